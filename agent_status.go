@@ -2,11 +2,13 @@ package main
 
 import (
 	"bytes"
+	"strings"
 	"time"
 )
 
 // Agent busy/idle detection via PTY output scanning.
-// Detects "esc to " pattern (case-insensitive) in terminal output
+// Detects the agent's busy pattern (case-insensitive, "esc to " for the
+// built-in agents) in terminal output
 // to determine if the agent is busy (thinking/processing).
 
 const (
@@ -25,84 +27,99 @@ const (
 	escOSCESC = 4 // In OSC, saw ESC (awaiting \ for ST terminator)
 )
 
-// busyPattern is the lowercase pattern to detect in PTY output
-var busyPattern = []byte("esc to ")
+// escapeFilter separates text from ANSI escape sequences (CSI, OSC) in a
+// stream of terminal output, keeping its state across chunks
+type escapeFilter struct {
+	state int
+	// Final byte of the CSI sequence ended by the last byte (e.g. 'H' for
+	// cursor position), 0 otherwise
+	csiFinal byte
+}
+
+// text consumes one byte and reports whether it is text, outside any escape sequence
+func (f *escapeFilter) text(b byte) bool {
+	f.csiFinal = 0
+	switch f.state {
+	case escNone:
+		if b == 0x1b {
+			f.state = escStart
+			return false
+		}
+		return true
+
+	case escStart:
+		// Byte right after ESC determines the sequence type
+		if b == '[' {
+			f.state = escCSI
+		} else if b == ']' {
+			f.state = escOSC
+		} else {
+			// Two-byte escape (e.g. ESC c, ESC M) — done
+			f.state = escNone
+		}
+
+	case escCSI:
+		// CSI: ESC [ (params) letter — letter terminates
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') {
+			f.state = escNone
+			f.csiFinal = b
+		}
+		// else: parameters (digits, ;, ?, etc.) — keep consuming
+
+	case escOSC:
+		// OSC: ESC ] ... terminated by BEL (0x07) or ST (ESC \)
+		if b == 0x07 {
+			f.state = escNone
+		} else if b == 0x1b {
+			f.state = escOSCESC
+		}
+		// else: OSC content — keep consuming
+
+	case escOSCESC:
+		// Inside OSC, saw ESC — if next is \, it's ST (end of OSC)
+		if b == '\\' {
+			f.state = escNone
+		} else {
+			// Not ST — treat as new escape sequence
+			if b == '[' {
+				f.state = escCSI
+			} else if b == ']' {
+				f.state = escOSC
+			} else {
+				f.state = escNone
+			}
+		}
+	}
+	return false
+}
 
-// initAgentStatus initializes the agent status detection fields
+// initAgentStatus initializes the agent status detection fields.
+// The busy pattern comes from the agent definition, lowercased.
 func (d *Daemon) initAgentStatus() {
 	d.agentStatusBuf = make([]byte, 0, agentStatusBufSize)
+	d.busyPattern = []byte(strings.ToLower(d.agent.BusyPattern))
 }
 
 // scanAgentStatus scans PTY output for the busy pattern.
 // Called from startPTYReader on every read — must be fast.
 func (d *Daemon) scanAgentStatus(data []byte) {
-	// Skip PTY scanning when receiving status via hook socket
-	if d.agentStatusViaSocket {
+	// Skip PTY scanning when receiving status via hook socket,
+	// or when the agent has no busy pattern
+	if d.agentStatusViaSocket || len(d.busyPattern) == 0 {
 		return
 	}
 
-	state := d.agentEscState
 	buf := d.agentStatusBuf
 
 	for _, b := range data {
-		switch state {
-		case escNone:
-			if b == 0x1b {
-				state = escStart
-				continue
-			}
-			// Only keep printable ASCII, lowercase on the fly
-			if b >= 0x20 && b <= 0x7e {
-				if b >= 'A' && b <= 'Z' {
-					b += 0x20
-				}
-				buf = append(buf, b)
-			}
-
-		case escStart:
-			// Byte right after ESC determines the sequence type
-			if b == '[' {
-				state = escCSI
-			} else if b == ']' {
-				state = escOSC
-			} else {
-				// Two-byte escape (e.g. ESC c, ESC M) — done
-				state = escNone
-			}
-
-		case escCSI:
-			// CSI: ESC [ (params) letter — letter terminates
-			if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') {
-				state = escNone
-			}
-			// else: parameters (digits, ;, ?, etc.) — keep consuming
-
-		case escOSC:
-			// OSC: ESC ] ... terminated by BEL (0x07) or ST (ESC \)
-			if b == 0x07 {
-				state = escNone
-			} else if b == 0x1b {
-				state = escOSCESC
-			}
-			// else: OSC content — keep consuming
-
-		case escOSCESC:
-			// Inside OSC, saw ESC — if next is \, it's ST (end of OSC)
-			if b == '\\' {
-				state = escNone
-			} else {
-				// Not ST — treat as new escape sequence
-				if b == '[' {
-					state = escCSI
-				} else if b == ']' {
-					state = escOSC
-				} else {
-					state = escNone
-				}
+		// Only keep printable ASCII, lowercase on the fly
+		if d.agentEsc.text(b) && b >= 0x20 && b <= 0x7e {
+			if b >= 'A' && b <= 'Z' {
+				b += 0x20
 			}
+			buf = append(buf, b)
 		}
 	}
-	d.agentEscState = state
 
 	// Trim buffer to max size (keep tail)
 	if len(buf) > agentStatusBufSize {
@@ -111,7 +128,7 @@ func (d *Daemon) scanAgentStatus(data []byte) {
 	d.agentStatusBuf = buf
 
 	// Check for pattern
-	found := bytes.Contains(buf, busyPattern)
+	found := bytes.Contains(buf, d.busyPattern)
 
 	if found {
 		// Agent is busy — notify immediately if state changed
@@ -121,7 +138,7 @@ func (d *Daemon) scanAgentStatus(data []byte) {
 		}
 		if !d.agentBusy {
 			d.agentBusy = true
-			d.sendControlMessage("agent-status:busy")
+			d.sendControl("agent-status", "", agentStatusPayload{Status: "busy"})
 		}
 	} else if d.agentBusy {
 		// Pattern gone — debounce before declaring idle
@@ -130,7 +147,7 @@ func (d *Daemon) scanAgentStatus(data []byte) {
 				d.agentBusy = false
 				d.agentIdleTimer = nil
 				d.agentStatusBuf = d.agentStatusBuf[:0]
-				d.sendControlMessage("agent-status:idle")
+				d.sendControl("agent-status", "", agentStatusPayload{Status: "idle"})
 			})
 		}
 	}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 )
 
 // AgentType represents known AI agent types
@@ -22,14 +26,129 @@ type AgentInfo struct {
 	Version string
 }
 
-// knownAgents lists all known AI terminal agents to detect
-var knownAgents = []struct {
-	command   string
-	agentType AgentType
-}{
-	{"claude", AgentClaude},
-	{"gemini", AgentGemini},
-	{"codex", AgentCodex},
+// AgentDefinition describes how to run and drive an agent.
+// Built-in agents can be overridden, and new ones added, in agents.json in the
+// config directory: a JSON array of definitions, matched by name.
+type AgentDefinition struct {
+	Name        string   `json:"name"`                   // Agent type reported to the app
+	Command     string   `json:"command"`                // Executable, looked up in PATH
+	Args        []string `json:"args,omitempty"`         // Default arguments
+	VersionArgs []string `json:"version_args,omitempty"` // Version probe (default: --version)
+	BusyPattern string   `json:"busy_pattern,omitempty"` // Output shown while busy, case-insensitive ("": no detection)
+	FileMention string   `json:"file_mention,omitempty"` // Inserted for uploaded files, {path} is the path (default: "{path} ")
+	Hooks       string   `json:"hooks,omitempty"`        // Hook installer (see hookInstallers), "" for none
+}
+
+// agentsFileName is the registry file in the config directory
+const agentsFileName = "agents.json"
+
+// fileMentionPlaceholder is replaced by the file path in FileMention. "%s" is
+// accepted too, as in agents.json files written for earlier versions.
+const fileMentionPlaceholder = "{path}"
+
+// builtinAgents are the agents known without configuration
+var builtinAgents = []AgentDefinition{
+	{Name: string(AgentClaude), Command: "claude", BusyPattern: "esc to ", Hooks: "claude"},
+	// Gemini: use @ prefix to reference files
+	{Name: string(AgentGemini), Command: "gemini", BusyPattern: "esc to ", FileMention: "@{path} ", Hooks: "gemini"},
+	// Codex: use /mention command
+	{Name: string(AgentCodex), Command: "codex", BusyPattern: "esc to ", FileMention: "/mention {path} ", Hooks: "codex"},
+}
+
+// hookInstallers install the hooks of an agent for a working directory, by
+// AgentDefinition.Hooks
+var hookInstallers = map[string]func(workDir string){
+	"claude": ensureClaudeHooksInstalled,
+	"gemini": ensureGeminiHooksInstalled,
+	"codex":  func(string) { ensureCodexHooksInstalled() }, // User-wide only
+}
+
+var (
+	registryOnce  sync.Once
+	agentRegistry []AgentDefinition
+)
+
+// registeredAgents returns the built-in agents merged with agents.json,
+// loaded once per process
+func registeredAgents() []AgentDefinition {
+	registryOnce.Do(func() {
+		agents, err := loadAgentRegistry()
+		if err != nil {
+			fmt.Printf("%sWarning: Ignoring %s: %v%s\n", yellow, agentsFileName, err, reset)
+		}
+		agentRegistry = agents
+	})
+	return agentRegistry
+}
+
+// loadAgentRegistry reads agents.json and merges it over the built-in agents.
+// On error the built-in agents are returned.
+func loadAgentRegistry() ([]AgentDefinition, error) {
+	agents := append([]AgentDefinition(nil), builtinAgents...)
+
+	dir, err := getConfigDir()
+	if err != nil {
+		return agents, nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, agentsFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return agents, nil
+		}
+		return agents, err
+	}
+
+	var custom []AgentDefinition
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return agents, err
+	}
+	merged, err := mergeAgentDefinitions(agents, custom)
+	if err != nil {
+		return append([]AgentDefinition(nil), builtinAgents...), err
+	}
+	return merged, nil
+}
+
+// mergeAgentDefinitions replaces agents with the same name and appends new ones
+func mergeAgentDefinitions(agents, custom []AgentDefinition) ([]AgentDefinition, error) {
+	for _, def := range custom {
+		if def.Name == "" {
+			return nil, fmt.Errorf("agent without name")
+		}
+		if def.Command == "" {
+			def.Command = def.Name
+		}
+		if def.Hooks != "" && hookInstallers[def.Hooks] == nil {
+			return nil, fmt.Errorf("agent %s: unknown hook installer %q", def.Name, def.Hooks)
+		}
+		if def.FileMention != "" && !validFileMention(def.FileMention) {
+			fmt.Printf("%sWarning: agent %s: file_mention %q must contain %s once, typing the path alone%s\n",
+				yellow, def.Name, def.FileMention, fileMentionPlaceholder, reset)
+			def.FileMention = ""
+		}
+
+		replaced := false
+		for i := range agents {
+			if agents[i].Name == def.Name {
+				agents[i] = def
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			agents = append(agents, def)
+		}
+	}
+	return agents, nil
+}
+
+// agentNames returns the names of the registered agents
+func agentNames() []string {
+	var names []string
+	for _, def := range registeredAgents() {
+		names = append(names, def.Name)
+	}
+	return names
 }
 
 func checkCommand(command string) (string, error) {
@@ -40,22 +159,66 @@ func checkCommand(command string) (string, error) {
 	return path, nil
 }
 
+// lookupAgent returns the definition of an agent type. Unknown types (any
+// command) get a plain definition: no default args, no busy detection, no hooks.
+func lookupAgent(agentType AgentType) AgentDefinition {
+	for _, def := range registeredAgents() {
+		if def.Name == string(agentType) {
+			return def
+		}
+	}
+	return AgentDefinition{Name: string(agentType), Command: string(agentType)}
+}
+
+// detectAgentType returns the registered agent a command runs, matched on the
+// executable name, or the executable name itself for unregistered commands
 func detectAgentType(command string) AgentType {
-	cmd := strings.ToLower(command)
-	switch {
-	case strings.Contains(cmd, "claude"):
-		return AgentClaude
-	case strings.Contains(cmd, "gemini"):
-		return AgentGemini
-	case strings.Contains(cmd, "codex"):
-		return AgentCodex
-	default:
-		return AgentClaude
+	base := strings.ToLower(filepath.Base(command))
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+
+	agents := registeredAgents()
+	for _, def := range agents {
+		if base == strings.ToLower(def.Command) || base == def.Name {
+			return AgentType(def.Name)
+		}
+	}
+	// Wrappers such as claude-dev or my-gemini: the agent name is one of the
+	// words of the executable name
+	words := strings.FieldsFunc(base, func(r rune) bool { return r == '-' || r == '_' || r == '.' })
+	for _, def := range agents {
+		for _, word := range words {
+			if word == def.Name {
+				return AgentType(def.Name)
+			}
+		}
+	}
+	return AgentType(base)
+}
+
+// validFileMention reports whether a file mention template has exactly one
+// path placeholder
+func validFileMention(template string) bool {
+	return strings.Count(template, fileMentionPlaceholder)+strings.Count(template, "%s") == 1
+}
+
+// fileMention returns the text inserted in the agent's input for an uploaded file
+func (def AgentDefinition) fileMention(filePath string) string {
+	if def.FileMention == "" {
+		// Claude and others: just output the path
+		return filePath + " "
+	}
+	if strings.Contains(def.FileMention, fileMentionPlaceholder) {
+		return strings.Replace(def.FileMention, fileMentionPlaceholder, filePath, 1)
 	}
+	return strings.Replace(def.FileMention, "%s", filePath, 1)
 }
 
 func getAgentVersion(command string, agentType AgentType) string {
-	cmd := exec.Command(command, "--version")
+	args := lookupAgent(agentType).VersionArgs
+	if len(args) == 0 {
+		args = []string{"--version"}
+	}
+	cmd := exec.Command(command, args...)
 	output, err := cmd.Output()
 	if err != nil {
 		return ""
@@ -79,12 +242,13 @@ func getAgentVersion(command string, agentType AgentType) string {
 func detectAvailableAgents() []AgentInfo {
 	var available []AgentInfo
 
-	for _, agent := range knownAgents {
-		if _, err := exec.LookPath(agent.command); err == nil {
-			version := getAgentVersion(agent.command, agent.agentType)
+	for _, agent := range registeredAgents() {
+		if _, err := exec.LookPath(agent.Command); err == nil {
+			agentType := AgentType(agent.Name)
+			version := getAgentVersion(agent.Command, agentType)
 			available = append(available, AgentInfo{
-				Command: agent.command,
-				Type:    agent.agentType,
+				Command: agent.Command,
+				Type:    agentType,
 				Version: version,
 			})
 		}
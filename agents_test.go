@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// useAgentRegistry loads the registry from a temporary config directory
+// holding the given agents.json ("" for none)
+func useAgentRegistry(t *testing.T, agentsJSON string) {
+	t.Helper()
+	previous := customConfigDir
+	customConfigDir = t.TempDir()
+	if agentsJSON != "" {
+		os.WriteFile(filepath.Join(customConfigDir, agentsFileName), []byte(agentsJSON), 0600)
+	}
+	registryOnce = sync.Once{}
+	t.Cleanup(func() {
+		customConfigDir = previous
+		registryOnce = sync.Once{}
+	})
+}
+
+func TestDetectAgentType_Builtin(t *testing.T) {
+	useAgentRegistry(t, "")
+
+	cases := map[string]AgentType{
+		"claude":                "claude",
+		"/usr/local/bin/gemini": "gemini",
+		"codex.exe":             "codex",
+		"claude-dev":            "claude",
+		"my_gemini":             "gemini",
+		"claudette":             "claudette", // Not a claude wrapper
+		"bash":                  "bash",      // No longer defaults to Claude
+	}
+	for command, want := range cases {
+		if got := detectAgentType(command); got != want {
+			t.Errorf("detectAgentType(%q) = %q, want %q", command, got, want)
+		}
+	}
+
+	if lookupAgent("bash").BusyPattern != "" || lookupAgent("bash").Hooks != "" {
+		t.Error("unregistered commands must not get busy detection or hooks")
+	}
+}
+
+func TestAgentRegistry_ConfigFile(t *testing.T) {
+	useAgentRegistry(t, `[
+		{"name": "aider", "args": ["--no-auto-commits"], "busy_pattern": "Thinking", "file_mention": "/add %s\n"},
+		{"name": "gemini", "command": "gemini-beta", "file_mention": "@{path} "},
+		{"name": "broken", "file_mention": "%s and %s %d"}
+	]`)
+
+	aider := lookupAgent(detectAgentType("aider"))
+	if aider.Command != "aider" || len(aider.Args) != 1 || aider.BusyPattern != "Thinking" {
+		t.Fatalf("unexpected aider definition %+v", aider)
+	}
+	if got := aider.fileMention("/tmp/a.png"); got != "/add /tmp/a.png\n" {
+		t.Fatalf("unexpected file mention %q", got)
+	}
+
+	if got := lookupAgent("gemini").fileMention("/tmp/50%.png"); got != "@/tmp/50%.png " {
+		t.Fatalf("unexpected file mention %q", got)
+	}
+	if got := lookupAgent("broken").fileMention("/tmp/a.png"); got != "/tmp/a.png " {
+		t.Fatalf("invalid file mention used: %q", got)
+	}
+
+	if detectAgentType("gemini-beta") != "gemini" {
+		t.Fatal("expected overridden gemini command to be detected")
+	}
+	if lookupAgent("claude").Hooks != "claude" {
+		t.Fatal("built-in agents must be kept")
+	}
+
+	d := &Daemon{agent: aider}
+	d.initAgentStatus()
+	if string(d.busyPattern) != "thinking" {
+		t.Fatalf("busy pattern must be lowercased, got %q", d.busyPattern)
+	}
+}
+
+func TestAgentRegistry_InvalidFileKeepsBuiltins(t *testing.T) {
+	useAgentRegistry(t, `[{"name": "x", "hooks": "nope"}]`)
+
+	if len(registeredAgents()) != len(builtinAgents) {
+		t.Fatalf("expected built-in agents only, got %+v", registeredAgents())
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Remote tool approval.
+// Claude Code's PreToolUse hook runs `aipilot-cli --agent-event` synchronously.
+// When the user is driving the session from the phone, the hook sends an
+// "approval_request" on the hook socket and waits for the answer:
+//
+//   hook   -> daemon: {"event":"approval_request","data":<ToolUseData>}
+//   daemon -> mobile: approval-request {id, tool, command, summary, timeout}
+//   mobile -> daemon: approval-response {id, decision: "allow"|"deny"}
+//   daemon -> hook:   {"decision":"allow"}
+//
+// Without an answer within ApprovalTimeout (or when nobody is on the phone)
+// the decision is empty: the hook outputs nothing and Claude asks in the
+// terminal as usual. The hook fires for every tool: calls Claude runs without
+// asking, or refuses, are not sent (see wouldPrompt).
+
+// Claude Code permission modes that skip some prompts
+const (
+	permissionModeAcceptEdits = "acceptEdits"
+	permissionModeBypass      = "bypassPermissions"
+)
+
+// readOnlyTools never ask for permission
+var readOnlyTools = map[string]bool{
+	"Read": true, "Glob": true, "Grep": true, "LS": true, "NotebookRead": true,
+	"TodoWrite": true, "Task": true, "BashOutput": true,
+}
+
+// editTools don't ask for permission in acceptEdits mode
+var editTools = map[string]bool{
+	"Edit": true, "MultiEdit": true, "Write": true, "NotebookEdit": true,
+}
+
+// ApprovalDecision is the daemon's answer to an "approval_request" hook message
+type ApprovalDecision struct {
+	Decision string `json:"decision"` // "allow", "deny", or "" to ask in the terminal
+	Reason   string `json:"reason,omitempty"`
+}
+
+// approvalRequestPayload asks mobile to allow or deny a tool call
+type approvalRequestPayload struct {
+	toolUsePayload
+	ID      string `json:"id"`
+	Timeout int    `json:"timeout"` // Seconds before falling back to the terminal
+}
+
+// approvalResponseRequest is mobile's decision
+type approvalResponseRequest struct {
+	ID       string `json:"id"`
+	Decision string `json:"decision"` // "allow" or "deny"
+	Reason   string `json:"reason,omitempty"`
+}
+
+// approvalCancelPayload withdraws a request that timed out
+type approvalCancelPayload struct {
+	ID string `json:"id"`
+}
+
+// claudePermissions are the permission rules of Claude Code settings:
+// "permissions": {"allow": [...], "ask": [...], "deny": [...]}
+type claudePermissions struct {
+	Allow []string `json:"allow"`
+	Ask   []string `json:"ask"`
+	Deny  []string `json:"deny"`
+}
+
+// loadClaudePermissions merges the rules of the user settings and of the
+// shared and local settings of a project. Missing or invalid files add none.
+func loadClaudePermissions(projectDir string) claudePermissions {
+	paths := []string{claudeSettingsPath()}
+	if projectDir != "" {
+		paths = append(paths, filepath.Join(projectDir, ".claude", "settings.json"),
+			claudeProjectSettingsPath(projectDir))
+	}
+
+	var rules claudePermissions
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var settings struct {
+			Permissions claudePermissions `json:"permissions"`
+		}
+		if json.Unmarshal(data, &settings) != nil {
+			continue
+		}
+		rules.Allow = append(rules.Allow, settings.Permissions.Allow...)
+		rules.Ask = append(rules.Ask, settings.Permissions.Ask...)
+		rules.Deny = append(rules.Deny, settings.Permissions.Deny...)
+	}
+	return rules
+}
+
+// claudeProjectDir returns the project of a Claude hook: Claude passes it to
+// hooks in CLAUDE_PROJECT_DIR, the payload's cwd is the fallback
+func claudeProjectDir(p hookPayload) string {
+	if dir := os.Getenv("CLAUDE_PROJECT_DIR"); dir != "" {
+		return dir
+	}
+	return p.Cwd
+}
+
+// wouldPrompt reports whether Claude would ask before running the tool call
+// of a PreToolUse payload, given the permission rules of its settings. Deny
+// rules win over ask rules, which win over allow rules.
+func (p hookPayload) wouldPrompt(rules claudePermissions) bool {
+	switch {
+	case p.PermissionMode == permissionModeBypass:
+		return false
+	case p.matchesAnyRule(rules.Deny, false):
+		return false // Refused without asking
+	case p.matchesAnyRule(rules.Ask, false):
+		return true
+	case readOnlyTools[p.ToolName], p.matchesAnyRule(rules.Allow, true):
+		return false
+	case p.PermissionMode == permissionModeAcceptEdits && editTools[p.ToolName]:
+		return false
+	}
+	return true
+}
+
+// matchesAnyRule reports whether the tool call matches one of the rules.
+// With allow, a Bash command chaining several commands matches no specifier:
+// Claude checks each of them and may still ask.
+func (p hookPayload) matchesAnyRule(rules []string, allow bool) bool {
+	for _, rule := range rules {
+		if p.matchesRule(strings.TrimSpace(rule), allow) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesRule reports whether the tool call matches a permission rule: "Tool"
+// for every call of the tool, "mcp__server" for every tool of an MCP server,
+// "Bash(npm test)" for a command, "Bash(go test:*)" for commands starting with
+// "go test", "WebFetch(domain:example.com)" for a host. Path rules of the file
+// tools are not evaluated: they match nothing, and the phone is asked.
+func (p hookPayload) matchesRule(rule string, allow bool) bool {
+	tool, specifier, found := strings.Cut(rule, "(")
+	if !found {
+		return rule == p.ToolName ||
+			strings.HasPrefix(rule, "mcp__") && strings.HasPrefix(p.ToolName, rule+"__")
+	}
+	specifier, closed := strings.CutSuffix(specifier, ")")
+	if tool != p.ToolName || !closed {
+		return false
+	}
+
+	switch tool {
+	case "Bash":
+		command, _ := p.ToolInput["command"].(string)
+		command = strings.TrimSpace(command)
+		if allow && (strings.ContainsAny(command, "&|;`\n") || strings.Contains(command, "$(")) {
+			return false
+		}
+		if prefix, ok := strings.CutSuffix(specifier, ":*"); ok {
+			return strings.HasPrefix(command, prefix)
+		}
+		return command == specifier
+	case "WebFetch":
+		domain, ok := strings.CutPrefix(specifier, "domain:")
+		raw, _ := p.ToolInput["url"].(string)
+		u, err := url.Parse(raw)
+		return ok && err == nil && strings.EqualFold(u.Hostname(), domain)
+	}
+	return false
+}
+
+// approvalWanted reports whether tool calls should be approved from the phone:
+// the mobile is connected, is the active client, supports approvals and may
+// send input
+func (d *Daemon) approvalWanted() bool {
+	d.mu.RLock()
+	onMobile := d.currentClient == "mobile"
+	d.mu.RUnlock()
+	return onMobile && d.isMobileConnected() && d.mobileHasCapability("tool-approval") &&
+		d.mobileInputRefusal() == ""
+}
+
+// handleApprovalRequest asks mobile to approve a tool call and writes the
+// decision back to the waiting hook
+func (d *Daemon) handleApprovalRequest(conn net.Conn, data json.RawMessage) {
+	decision := ApprovalDecision{}
+	defer func() {
+		encoded, _ := json.Marshal(decision)
+		fmt.Fprintf(conn, "%s\n", encoded)
+	}()
+
+	var tool ToolUseData
+	if err := json.Unmarshal(data, &tool); err != nil || !d.approvalWanted() {
+		return
+	}
+	for i, path := range tool.FilePaths {
+		tool.FilePaths[i] = d.relativePath(path)
+	}
+
+	id := generateRandomToken()
+	ch := make(chan ApprovalDecision, 1)
+	d.approvalMu.Lock()
+	if d.approvals == nil {
+		d.approvals = make(map[string]chan ApprovalDecision)
+	}
+	d.approvals[id] = ch
+	d.approvalMu.Unlock()
+	defer func() {
+		d.approvalMu.Lock()
+		delete(d.approvals, id)
+		d.approvalMu.Unlock()
+	}()
+
+	summary := toolSummary(tool)
+	d.sendControl("approval-request", "", approvalRequestPayload{
+		toolUsePayload: toolUsePayload{ToolUseData: tool, Summary: summary},
+		ID:             id,
+		Timeout:        int(ApprovalTimeout / time.Second),
+	})
+	fmt.Printf("%s[hook] Waiting for approval on mobile: %s%s\n", dim, summary, reset)
+
+	select {
+	case decision = <-ch:
+		fmt.Printf("%s[hook] Mobile decision: %s%s\n", dim, decision.Decision, reset)
+	case <-time.After(ApprovalTimeout):
+		d.sendControl("approval-cancel", "", approvalCancelPayload{ID: id})
+		fmt.Printf("%s[hook] No decision from mobile, asking in the terminal%s\n", dim, reset)
+	}
+}
+
+// resolveApproval delivers mobile's decision to the waiting request
+func (d *Daemon) resolveApproval(reqID string, req approvalResponseRequest) {
+	if req.Decision != "allow" && req.Decision != "deny" {
+		d.sendControlError("approval-response", reqID, ErrCodeInvalidRequest, "Decision must be allow or deny")
+		return
+	}
+
+	d.approvalMu.Lock()
+	ch, ok := d.approvals[req.ID]
+	d.approvalMu.Unlock()
+	if !ok {
+		d.sendControlError("approval-response", reqID, ErrCodeNotFound, "No pending approval "+req.ID)
+		return
+	}
+
+	select {
+	case ch <- ApprovalDecision{Decision: req.Decision, Reason: req.Reason}:
+	default:
+		// Already decided
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Command key: a tmux-style prefix typed on the PC keyboard opens an AIPilot
+// command prompt over the agent, whatever it shows. Typed twice, the key
+// itself is sent to the agent. It is set by "command_key" in config.json
+// ("ctrl+a" to "ctrl+z"), off by default: it takes a key from the agent.
+// AIPilot commands typed alone on a line work either way, as on mobile.
+
+// DefaultCommandKey is the command key when config.json doesn't set one
+const DefaultCommandKey = "off"
+
+// commandKey is the command key byte, 0 when off
+var commandKey byte
+
+// parseCommandKey converts a "ctrl+<letter>" setting to its control byte.
+// "off" returns 0.
+func parseCommandKey(spec string) (byte, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if spec == "off" {
+		return 0, nil
+	}
+	letter := strings.TrimPrefix(spec, "ctrl+")
+	if letter == spec || len(letter) != 1 || letter[0] < 'a' || letter[0] > 'z' {
+		return 0, fmt.Errorf("invalid command key %q (expected ctrl+a to ctrl+z, or off)", spec)
+	}
+
+	key := letter[0] - 'a' + 1
+	switch key {
+	case 0x03, 0x08, 0x09, 0x0a, 0x0d:
+		// Ctrl+C closes panels; Backspace, Tab and Enter are typed with these
+		return 0, fmt.Errorf("command key %q is reserved", spec)
+	}
+	return key, nil
+}
+
+// commandKeyName returns the display name of a command key, such as "Ctrl+A"
+func commandKeyName(key byte) string {
+	return "Ctrl+" + string(rune('A'+key-1))
+}
+
+// commandHint returns how to type an AIPilot command on the PC, such as
+// "/qr", or "Ctrl+A /qr" with a command key
+func commandHint(name string) string {
+	if commandKey == 0 {
+		return "/" + name
+	}
+	return commandKeyName(commandKey) + " /" + name
+}
+
+// setCommandKey applies the command_key setting of config.json
+func setCommandKey(spec string) {
+	if spec == "" {
+		spec = DefaultCommandKey
+	}
+	key, err := parseCommandKey(spec)
+	if err != nil {
+		fmt.Printf("%sWarning: %v, using %s%s\n", yellow, err, DefaultCommandKey, reset)
+		return
+	}
+	commandKey = key
+}
+
+// commandMode prompts for an AIPilot command in the alternate screen, after
+// the command key was typed. Runs on the stdin reader goroutine.
+func (d *Daemon) commandMode() {
+	d.openLocalPanel()
+	showPrompt := func(message string) {
+		fmt.Print(clearScreen + cursorHome)
+		printRaw("%s", helpPanel())
+		if message != "" {
+			printRaw("\n  %s%s%s\n", red, message, reset)
+		}
+		printRaw("\n%sEnter to run, ESC to cancel, %s again sends it to the agent%s\n> /",
+			dim, commandKeyName(commandKey), reset)
+	}
+	showPrompt("")
+
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if err != nil || n == 0 {
+			d.closeLocalPanel()
+			return
+		}
+
+		switch char := b[0]; {
+		case char == commandKey:
+			d.closeLocalPanel()
+			d.sendToPTY(b)
+			return
+
+		case char == 0x1b || char == 0x03: // ESC or Ctrl+C
+			d.closeLocalPanel()
+			return
+
+		case char == '\r' || char == '\n':
+			text := strings.TrimSpace(string(line))
+			if text == "" {
+				d.closeLocalPanel()
+				return
+			}
+			cmd, arg := d.getAIPilotCommand("/" + strings.TrimPrefix(text, "/"))
+			if cmd == "" {
+				showPrompt("Unknown command: " + text)
+				line = line[:0]
+				continue
+			}
+			// The command's panel takes over the alternate screen
+			d.executeAIPilotCommand(cmd, arg, "pc")
+			return
+
+		case char == 127 || char == 8: // Backspace
+			if len(line) > 0 {
+				line = trimLastRune(line)
+				fmt.Print("\b \b")
+			}
+
+		case char >= 32:
+			line = append(line, char)
+			os.Stdout.Write(b)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestParseCommandKey(t *testing.T) {
+	tests := []struct {
+		spec string
+		key  byte
+		ok   bool
+	}{
+		{"ctrl+a", 0x01, true},
+		{" Ctrl+B ", 0x02, true},
+		{"ctrl+z", 0x1a, true},
+		{"off", 0, true},
+		{"ctrl+c", 0, false}, // Closes panels
+		{"ctrl+m", 0, false}, // Enter
+		{"ctrl+1", 0, false},
+		{"a", 0, false},
+		{"ctrl+ab", 0, false},
+	}
+	for _, tt := range tests {
+		key, err := parseCommandKey(tt.spec)
+		if key != tt.key || (err == nil) != tt.ok {
+			t.Errorf("%q: got (%#x, %v), want %#x ok=%v", tt.spec, key, err, tt.key, tt.ok)
+		}
+	}
+}
+
+func TestCommandHint(t *testing.T) {
+	previous := commandKey
+	defer func() { commandKey = previous }()
+
+	setCommandKey("")
+	if got := commandHint("qr"); got != "/qr" {
+		t.Fatalf("default: got %q", got)
+	}
+	setCommandKey("ctrl+g")
+	if got := commandHint("qr"); got != "Ctrl+G /qr" {
+		t.Fatalf("ctrl+g: got %q", got)
+	}
+	setCommandKey("off")
+	if got := commandHint("qr"); got != "/qr" {
+		t.Fatalf("off: got %q", got)
+	}
+	setCommandKey("ctrl+c") // Invalid: keeps the current key
+	if commandKey != 0 {
+		t.Fatalf("invalid key applied: %#x", commandKey)
+	}
+}
@@ -1,14 +1,13 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"net"
 	"runtime"
 )
 
 // sendCLIInfo sends CLI information to mobile
-func (d *Daemon) sendCLIInfo() {
+func (d *Daemon) sendCLIInfo(reqID string) {
 	// Gather all non-loopback IPv4 addresses for mobile connectivity
 	var ips []string
 	if addrs, err := net.InterfaceAddrs(); err == nil {
@@ -36,27 +35,28 @@ func (d *Daemon) sendCLIInfo() {
 		"ssh_running": sshInfo.Available,
 		"ssh_port":    sshInfo.Port,
 		"ips":         ips,
+		// Capability negotiation: the mobile answers with its own in mobile-info
+		"protocol_version": ControlProtocolVersion,
+		"capabilities":     d.cliCapabilities(),
 	}
-
-	infoJSON, err := json.Marshal(info)
-	if err != nil {
-		return
+	if lanURL := d.getLANURL(); lanURL != "" {
+		info["lan_url"] = lanURL
 	}
 
-	d.sendControlMessage("cli-info:" + string(infoJSON))
+	d.sendControl("cli-info", reqID, info)
 }
 
-// handleMobileInfo processes mobile-info control message and checks app version compatibility
-func (d *Daemon) handleMobileInfo(args string) {
-	var info struct {
-		AppVersion string `json:"app_version"`
-	}
-	if err := json.Unmarshal([]byte(args), &info); err != nil {
-		fmt.Printf("%s[mobile-info] Invalid JSON: %v%s\n", dim, err, reset)
-		return
+// handleMobileInfo processes mobile-info control message: it negotiates the
+// control protocol and checks app version compatibility
+func (d *Daemon) handleMobileInfo(info mobileInfoRequest) {
+	// Apps without protocol_version only speak legacy strings
+	version := info.ProtocolVersion
+	if version < 1 {
+		version = 1
 	}
+	d.setMobileProtocol(version, info.Capabilities)
 
-	fmt.Printf("%s[mobile-info] App version: %s%s\n", dim, info.AppVersion, reset)
+	fmt.Printf("%s[mobile-info] App version: %s (protocol v%d)%s\n", dim, info.AppVersion, d.mobileProtocolVersion(), reset)
 
 	appVer, err := parseSemver(info.AppVersion)
 	if err != nil {
@@ -76,11 +76,7 @@ func (d *Daemon) handleMobileInfo(args string) {
 			"cli_version":     Version,
 			"message":         fmt.Sprintf("Please update the app to at least v%s", MinAppVersion),
 		}
-		msgJSON, err := json.Marshal(msg)
-		if err != nil {
-			return
-		}
-		d.sendControlMessage("update-required:" + string(msgJSON))
+		d.sendControl("update-required", "", msg)
 	}
 }
 
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Command palette: AIPilot commands typed alone on a line, on the PC keyboard
+// or in mobile input, are intercepted instead of reaching the agent. Their
+// output is shown in the alternate screen of the terminal they were typed in
+// (the local terminal or the mobile's); agent output is held back meanwhile
+// and the screen is repainted from the screen model when the panel closes.
+
+// aipilotCommand describes an intercepted command
+type aipilotCommand struct {
+	name string
+	args string // Argument syntax, empty when the command takes none
+	help string
+}
+
+// aipilotCommands lists the intercepted commands, in /help order
+var aipilotCommands = []aipilotCommand{
+	{"status", "", "Relay, mobile and encryption state"},
+	{"session", "", "Session ID, token fingerprint and uptime"},
+	{"mobiles", "", "Paired mobile devices"},
+	{"unpair", "<id>", "Unpair a mobile device (ID or its first 8 characters), on the PC"},
+	{"kick", "", "Disconnect the connected mobile"},
+	{"lock", "", "Make mobile view-only (type /lock again on the PC to unlock)"},
+	{"viewonly", "<id> [off]", "Make a mobile device view-only, or give it back control, on the PC"},
+	{"qr", "[view-only]", "Pair a new mobile device"},
+	{"help", "", "This list"},
+}
+
+// pcOnlyCommands change the pairings: a mobile must not lift its own
+// restriction or cut off another mobile
+var pcOnlyCommands = map[string]bool{"unpair": true, "viewonly": true}
+
+// Hints shown under a panel
+const (
+	localPanelHint  = "Press ESC or Ctrl+C to close"
+	mobilePanelHint = "Press any key to close"
+)
+
+// getAIPilotCommand checks if a line is an AIPilot command and returns the
+// command name and its argument
+func (d *Daemon) getAIPilotCommand(line string) (string, string) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	name = strings.ToLower(name)
+	arg = strings.TrimSpace(arg)
+	for _, c := range aipilotCommands {
+		// A command that takes no argument is left to the agent with one
+		if "/"+c.name == name && (arg == "" || c.args != "") {
+			return c.name, arg
+		}
+	}
+	return "", ""
+}
+
+// executeAIPilotCommand runs an AIPilot command typed on source ("pc" or "mobile")
+func (d *Daemon) executeAIPilotCommand(cmd, arg, source string) {
+	if cmd == "qr" && (arg == "" || arg == "view-only") {
+		d.showPairingQRInAltScreen(arg == "view-only")
+		return
+	}
+	if pcOnlyCommands[cmd] && source != "pc" {
+		d.showMobilePanel(pcOnlyPanel(cmd))
+		return
+	}
+
+	var text string
+	switch cmd {
+	case "status":
+		text = d.statusPanel()
+	case "session":
+		text = d.sessionPanel()
+	case "mobiles":
+		text = d.mobilesPanel()
+	case "unpair":
+		text = d.unpairPanel(arg)
+	case "kick":
+		text = d.kickPanel()
+	case "lock":
+		text = d.lockPanel()
+	case "viewonly":
+		text = d.viewOnlyPanel(arg)
+	default:
+		text = helpPanel()
+	}
+
+	if source == "mobile" {
+		d.showMobilePanel(text)
+	} else {
+		d.showPanelInAltScreen(func(func()) { printRaw("%s", text) })
+	}
+}
+
+// showPanelInAltScreen shows a panel in the local alternate screen until ESC
+// or Ctrl+C. render prints the panel in raw mode; it may call done to close
+// the panel without a key (pairing completed).
+func (d *Daemon) showPanelInAltScreen(render func(done func())) {
+	d.openLocalPanel()
+	fmt.Print(hideCursor)
+
+	closed := make(chan bool, 1)
+	closePanel := func() {
+		select {
+		case closed <- true:
+		default: // Already closing
+		}
+	}
+	render(closePanel)
+	printRaw("\n%s%s%s\n", dim, localPanelHint, reset)
+
+	// Read keys in a goroutine, only exit on ESC or Ctrl+C
+	// Use atomic flag to signal goroutine to stop
+	var shouldExit int32
+	go func() {
+		b := make([]byte, 1)
+		for {
+			n, err := os.Stdin.Read(b)
+			if err != nil || n == 0 {
+				return
+			}
+
+			// Check if we should exit (closed without a key)
+			if atomic.LoadInt32(&shouldExit) != 0 {
+				// Forward this key to PTY instead of discarding
+				d.sendToPTY(b[:n])
+				return
+			}
+
+			// ESC (0x1b) or Ctrl+C (0x03) to exit
+			if b[0] == 0x1b || b[0] == 0x03 {
+				closePanel()
+				return
+			}
+			// Ignore all other keys while the panel is shown
+		}
+	}()
+	<-closed
+
+	// Signal goroutine to stop intercepting keys
+	atomic.StoreInt32(&shouldExit, 1)
+
+	d.closeLocalPanel()
+}
+
+// openLocalPanel switches the local terminal to a cleared alternate screen
+// and holds back agent output
+func (d *Daemon) openLocalPanel() {
+	d.outputMu.Lock()
+	d.localPanel = true
+	d.outputMu.Unlock()
+	fmt.Print(altScreenOn + clearScreen + cursorHome)
+}
+
+// closeLocalPanel restores the main screen and shows the cursor, with the
+// output held back meanwhile
+func (d *Daemon) closeLocalPanel() {
+	d.outputMu.Lock()
+	defer d.outputMu.Unlock()
+	d.localPanel = false
+	fmt.Print(showCursor + altScreenOff)
+	if d.screen != nil {
+		os.Stdout.WriteString(d.screen.Snapshot().ANSI)
+	}
+}
+
+// showMobilePanel shows a panel in the mobile's alternate screen. The next
+// key typed on mobile closes it (see closeMobilePanel).
+func (d *Daemon) showMobilePanel(text string) {
+	d.outputMu.Lock()
+	defer d.outputMu.Unlock()
+
+	d.mobilePanel = true
+	panel := altScreenOn + clearScreen + cursorHome + hideCursor +
+		text + "\n" + dim + mobilePanelHint + reset
+	d.sendToMobile([]byte(strings.ReplaceAll(panel, "\n", "\r\n")))
+}
+
+// closeMobilePanel closes the panel shown on mobile, if any, and repaints the
+// agent screen there. Returns false when no panel was shown.
+func (d *Daemon) closeMobilePanel() bool {
+	d.outputMu.Lock()
+	defer d.outputMu.Unlock()
+
+	if !d.mobilePanel {
+		return false
+	}
+	d.mobilePanel = false
+	restore := showCursor + altScreenOff
+	if d.screen != nil {
+		restore += d.screen.Snapshot().ANSI
+	}
+	d.sendToMobile([]byte(restore))
+	return true
+}
+
+// panelTitle returns the title line of a panel
+func panelTitle(title string) string {
+	return fmt.Sprintf("%s%s=== %s ===%s\n\n", bold, cyan, title, reset)
+}
+
+// pcOnlyPanel refuses a command typed on mobile that only the PC may run
+func pcOnlyPanel(cmd string) string {
+	var b strings.Builder
+	b.WriteString(panelTitle("/" + cmd))
+	fmt.Fprintf(&b, "  %sPaired mobiles are only managed from the PC: type /%s there.%s\n", red, cmd, reset)
+	return b.String()
+}
+
+// helpPanel lists the AIPilot commands
+func helpPanel() string {
+	var b strings.Builder
+	b.WriteString(panelTitle("AIPilot Commands"))
+	for _, c := range aipilotCommands {
+		usage := "/" + c.name
+		if c.args != "" {
+			usage += " " + c.args
+		}
+		fmt.Fprintf(&b, "  %s%-20s%s %s\n", cyan, usage, reset, c.help)
+	}
+	if commandKey != 0 {
+		fmt.Fprintf(&b, "\n  %sOn the PC, press %s and type the command. On mobile, type it alone on a line.%s\n",
+			dim, commandKeyName(commandKey), reset)
+	} else {
+		fmt.Fprintf(&b, "\n  %sType a command alone on a line, on the PC or on mobile.%s\n", dim, reset)
+	}
+	return b.String()
+}
+
+// statusPanel shows the relay, mobile and encryption state
+func (d *Daemon) statusPanel() string {
+	d.mu.RLock()
+	relayConnected := d.relayConnected
+	viaRelay := d.mobileConnected
+	viaLAN := d.lanConn != nil
+	slot := d.activeKeySlotLocked()
+	keys := slot.keys
+	revoked := slot.revoked
+	strict := d.strict
+	locked := d.inputLocked
+	busy := d.agentBusy
+	d.mu.RUnlock()
+
+	var b strings.Builder
+	b.WriteString(panelTitle("AIPilot Status"))
+
+	if relayConnected {
+		fmt.Fprintf(&b, "  Relay:       %sconnected%s (%s)\n", green, reset, d.relay)
+	} else {
+		fmt.Fprintf(&b, "  Relay:       %sdisconnected%s (%s)\n", red, reset, d.relay)
+	}
+	if lanURL := d.getLANURL(); lanURL != "" {
+		fmt.Fprintf(&b, "  LAN:         %s\n", lanURL)
+	}
+
+	switch {
+	case revoked:
+		fmt.Fprintf(&b, "  Mobile:      %scut off%s (unpaired or kicked)\n", yellow, reset)
+	case viaLAN:
+		fmt.Fprintf(&b, "  Mobile:      %sconnected directly%s (LAN)\n", green, reset)
+	case viaRelay:
+		fmt.Fprintf(&b, "  Mobile:      %sconnected%s via the relay\n", green, reset)
+	default:
+		fmt.Fprintf(&b, "  Mobile:      %snot connected%s\n", dim, reset)
+	}
+
+	switch {
+	case keys != nil:
+		name := keys.mobileID
+		if mobile := d.currentPairedMobile(keys.mobileID); mobile != nil {
+			name = mobile.Name
+		}
+		fmt.Fprintf(&b, "  Encryption:  %ssession keys%s with %s (X25519, since %s)\n",
+			green, reset, name, keys.established.Format("15:04:05"))
+	case strict:
+		fmt.Fprintf(&b, "  Encryption:  strict, waiting for a key exchange\n")
+	default:
+		fmt.Fprintf(&b, "  Encryption:  %ssession token key%s (legacy app)\n", yellow, reset)
+	}
+
+	if locked {
+		fmt.Fprintf(&b, "  Input:       %slocked%s, mobile is view-only\n", yellow, reset)
+	} else if d.mobileInputRefusal() != "" {
+		fmt.Fprintf(&b, "  Input:       PC only, %sthe mobile is view-only%s\n", yellow, reset)
+	} else {
+		fmt.Fprintf(&b, "  Input:       PC and mobile\n")
+	}
+	if busy {
+		fmt.Fprintf(&b, "  Agent:       busy\n")
+	} else {
+		fmt.Fprintf(&b, "  Agent:       idle\n")
+	}
+	return b.String()
+}
+
+// sessionPanel shows the session identity and uptime
+func (d *Daemon) sessionPanel() string {
+	d.mu.RLock()
+	session, token, started := d.session, d.token, d.startedAt
+	recorder, conversation := d.recorder, d.conversation
+	d.mu.RUnlock()
+
+	var b strings.Builder
+	b.WriteString(panelTitle("AIPilot Session"))
+	fmt.Fprintf(&b, "  Session:     %s\n", session)
+	fmt.Fprintf(&b, "  Token:       %s (SHA-256 fingerprint)\n", tokenFingerprint(token))
+	fmt.Fprintf(&b, "  Agent:       %s\n", strings.Join(append([]string{d.command}, d.args...), " "))
+	fmt.Fprintf(&b, "  Directory:   %s\n", d.workDir)
+	if !started.IsZero() {
+		fmt.Fprintf(&b, "  Started:     %s (up %s)\n",
+			started.Format("2006-01-02 15:04:05"), time.Since(started).Round(time.Second))
+	}
+	if path := recorder.Path(); path != "" {
+		fmt.Fprintf(&b, "  Recording:   %s\n", path)
+	}
+	if path := conversation.Path(); path != "" {
+		fmt.Fprintf(&b, "  Log:         %s\n", path)
+	}
+	return b.String()
+}
+
+// tokenFingerprint identifies a session token without revealing it
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	hexSum := hex.EncodeToString(sum[:8])
+	var parts []string
+	for i := 0; i < len(hexSum); i += 4 {
+		parts = append(parts, hexSum[i:i+4])
+	}
+	return strings.Join(parts, ":")
+}
+
+// mobilesPanel lists the paired mobiles
+func (d *Daemon) mobilesPanel() string {
+	d.mu.RLock()
+	connectedID := ""
+	if keys := d.activeKeySlotLocked().keys; keys != nil {
+		connectedID = keys.mobileID
+	}
+	d.mu.RUnlock()
+
+	var b strings.Builder
+	b.WriteString(panelTitle("Paired Mobiles"))
+	config := d.currentPCConfig()
+	if config == nil || len(config.PairedMobiles) == 0 {
+		fmt.Fprintf(&b, "  %sNo paired mobile devices%s\n", dim, reset)
+		fmt.Fprintf(&b, "\n  Type %s/qr%s to pair a mobile device.\n", cyan, reset)
+		return b.String()
+	}
+	for _, mobile := range config.PairedMobiles {
+		fmt.Fprintf(&b, "  %s✓%s %s", green, reset, mobile.Name)
+		if mobile.ViewOnly {
+			fmt.Fprintf(&b, " %s(view-only)%s", yellow, reset)
+		}
+		if mobile.ID == connectedID {
+			fmt.Fprintf(&b, " %s(connected)%s", green, reset)
+		}
+		fmt.Fprintf(&b, "\n      ID: %s\n", shortID(mobile.ID))
+		if mobile.PairedAt != "" {
+			fmt.Fprintf(&b, "      Paired: %s\n", mobile.PairedAt)
+		}
+	}
+	fmt.Fprintf(&b, "\n  %sUnpair with /unpair <id>, restrict with /viewonly <id>%s\n", dim, reset)
+	return b.String()
+}
+
+// unpairPanel unpairs a mobile and cuts it off from this session
+func (d *Daemon) unpairPanel(id string) string {
+	var b strings.Builder
+	b.WriteString(panelTitle("Unpair Mobile"))
+	if id == "" {
+		fmt.Fprintf(&b, "  Usage: /unpair <id>  %s(see /mobiles)%s\n", dim, reset)
+		return b.String()
+	}
+
+	config := d.currentPCConfig()
+	var mobile *PairedMobile
+	if config != nil {
+		mobile = findPairedMobile(config, id)
+	}
+	if mobile == nil {
+		fmt.Fprintf(&b, "  %sMobile device not found: %s%s\n", red, id, reset)
+		return b.String()
+	}
+	name, mobileID := mobile.Name, mobile.ID
+
+	if d.relayClient != nil {
+		if err := d.relayClient.UnpairMobile(mobileID); err != nil {
+			fmt.Fprintf(&b, "  %sWarning: Could not notify relay: %v%s\n", yellow, err, reset)
+		}
+	}
+	config.removePairedMobile(mobileID)
+	if d.pcConfig != nil && d.pcConfig != config {
+		d.pcConfig.removePairedMobile(mobileID)
+	}
+	if err := savePCConfig(config); err != nil {
+		fmt.Fprintf(&b, "  %sFailed to save config: %v%s\n", red, err, reset)
+		return b.String()
+	}
+	d.revokeMobile(mobileID)
+
+	fmt.Fprintf(&b, "  %s✓ Unpaired %s (%s)%s\n", green, name, shortID(mobileID), reset)
+	fmt.Fprintf(&b, "  %sThe session token is rotated and shared with the other paired mobiles.%s\n", dim, reset)
+	return b.String()
+}
+
+// viewOnlyPanel makes a mobile view-only, or gives it back control with "off"
+func (d *Daemon) viewOnlyPanel(arg string) string {
+	var b strings.Builder
+	b.WriteString(panelTitle("View-only Mobile"))
+	id, mode, _ := strings.Cut(arg, " ")
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if id == "" || (mode != "" && mode != "on" && mode != "off") {
+		fmt.Fprintf(&b, "  Usage: /viewonly <id> [off]  %s(see /mobiles)%s\n", dim, reset)
+		return b.String()
+	}
+
+	config := d.currentPCConfig()
+	if config == nil {
+		fmt.Fprintf(&b, "  %sMobile device not found: %s%s\n", red, id, reset)
+		return b.String()
+	}
+	mobile, err := setMobileViewOnly(config, id, mode != "off")
+	if err != nil {
+		fmt.Fprintf(&b, "  %s%v%s\n", red, err, reset)
+		return b.String()
+	}
+	// Keep the config loaded at startup in sync
+	if d.pcConfig != nil && d.pcConfig != config {
+		if loaded := d.pcConfig.getPairedMobile(mobile.ID); loaded != nil {
+			loaded.ViewOnly = mobile.ViewOnly
+		}
+	}
+
+	if mobile.ViewOnly {
+		fmt.Fprintf(&b, "  %s✓ %s is view-only%s\n", green, mobile.Name, reset)
+	} else {
+		fmt.Fprintf(&b, "  %s✓ %s can send input again%s\n", green, mobile.Name, reset)
+	}
+	return b.String()
+}
+
+// kickPanel disconnects the connected mobile
+func (d *Daemon) kickPanel() string {
+	var b strings.Builder
+	b.WriteString(panelTitle("Disconnect Mobile"))
+	if !d.kickMobile() {
+		fmt.Fprintf(&b, "  %sNo mobile connected%s\n", dim, reset)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "  %s✓ Mobile disconnected%s\n", green, reset)
+	fmt.Fprintf(&b, "\n  %sIt can connect again: use /unpair to keep it out.%s\n", dim, reset)
+	return b.String()
+}
+
+// kickMobile disconnects the connected mobile. A LAN connection is closed; a
+// mobile on the relay is cut off like an unpaired one until it reconnects.
+// Returns false when no mobile was connected.
+func (d *Daemon) kickMobile() bool {
+	if !d.isMobileConnected() {
+		return false
+	}
+	d.notifyMobile(NotifyLevelWarning, "Disconnected from the PC")
+
+	d.mu.Lock()
+	slot := d.activeKeySlotLocked()
+	if slot.keys != nil && slot.keys.rekeyTimer != nil {
+		slot.keys.rekeyTimer.Stop()
+	}
+	slot.keys = nil
+	conn := d.lanConn
+	d.lanConn = nil
+	if conn == nil {
+		slot.revoked = true
+	}
+	d.mobileConnected = false
+	d.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+	fmt.Printf("%s[kick] Mobile disconnected%s\n", dim, reset)
+	return true
+}
+
+// lockPanel toggles the input lock
+func (d *Daemon) lockPanel() string {
+	var b strings.Builder
+	b.WriteString(panelTitle("Input Lock"))
+
+	// Locked mobile input doesn't get here: only the PC unlocks
+	locked := d.isInputLocked()
+	d.setInputLocked(!locked)
+	if locked {
+		d.notifyMobile(NotifyLevelInfo, "Input unlocked on the PC")
+		fmt.Fprintf(&b, "  %s✓ Unlocked: mobile can type again%s\n", green, reset)
+	} else {
+		d.notifyMobile(NotifyLevelWarning, "Input locked on the PC: view only")
+		fmt.Fprintf(&b, "  %s✓ Locked: mobile is view-only%s\n", green, reset)
+		fmt.Fprintf(&b, "\n  %sType /lock on the PC to unlock.%s\n", dim, reset)
+	}
+	return b.String()
+}
+
+// isInputLocked reports whether mobile input is locked (view-only)
+func (d *Daemon) isInputLocked() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.inputLocked
+}
+
+// setInputLocked locks or unlocks mobile input
+func (d *Daemon) setInputLocked(locked bool) {
+	d.mu.Lock()
+	d.inputLocked = locked
+	d.mu.Unlock()
+	if locked {
+		fmt.Printf("%s[lock] Mobile input locked%s\n", dim, reset)
+	} else {
+		fmt.Printf("%s[lock] Mobile input unlocked%s\n", dim, reset)
+	}
+}
+
+// notifyMobile sends a notification from the CLI to mobile
+func (d *Daemon) notifyMobile(level, message string) {
+	d.forwardHookEvent("notification", NotificationData{
+		Message: message,
+		Title:   "AIPilot",
+		Level:   level,
+		Source:  notifySourceCLI,
+	})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGetAIPilotCommand(t *testing.T) {
+	d := &Daemon{}
+	tests := []struct {
+		line, cmd, arg string
+	}{
+		{"/qr", "qr", ""},
+		{"  /STATUS ", "status", ""},
+		{"/unpair 1A2b3C4d", "unpair", "1A2b3C4d"},
+		{"/unpair", "unpair", ""},
+		{"/status please", "", ""}, // Left to the agent
+		{"/model opus", "", ""},
+		{"status", "", ""},
+	}
+	for _, tt := range tests {
+		cmd, arg := d.getAIPilotCommand(tt.line)
+		if cmd != tt.cmd || arg != tt.arg {
+			t.Errorf("%q: got (%q, %q), want (%q, %q)", tt.line, cmd, arg, tt.cmd, tt.arg)
+		}
+	}
+}
+
+func TestInputLock_MobileViewOnly(t *testing.T) {
+	d := &Daemon{}
+	d.setInputLocked(true)
+	d.handleMobileInput([]byte("rm -rf"))
+	if len(d.mobileLineBuf) != 0 {
+		t.Fatalf("locked input reached the line buffer: %q", d.mobileLineBuf)
+	}
+
+	d.setInputLocked(false)
+	d.handleMobileInput([]byte("ls"))
+	if string(d.mobileLineBuf) != "ls" {
+		t.Fatalf("unlocked input lost: %q", d.mobileLineBuf)
+	}
+}
+
+func TestMobilePanel_ClosedByNextKey(t *testing.T) {
+	d := &Daemon{}
+	d.showMobilePanel(helpPanel())
+	if !d.mobilePanel {
+		t.Fatal("panel not shown")
+	}
+	d.handleMobileInput([]byte("q"))
+	if d.mobilePanel || len(d.mobileLineBuf) != 0 {
+		t.Fatalf("key not consumed by the panel (open: %v, line: %q)", d.mobilePanel, d.mobileLineBuf)
+	}
+	d.handleMobileInput([]byte("q"))
+	if string(d.mobileLineBuf) != "q" {
+		t.Fatalf("input after the panel lost: %q", d.mobileLineBuf)
+	}
+}
+
+func TestUnpairPanel_CutsOffMobile(t *testing.T) {
+	d, _ := newKeyExchangeDaemon(t)
+	_, ephemeralPublic, _ := GenerateX25519KeyPair()
+	keys, _, err := d.negotiateSessionKeys(keyExchangeRequest{
+		MobileID:  "mobile-1",
+		Ephemeral: hex.EncodeToString(ephemeralPublic[:]),
+	})
+	if err != nil {
+		t.Fatalf("negotiate: %v", err)
+	}
+	d.installMobileKeys(&d.relayKeys, keys)
+	defer d.resetMobileKeys(&d.relayKeys)
+
+	if text := d.unpairPanel("unknown"); !strings.Contains(text, "not found") {
+		t.Fatalf("unexpected panel:\n%s", text)
+	}
+	if text := d.unpairPanel("mobile-1"); !strings.Contains(text, "Unpaired Phone") {
+		t.Fatalf("unexpected panel:\n%s", text)
+	}
+
+	config, _ := loadPCConfig()
+	if config.getPairedMobile("mobile-1") != nil || d.pcConfig.getPairedMobile("mobile-1") != nil {
+		t.Fatal("mobile still paired")
+	}
+	if _, err := d.encrypt(&d.relayKeys, []byte("output")); !errors.Is(err, errMobileRevoked) {
+		t.Fatalf("expected errMobileRevoked, got %v", err)
+	}
+}
+
+func TestPCOnlyCommands_RefusedFromMobile(t *testing.T) {
+	d, _ := newKeyExchangeDaemon(t)
+	d.executeAIPilotCommand("unpair", "mobile-1", "mobile")
+	d.closeMobilePanel()
+	d.executeAIPilotCommand("viewonly", "mobile-1", "mobile")
+	d.closeMobilePanel()
+
+	config, _ := loadPCConfig()
+	if m := config.getPairedMobile("mobile-1"); m == nil || m.ViewOnly {
+		t.Fatalf("mobile changed its pairing: %+v", m)
+	}
+}
+
+func TestKickMobile(t *testing.T) {
+	d, _ := newKeyExchangeDaemon(t)
+	if d.kickMobile() {
+		t.Fatal("kicked without a mobile")
+	}
+
+	d.setMobileConnected(true)
+	if !d.kickMobile() {
+		t.Fatal("mobile not kicked")
+	}
+	if d.isMobileConnected() {
+		t.Fatal("mobile still connected")
+	}
+	if _, err := d.decrypt(&d.relayKeys, "AAAA"); !errors.Is(err, errMobileRevoked) {
+		t.Fatalf("expected errMobileRevoked, got %v", err)
+	}
+
+	// Reconnecting starts over
+	d.resetMobileKeys(&d.relayKeys)
+	if _, err := d.encrypt(&d.relayKeys, []byte("output")); err != nil {
+		t.Fatalf("encrypt after reconnect: %v", err)
+	}
+}
+
+func TestTokenFingerprint(t *testing.T) {
+	fp := tokenFingerprint("token-1")
+	if len(fp) != 19 || strings.Count(fp, ":") != 3 || strings.Contains(fp, "token") {
+		t.Fatalf("unexpected fingerprint %q", fp)
+	}
+	if fp == tokenFingerprint("token-2") {
+		t.Fatal("fingerprints collide")
+	}
+}
+
+func TestViewOnlyMobile_InputDropped(t *testing.T) {
+	d, _ := newKeyExchangeDaemon(t)
+	_, ephemeralPublic, _ := GenerateX25519KeyPair()
+	keys, _, err := d.negotiateSessionKeys(keyExchangeRequest{
+		MobileID:  "mobile-1",
+		Ephemeral: hex.EncodeToString(ephemeralPublic[:]),
+	})
+	if err != nil {
+		t.Fatalf("negotiate: %v", err)
+	}
+	d.installMobileKeys(&d.relayKeys, keys)
+	defer d.resetMobileKeys(&d.relayKeys)
+
+	// Set by another aipilot-cli process
+	config, _ := loadPCConfig()
+	if _, err := setMobileViewOnly(config, "mobile-1", true); err != nil {
+		t.Fatal(err)
+	}
+	d.handleMobileInput([]byte("rm -rf"))
+	if len(d.mobileLineBuf) != 0 {
+		t.Fatalf("view-only input reached the line buffer: %q", d.mobileLineBuf)
+	}
+	if refusal := d.mobileInputRefusal(); refusal != "This device is view-only" {
+		t.Fatalf("unexpected refusal %q", refusal)
+	}
+
+	if text := d.viewOnlyPanel("mobile-1 off"); !strings.Contains(text, "can send input again") {
+		t.Fatalf("unexpected panel:\n%s", text)
+	}
+	d.handleMobileInput([]byte("ls"))
+	if string(d.mobileLineBuf) != "ls" {
+		t.Fatalf("input lost after view-only was lifted: %q", d.mobileLineBuf)
+	}
+}
+
+func TestViewOnlyMobile_UnidentifiedRefused(t *testing.T) {
+	d, _ := newKeyExchangeDaemon(t)
+	if text := d.viewOnlyPanel("mobile-1"); !strings.Contains(text, "Phone is view-only") {
+		t.Fatalf("unexpected panel:\n%s", text)
+	}
+	if !d.pcConfig.getPairedMobile("mobile-1").ViewOnly {
+		t.Fatal("loaded config not updated")
+	}
+
+	// Without session keys the mobile may be the view-only one, whatever the
+	// relay says
+	if refusal := d.mobileInputRefusal(); refusal == "" {
+		t.Fatal("unidentified mobile accepted while a mobile is view-only")
+	}
+	d.handleMobileInput([]byte("ls"))
+	if len(d.mobileLineBuf) != 0 {
+		t.Fatalf("unidentified input reached the line buffer: %q", d.mobileLineBuf)
+	}
+
+	d.viewOnlyPanel("mobile-1 off")
+	if refusal := d.mobileInputRefusal(); refusal != "" {
+		t.Fatalf("unidentified mobile refused without view-only mobiles: %q", refusal)
+	}
+}
@@ -3,9 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 	"strings"
-	"sync/atomic"
 	"time"
 
 	"github.com/skip2/go-qrcode"
@@ -18,91 +16,27 @@ func printRaw(format string, args ...interface{}) {
 	fmt.Print(text)
 }
 
-// getAIPilotCommand checks if a line is an AIPilot command
-func (d *Daemon) getAIPilotCommand(line string) string {
-	switch line {
-	case "/qr":
-		return "qr"
-	}
-	return ""
-}
-
-// executeAIPilotCommand runs an AIPilot command
-func (d *Daemon) executeAIPilotCommand(cmd string) {
-	switch cmd {
-	case "qr":
-		d.showPairingQRInAltScreen()
-	}
-}
-
-// showPairingQRInAltScreen shows QR in alt screen, exits on ESC/Ctrl+C or pairing completion
-func (d *Daemon) showPairingQRInAltScreen() {
+// showPairingQRInAltScreen shows QR in alt screen, exits on ESC/Ctrl+C or pairing completion.
+// A mobile paired view-only can't send input.
+func (d *Daemon) showPairingQRInAltScreen(viewOnly bool) {
 	// Clear agent screen BEFORE switching to alt screen
 	d.sendToPTY([]byte{0x03}) // Ctrl+C to cancel any input
 	time.Sleep(20 * time.Millisecond)
 	d.sendToPTY([]byte{0x0c}) // Ctrl+L to clear/redraw
 	time.Sleep(50 * time.Millisecond)
 
-	// Switch to alternate screen, clear, and hide cursor
-	fmt.Print(altScreenOn + clearScreen + cursorHome + hideCursor)
-
-	// Channel to signal pairing completion
-	pairingDone := make(chan bool, 1)
-
-	// Show QR in raw mode (using \r\n)
-	d.showPairingQRRaw(func() {
-		pairingDone <- true
+	d.showPanelInAltScreen(func(done func()) {
+		// Show QR in raw mode (using \r\n)
+		d.showPairingQRRaw(viewOnly, func() {
+			// Pairing completed, auto-exit
+			time.Sleep(500 * time.Millisecond) // Brief pause to show success message
+			done()
+		})
 	})
-
-	// Show exit hint
-	printRaw("\n%sPress ESC or Ctrl+C to close%s\n", dim, reset)
-
-	// Read keys in a goroutine, only exit on ESC or Ctrl+C
-	// Use atomic flag to signal goroutine to stop
-	var shouldExit int32
-	exitRequested := make(chan bool, 1)
-	go func() {
-		b := make([]byte, 1)
-		for {
-			n, err := os.Stdin.Read(b)
-			if err != nil || n == 0 {
-				return
-			}
-
-			// Check if we should exit (pairing completed or screen closed)
-			if atomic.LoadInt32(&shouldExit) != 0 {
-				// Forward this key to PTY instead of discarding
-				d.sendToPTY(b[:n])
-				return
-			}
-
-			// ESC (0x1b) or Ctrl+C (0x03) to exit
-			if b[0] == 0x1b || b[0] == 0x03 {
-				exitRequested <- true
-				return
-			}
-			// Ignore all other keys while in QR screen
-		}
-	}()
-
-	// Wait for exit key or pairing completion
-	select {
-	case <-exitRequested:
-		// User pressed ESC or Ctrl+C
-	case <-pairingDone:
-		// Pairing completed, auto-exit
-		time.Sleep(500 * time.Millisecond) // Brief pause to show success message
-	}
-
-	// Signal goroutine to stop intercepting keys
-	atomic.StoreInt32(&shouldExit, 1)
-
-	// Restore main screen and show cursor
-	fmt.Print(showCursor + altScreenOff)
 }
 
 // showPairingQRRaw displays pairing QR in raw terminal mode (uses \r\n)
-func (d *Daemon) showPairingQRRaw(onComplete func()) {
+func (d *Daemon) showPairingQRRaw(viewOnly bool, onComplete func()) {
 	if d.relayClient == nil || d.pcConfig == nil {
 		printRaw("%sError: Cannot create pairing QR%s\n", red, reset)
 		return
@@ -129,6 +63,7 @@ func (d *Daemon) showPairingQRRaw(onComplete func()) {
 			SessionID: sessionID,
 			WorkDir:   workDir,
 			AgentType: string(agentType),
+			LANURL:    d.getLANURL(),
 		}
 	}
 
@@ -141,7 +76,11 @@ func (d *Daemon) showPairingQRRaw(onComplete func()) {
 		return
 	}
 
-	printRaw("\n%sScan to pair a new mobile device:%s\n\n", bold, reset)
+	if viewOnly {
+		printRaw("\n%sScan to pair a new view-only mobile device:%s\n\n", bold, reset)
+	} else {
+		printRaw("\n%sScan to pair a new mobile device:%s\n\n", bold, reset)
+	}
 
 	// Generate and print QR code with \r\n
 	qr, err := qrcode.New(string(qrJSON), qrcode.Medium)
@@ -156,11 +95,11 @@ func (d *Daemon) showPairingQRRaw(onComplete func()) {
 	printRaw("  Expires: %s\n", pairingResp.ExpiresAt)
 
 	// Start background polling for pairing completion
-	go d.pollPairingCompletionRaw(pairingResp.Token, onComplete)
+	go d.pollPairingCompletionRaw(pairingResp.Token, viewOnly, onComplete)
 }
 
 // pollPairingCompletionRaw polls for pairing completion with raw mode output
-func (d *Daemon) pollPairingCompletionRaw(token string, onComplete func()) {
+func (d *Daemon) pollPairingCompletionRaw(token string, viewOnly bool, onComplete func()) {
 	ticker := time.NewTicker(PairingPollInterval)
 	defer ticker.Stop()
 	timeout := time.After(PairingTimeout)
@@ -177,7 +116,7 @@ func (d *Daemon) pollPairingCompletionRaw(token string, onComplete func()) {
 
 			switch status.Status {
 			case "completed":
-				existingMobile := d.pcConfig.getPairedMobile(status.MobileID)
+				existingMobile := d.currentPairedMobile(status.MobileID)
 				samePublicKey := existingMobile != nil && existingMobile.PublicKey == status.PublicKey
 
 				mobile := PairedMobile{
@@ -185,9 +124,10 @@ func (d *Daemon) pollPairingCompletionRaw(token string, onComplete func()) {
 					Name:      status.MobileName,
 					PublicKey: status.PublicKey,
 					PairedAt:  time.Now().Format(time.RFC3339),
+					ViewOnly:  viewOnly,
 				}
-				d.pcConfig.addPairedMobile(mobile)
-				if err := savePCConfig(d.pcConfig); err != nil {
+				d.mobilePaired(mobile.ID)
+				if err := d.savePairedMobile(mobile); err != nil {
 					fmt.Printf("%sFailed to save config: %v%s\n", red, err, reset)
 				}
 
@@ -201,6 +141,9 @@ func (d *Daemon) pollPairingCompletionRaw(token string, onComplete func()) {
 				}
 
 				// Single line notification
+				if viewOnly {
+					mobile.Name += " (view-only)"
+				}
 				if samePublicKey {
 					printRaw("\n%s✓ Paired: %s (session unchanged)%s\n", green, mobile.Name, reset)
 				} else if tokenShared {
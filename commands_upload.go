@@ -5,15 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
-	"sync"
 	"time"
 )
 
-// uploadCleanupStarted tracks whether the cleanup goroutine has been started
-var uploadCleanupStarted bool
-var uploadCleanupMu sync.Mutex
-
 // cleanupAbandonedUploads removes uploads that have been inactive for more than 5 minutes
 func (d *Daemon) cleanupAbandonedUploads() {
 	d.uploadMu.Lock()
@@ -33,99 +27,86 @@ func (d *Daemon) cleanupAbandonedUploads() {
 }
 
 // handleChunkedUploadStart handles start of chunked upload
-func (d *Daemon) handleChunkedUploadStart(args string) {
-	startParts := strings.SplitN(args, ":", 4)
-	if len(startParts) == 4 {
-		uploadId := startParts[0]
-		fileName := startParts[1]
-		totalChunks := 0
-		totalSize := int64(0)
-		fmt.Sscanf(startParts[2], "%d", &totalChunks)
-		fmt.Sscanf(startParts[3], "%d", &totalSize)
-
-		d.uploadMu.Lock()
-		if d.chunkedUploads == nil {
-			d.chunkedUploads = make(map[string]*ChunkedUpload)
-			// Start cleanup goroutine on first upload (lazy initialization)
-			uploadCleanupMu.Lock()
-			if !uploadCleanupStarted {
-				uploadCleanupStarted = true
-				go func() {
-					ticker := time.NewTicker(UploadCleanupInterval)
-					defer ticker.Stop()
-					for range ticker.C {
-						d.cleanupAbandonedUploads()
-					}
-				}()
-			}
-			uploadCleanupMu.Unlock()
-		}
-		d.chunkedUploads[uploadId] = &ChunkedUpload{
-			FileName:    fileName,
-			TotalChunks: totalChunks,
-			TotalSize:   totalSize,
-			Chunks:      make(map[int][]byte),
-			ReceivedAt:  time.Now(),
-		}
-		d.uploadMu.Unlock()
+func (d *Daemon) handleChunkedUploadStart(reqID string, req uploadStartRequest) {
+	if req.UploadID == "" {
+		d.sendControlError("file-upload-result", reqID, ErrCodeInvalidRequest, "Missing upload ID")
+		return
+	}
 
-		d.sendControlMessage(fmt.Sprintf("file-upload-ack:%s:started", uploadId))
+	d.uploadMu.Lock()
+	if d.chunkedUploads == nil {
+		d.chunkedUploads = make(map[string]*ChunkedUpload)
+		// Start the session's cleanup goroutine on first upload (lazy initialization)
+		d.uploadCleanup.Do(func() {
+			go func() {
+				ticker := time.NewTicker(UploadCleanupInterval)
+				defer ticker.Stop()
+				for range ticker.C {
+					d.cleanupAbandonedUploads()
+				}
+			}()
+		})
 	}
+	d.chunkedUploads[req.UploadID] = &ChunkedUpload{
+		FileName:    req.Name,
+		TotalChunks: req.TotalChunks,
+		TotalSize:   req.TotalSize,
+		Chunks:      make(map[int][]byte),
+		ReceivedAt:  time.Now(),
+	}
+	d.uploadMu.Unlock()
+
+	d.sendControl("file-upload-ack", reqID, uploadAckPayload{UploadID: req.UploadID, Status: "started"})
 }
 
 // handleChunkedUploadChunk handles a chunk of upload
-func (d *Daemon) handleChunkedUploadChunk(args string) {
-	chunkParts := strings.SplitN(args, ":", 3)
-	if len(chunkParts) == 3 {
-		uploadId := chunkParts[0]
-		chunkIndex := 0
-		fmt.Sscanf(chunkParts[1], "%d", &chunkIndex)
-		chunkBase64 := chunkParts[2]
-
-		chunkData, err := base64.StdEncoding.DecodeString(chunkBase64)
-		if err != nil {
-			d.sendControlMessage(fmt.Sprintf("file-upload-result:error:Invalid chunk data for %s", uploadId))
-			return
-		}
+func (d *Daemon) handleChunkedUploadChunk(reqID string, req uploadChunkRequest) {
+	uploadId := req.UploadID
+	chunkIndex := req.Index
 
-		d.uploadMu.Lock()
-		upload, exists := d.chunkedUploads[uploadId]
-		if !exists {
-			d.uploadMu.Unlock()
-			d.sendControlMessage(fmt.Sprintf("file-upload-result:error:Unknown upload %s", uploadId))
-			return
-		}
+	chunkData, err := base64.StdEncoding.DecodeString(req.Data)
+	if err != nil {
+		d.sendControlError("file-upload-result", reqID, ErrCodeInvalidRequest, fmt.Sprintf("Invalid chunk data for %s", uploadId))
+		return
+	}
 
-		upload.Chunks[chunkIndex] = chunkData
-		upload.ReceivedAt = time.Now() // Update activity time
-
-		// Check if all chunks received
-		if len(upload.Chunks) == upload.TotalChunks {
-			var fullData []byte
-			for i := 0; i < upload.TotalChunks; i++ {
-				if chunk, ok := upload.Chunks[i]; ok {
-					fullData = append(fullData, chunk...)
-				} else {
-					d.uploadMu.Unlock()
-					d.sendControlMessage(fmt.Sprintf("file-upload-result:error:Missing chunk %d for %s", i, uploadId))
-					return
-				}
+	d.uploadMu.Lock()
+	upload, exists := d.chunkedUploads[uploadId]
+	if !exists {
+		d.uploadMu.Unlock()
+		d.sendControlError("file-upload-result", reqID, ErrCodeNotFound, fmt.Sprintf("Unknown upload %s", uploadId))
+		return
+	}
+
+	upload.Chunks[chunkIndex] = chunkData
+	upload.ReceivedAt = time.Now() // Update activity time
+
+	// Check if all chunks received
+	if len(upload.Chunks) == upload.TotalChunks {
+		var fullData []byte
+		for i := 0; i < upload.TotalChunks; i++ {
+			if chunk, ok := upload.Chunks[i]; ok {
+				fullData = append(fullData, chunk...)
+			} else {
+				d.uploadMu.Unlock()
+				d.sendControlError("file-upload-result", reqID, ErrCodeInvalidRequest, fmt.Sprintf("Missing chunk %d for %s", i, uploadId))
+				return
 			}
+		}
 
-			fileName := upload.FileName
-			delete(d.chunkedUploads, uploadId)
-			d.uploadMu.Unlock()
+		fileName := upload.FileName
+		delete(d.chunkedUploads, uploadId)
+		d.uploadMu.Unlock()
 
-			go d.saveUploadedFileBytes(fileName, fullData)
-		} else {
-			d.uploadMu.Unlock()
-			d.sendControlMessage(fmt.Sprintf("file-upload-ack:%s:%d", uploadId, chunkIndex))
-		}
+		go d.saveUploadedFileBytes(reqID, fileName, fullData)
+	} else {
+		d.uploadMu.Unlock()
+		d.sendControl("file-upload-ack", reqID, uploadAckPayload{UploadID: uploadId, Status: "chunk", Chunk: chunkIndex})
 	}
 }
 
 // handleChunkedUploadCancel handles cancellation of a chunked upload
-func (d *Daemon) handleChunkedUploadCancel(uploadId string) {
+func (d *Daemon) handleChunkedUploadCancel(reqID, uploadId string) {
 	d.uploadMu.Lock()
 	_, exists := d.chunkedUploads[uploadId]
 	if exists {
@@ -134,26 +115,26 @@ func (d *Daemon) handleChunkedUploadCancel(uploadId string) {
 	d.uploadMu.Unlock()
 
 	if exists {
-		d.sendControlMessage(fmt.Sprintf("file-upload-ack:%s:cancelled", uploadId))
+		d.sendControl("file-upload-ack", reqID, uploadAckPayload{UploadID: uploadId, Status: "cancelled"})
 	}
 }
 
 // saveUploadedFile saves a base64-encoded file to /tmp
-func (d *Daemon) saveUploadedFile(fileName, fileBase64 string) {
+func (d *Daemon) saveUploadedFile(reqID, fileName, fileBase64 string) {
 	fileData, err := base64.StdEncoding.DecodeString(fileBase64)
 	if err != nil {
-		d.sendControlMessage("file-upload-result:error:Invalid file encoding")
+		d.sendControlError("file-upload-result", reqID, ErrCodeInvalidRequest, "Invalid file encoding")
 		return
 	}
 
-	d.saveUploadedFileBytes(fileName, fileData)
+	d.saveUploadedFileBytes(reqID, fileName, fileData)
 }
 
 // saveUploadedFileBytes saves file bytes to /tmp
-func (d *Daemon) saveUploadedFileBytes(fileName string, fileData []byte) {
+func (d *Daemon) saveUploadedFileBytes(reqID, fileName string, fileData []byte) {
 	fileName = filepath.Base(fileName)
 	if fileName == "" || fileName == "." || fileName == ".." {
-		d.sendControlMessage("file-upload-result:error:Invalid filename")
+		d.sendControlError("file-upload-result", reqID, ErrCodeInvalidRequest, "Invalid filename")
 		return
 	}
 
@@ -161,31 +142,18 @@ func (d *Daemon) saveUploadedFileBytes(fileName string, fileData []byte) {
 	remotePath := filepath.Join(os.TempDir(), fmt.Sprintf("aipilot_%d_%s", timestamp, fileName))
 
 	if err := os.WriteFile(remotePath, fileData, FilePermissions); err != nil {
-		d.sendControlMessage(fmt.Sprintf("file-upload-result:error:Failed to write file: %v", err))
+		d.sendControlError("file-upload-result", reqID, ErrCodeInternal, fmt.Sprintf("Failed to write file: %v", err))
 		return
 	}
 
-	d.sendControlMessage(fmt.Sprintf("file-upload-result:success:%s", remotePath))
+	d.sendControl("file-upload-result", reqID, uploadResultPayload{Path: remotePath})
 
 	// Auto-insert file reference based on agent type
 	d.insertFileReference(remotePath)
 }
 
-// insertFileReference inserts a file reference into the PTY based on agent type
+// insertFileReference inserts a file reference into the PTY, in the
+// file-mention syntax of the agent
 func (d *Daemon) insertFileReference(filePath string) {
-	var insertCmd string
-
-	switch d.agentType {
-	case AgentGemini:
-		// Gemini: use @ prefix to reference files
-		insertCmd = fmt.Sprintf("@%s ", filePath)
-	case AgentCodex:
-		// Codex: use /mention command
-		insertCmd = fmt.Sprintf("/mention %s ", filePath)
-	default:
-		// Claude and others: just output the path
-		insertCmd = filePath + " "
-	}
-
-	d.sendToPTY([]byte(insertCmd))
+	d.sendToPTY([]byte(d.agent.fileMention(filePath)))
 }
@@ -14,6 +14,16 @@ const (
 const (
 	// BufferSize is the standard buffer size for PTY I/O
 	BufferSize = 4096
+	// ScrollbackSize is the amount of recent PTY output kept for mobile replay
+	ScrollbackSize = 256 * 1024
+)
+
+// Terminal defaults
+const (
+	// DefaultCols is the initial screen model width before the first resize
+	DefaultCols = 80
+	// DefaultRows is the initial screen model height before the first resize
+	DefaultRows = 24
 )
 
 // SSH defaults
@@ -24,6 +34,38 @@ const (
 	AlternativeSSHPort = 2222
 )
 
+// LAN direct mode defaults
+const (
+	// DefaultLANPort is the port of the local WebSocket listener (--lan)
+	DefaultLANPort = 7722
+)
+
+// Detached mode
+const (
+	// DetachedSessionsEnv passes the resolved sessions (JSON) to a --detach daemon
+	DetachedSessionsEnv = "AIPILOT_DETACHED_SESSIONS"
+	// MaxAttachFrameSize bounds a single frame on the attach socket
+	MaxAttachFrameSize = 1 << 20
+)
+
+// Self-hosted relay
+const (
+	// MaxRelayFrameSize bounds a single WebSocket frame read by the relay
+	MaxRelayFrameSize = 16 << 20
+)
+
+// Hook events
+const (
+	// MaxHookFieldLength bounds commands and texts forwarded from hooks to mobile
+	MaxHookFieldLength = 500
+	// ApprovalTimeout is how long a tool call waits for a decision from mobile
+	// before Claude asks in the terminal
+	ApprovalTimeout = 60 * time.Second
+	// ApprovalHookTimeout is the PreToolUse hook timeout given to Claude (seconds),
+	// longer than ApprovalTimeout so the fallback comes from us
+	ApprovalHookTimeout = 90
+)
+
 // Timeout constants
 const (
 	// UploadTimeout is the maximum time to wait for a file upload
@@ -46,4 +88,14 @@ const (
 	SSHConnectTimeout = time.Second
 	// SSHQuickCheckTimeout is the timeout for quick SSH availability checks
 	SSHQuickCheckTimeout = 500 * time.Millisecond
+	// LANHandshakeTimeout is the time a LAN mobile has to answer the auth challenge
+	LANHandshakeTimeout = 10 * time.Second
+	// AttachWriteTimeout drops an attached terminal that stops reading output
+	AttachWriteTimeout = 5 * time.Second
+	// RequestSignatureMaxAge is how far a signed relay request's timestamp may be from the relay clock
+	RequestSignatureMaxAge = 5 * time.Minute
+	// RekeyInterval is how often session keys are renegotiated and the mobile's pairing re-checked
+	RekeyInterval = 15 * time.Minute
+	// IntegrityReportInterval limits how often refused frames are reported
+	IntegrityReportInterval = time.Second
 )
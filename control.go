@@ -0,0 +1,502 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Control protocol between CLI and mobile, carried as \x00CTRL:<message> data.
+//
+// Version 1 (legacy) messages are colon-delimited strings: "resize:80,24".
+// Version 2 messages are JSON envelopes: {"v":2,"id":"42","type":"resize","payload":{...}}.
+// A v2 envelope always starts with "{", which no legacy command does, so both
+// share the same prefix. Incoming legacy strings go through parseLegacyControl
+// and are dispatched like envelopes. Outgoing messages are sent as envelopes once
+// the mobile announced protocol v2 in mobile-info (or sent an envelope), and as
+// legacy strings otherwise.
+
+// ControlProtocolVersion is the newest control protocol this CLI speaks
+const ControlProtocolVersion = 2
+
+// ControlEnvelope is a v2 control message
+type ControlEnvelope struct {
+	Version int             `json:"v"`
+	ID      string          `json:"id,omitempty"` // Request ID, echoed in responses
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   *ControlError   `json:"error,omitempty"`
+}
+
+// ControlError reports a failed request
+type ControlError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Control error codes
+const (
+	ErrCodeInvalidRequest     = "invalid_request"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeInternal           = "internal"
+)
+
+// --- Request payloads (mobile -> CLI) ---
+
+type resizeRequest struct {
+	Cols int `json:"cols"`
+	Rows int `json:"rows"`
+}
+
+type sshSetupKeyRequest struct {
+	Username string `json:"username"`
+	MobileID string `json:"mobile_id"`
+	Key      string `json:"key"` // Base64 public key
+}
+
+type fileUploadRequest struct {
+	Name string `json:"name"`
+	Data string `json:"data"` // Base64 content
+}
+
+type uploadStartRequest struct {
+	UploadID    string `json:"upload_id"`
+	Name        string `json:"name"`
+	TotalChunks int    `json:"total_chunks"`
+	TotalSize   int64  `json:"total_size"`
+}
+
+type uploadChunkRequest struct {
+	UploadID string `json:"upload_id"`
+	Index    int    `json:"index"`
+	Data     string `json:"data"` // Base64 chunk
+}
+
+type uploadCancelRequest struct {
+	UploadID string `json:"upload_id"`
+}
+
+type mobileInfoRequest struct {
+	AppVersion      string   `json:"app_version"`
+	ProtocolVersion int      `json:"protocol_version,omitempty"` // Absent: legacy app
+	Capabilities    []string `json:"capabilities,omitempty"`
+}
+
+// --- Message payloads (CLI -> mobile) ---
+
+type agentStatusPayload struct {
+	Status string `json:"status"` // "busy" or "idle"
+}
+
+type modePayload struct {
+	Client string `json:"client"` // "pc" or "mobile"
+}
+
+type replayStartPayload struct {
+	Bytes int `json:"bytes"`
+}
+
+type uploadAckPayload struct {
+	UploadID string `json:"upload_id"`
+	Status   string `json:"status"` // "started", "chunk" or "cancelled"
+	Chunk    int    `json:"chunk,omitempty"`
+}
+
+type uploadResultPayload struct {
+	Path string `json:"path"`
+}
+
+type sshSetupResultPayload struct {
+	Message string `json:"message"`
+}
+
+// toolUsePayload is a tool use reported by the agent's hooks
+type toolUsePayload struct {
+	ToolUseData
+	Summary string `json:"summary"` // "Run `make test`", "Edit src/main.go"
+}
+
+// integrityErrorPayload reports a refused data frame. The mobile sends it too.
+type integrityErrorPayload struct {
+	Reason string `json:"reason"`
+}
+
+// cliCapabilities returns the features this CLI offers, announced in cli-info
+func (d *Daemon) cliCapabilities() []string {
+	caps := []string{"replay", "screen-snapshot", "file-upload", "chunked-upload", "ssh-setup", "session-keys", "strict-frames", "hook-events", "tool-approval", "notify"}
+	if d.getLANURL() != "" {
+		caps = append(caps, "lan")
+	}
+	return caps
+}
+
+// mobileProtocolVersion returns the control protocol version negotiated with the mobile
+func (d *Daemon) mobileProtocolVersion() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.mobileProtocol
+}
+
+// setMobileProtocol records the protocol version and capabilities of the mobile.
+// A new mobile connection resets them to legacy until it announces itself.
+// A mobile announcing session keys turns strict mode on for the rest of the
+// session: a relay reconnecting "a mobile" can't go back to legacy frames.
+// Protocol v2 alone doesn't: v2 mobiles without session keys use legacy frames.
+func (d *Daemon) setMobileProtocol(version int, capabilities []string) {
+	if version > ControlProtocolVersion {
+		version = ControlProtocolVersion
+	}
+	d.mu.Lock()
+	d.mobileProtocol = version
+	d.mobileCapabilities = capabilities
+	if !d.strict && hasCapability(capabilities, "session-keys") {
+		d.strict = true
+		fmt.Printf("%s[keys] Mobile supports session keys, legacy frames are now refused%s\n", dim, reset)
+	}
+	d.mu.Unlock()
+}
+
+// hasCapability reports whether capability is in a capability list
+func hasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// mobileHasCapability reports whether the mobile announced a capability
+func (d *Daemon) mobileHasCapability(capability string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return hasCapability(d.mobileCapabilities, capability)
+}
+
+// sendControl sends a control message. reqID is the ID of the request it
+// answers, empty for unsolicited messages.
+func (d *Daemon) sendControl(msgType, reqID string, payload interface{}) {
+	if d.mobileProtocolVersion() < 2 {
+		d.sendControlMessage(legacyControl(msgType, payload))
+		return
+	}
+
+	env := ControlEnvelope{Version: ControlProtocolVersion, ID: reqID, Type: msgType}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return
+		}
+		env.Payload = data
+	}
+	d.sendControlEnvelope(env)
+}
+
+// sendControlError reports a failed request
+func (d *Daemon) sendControlError(msgType, reqID, code, message string) {
+	if d.mobileProtocolVersion() < 2 {
+		d.sendControlMessage(msgType + ":error:" + message)
+		return
+	}
+
+	d.sendControlEnvelope(ControlEnvelope{
+		Version: ControlProtocolVersion,
+		ID:      reqID,
+		Type:    msgType,
+		Error:   &ControlError{Code: code, Message: message},
+	})
+}
+
+func (d *Daemon) sendControlEnvelope(env ControlEnvelope) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return
+	}
+	d.sendControlMessage(string(data))
+}
+
+// legacyControl formats a message for mobiles that predate protocol v2
+func legacyControl(msgType string, payload interface{}) string {
+	switch p := payload.(type) {
+	case nil:
+		return msgType
+	case agentStatusPayload:
+		return msgType + ":" + p.Status
+	case modePayload:
+		return msgType + ":" + p.Client
+	case replayStartPayload:
+		return fmt.Sprintf("%s:%d", msgType, p.Bytes)
+	case uploadAckPayload:
+		if p.Status == "chunk" {
+			return fmt.Sprintf("%s:%s:%d", msgType, p.UploadID, p.Chunk)
+		}
+		return msgType + ":" + p.UploadID + ":" + p.Status
+	case uploadResultPayload:
+		return msgType + ":success:" + p.Path
+	case sshSetupResultPayload:
+		return msgType + ":success:" + p.Message
+	default:
+		// JSON payloads (cli-info, update-required, screen-snapshot)
+		data, err := json.Marshal(p)
+		if err != nil {
+			return msgType
+		}
+		return msgType + ":" + string(data)
+	}
+}
+
+// handleControlMessage processes control messages from mobile
+func (d *Daemon) handleControlMessage(msg string) {
+	if strings.HasPrefix(msg, "{") {
+		var env ControlEnvelope
+		if err := json.Unmarshal([]byte(msg), &env); err != nil || env.Type == "" {
+			d.sendControlError("error", "", ErrCodeInvalidRequest, "Invalid control envelope")
+			return
+		}
+		// Sending an envelope proves the mobile speaks v2
+		if d.mobileProtocolVersion() < 2 {
+			d.setMobileProtocol(2, nil)
+		}
+		if env.Version > ControlProtocolVersion {
+			d.sendControlError(env.Type, env.ID, ErrCodeUnsupportedVersion,
+				fmt.Sprintf("Protocol version %d not supported (max %d)", env.Version, ControlProtocolVersion))
+			return
+		}
+		d.dispatchControl(env)
+		return
+	}
+
+	env, err := parseLegacyControl(msg)
+	if err != nil {
+		fmt.Printf("%s[control] %v%s\n", dim, err, reset)
+		if env.Type == "file-upload" {
+			d.sendControlError("file-upload-result", "", ErrCodeInvalidRequest, "Invalid file upload format")
+		}
+		return
+	}
+	d.dispatchControl(env)
+}
+
+// viewControlTypes are the control requests a view-only mobile may send
+var viewControlTypes = map[string]bool{
+	"resize":          true,
+	"info-request":    true,
+	"replay-request":  true,
+	"screen-request":  true,
+	"mobile-info":     true,
+	"integrity-error": true,
+}
+
+// mobileInputRefusal returns why input from the connected mobile (the one
+// output goes to, see mobileConn) is dropped: the session is locked (/lock)
+// or the mobile is view-only. "" when accepted.
+// The mobile is only known from its session keys: what the relay reports is
+// not trusted, and a LAN connection doesn't tell. While a mobile is view-only,
+// input without session keys may come from it and is refused.
+func (d *Daemon) mobileInputRefusal() string {
+	d.mu.RLock()
+	locked := d.inputLocked
+	mobileID := ""
+	if keys := d.activeKeySlotLocked().keys; keys != nil {
+		mobileID = keys.mobileID
+	}
+	d.mu.RUnlock()
+
+	if locked {
+		return "Input is locked on the PC"
+	}
+	// Read from disk: a change made by another aipilot-cli process applies at once
+	config := d.currentPCConfig()
+	if config == nil {
+		return ""
+	}
+	if mobileID == "" {
+		if config.hasViewOnlyMobile() {
+			return "A view-only device is paired: input needs session keys"
+		}
+		return ""
+	}
+	if mobile := config.getPairedMobile(mobileID); mobile != nil && mobile.ViewOnly {
+		return "This device is view-only"
+	}
+	return ""
+}
+
+// dispatchControl runs a control request
+func (d *Daemon) dispatchControl(env ControlEnvelope) {
+	if !viewControlTypes[env.Type] {
+		if refusal := d.mobileInputRefusal(); refusal != "" {
+			d.sendControlError(env.Type, env.ID, ErrCodeForbidden, refusal)
+			return
+		}
+	}
+
+	switch env.Type {
+	case "resize":
+		var req resizeRequest
+		if d.decodeControl(env, &req) {
+			d.handleResizeCommand(req.Cols, req.Rows)
+		}
+
+	case "info-request":
+		d.sendCLIInfo(env.ID)
+
+	case "replay-request":
+		go d.sendReplay(env.ID)
+
+	case "screen-request":
+		go d.sendScreenSnapshot(env.ID)
+
+	case "ssh-setup-key":
+		var req sshSetupKeyRequest
+		if d.decodeControl(env, &req) {
+			go d.installSSHKey(env.ID, req.Username, req.MobileID, req.Key)
+		}
+
+	case "file-upload":
+		var req fileUploadRequest
+		if d.decodeControl(env, &req) {
+			go d.saveUploadedFile(env.ID, req.Name, req.Data)
+		}
+
+	case "file-upload-start":
+		var req uploadStartRequest
+		if d.decodeControl(env, &req) {
+			d.handleChunkedUploadStart(env.ID, req)
+		}
+
+	case "file-upload-chunk":
+		var req uploadChunkRequest
+		if d.decodeControl(env, &req) {
+			d.handleChunkedUploadChunk(env.ID, req)
+		}
+
+	case "file-upload-cancel":
+		var req uploadCancelRequest
+		if d.decodeControl(env, &req) {
+			d.handleChunkedUploadCancel(env.ID, req.UploadID)
+		}
+
+	case "mobile-info":
+		var req mobileInfoRequest
+		if d.decodeControl(env, &req) {
+			d.handleMobileInfo(req)
+		}
+
+	case "approval-response":
+		var req approvalResponseRequest
+		if d.decodeControl(env, &req) {
+			d.resolveApproval(env.ID, req)
+		}
+
+	case "integrity-error":
+		var req integrityErrorPayload
+		if d.decodeControl(env, &req) {
+			fmt.Printf("%s[security] Mobile refused a frame: %s%s\n", yellow, req.Reason, reset)
+		}
+
+	default:
+		// Legacy mobiles never got errors for unknown commands
+		if env.Version >= 2 {
+			d.sendControlError(env.Type, env.ID, ErrCodeUnknownType, "Unknown control message: "+env.Type)
+		}
+	}
+}
+
+// decodeControl unmarshals a request payload, reporting invalid ones
+func (d *Daemon) decodeControl(env ControlEnvelope, v interface{}) bool {
+	if len(env.Payload) == 0 {
+		env.Payload = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(env.Payload, v); err != nil {
+		if env.Version >= 2 {
+			d.sendControlError(env.Type, env.ID, ErrCodeInvalidRequest, "Invalid payload: "+err.Error())
+		} else {
+			fmt.Printf("%s[control] Invalid %s payload: %v%s\n", dim, env.Type, err, reset)
+		}
+		return false
+	}
+	return true
+}
+
+// parseLegacyControl converts a v1 "command:args" string into an envelope
+func parseLegacyControl(msg string) (ControlEnvelope, error) {
+	cmd, args, _ := strings.Cut(msg, ":")
+	env := ControlEnvelope{Version: 1, Type: cmd}
+
+	var payload interface{}
+	switch cmd {
+	case "resize":
+		colsStr, rowsStr, ok := strings.Cut(args, ",")
+		cols, errCols := strconv.Atoi(colsStr)
+		rows, errRows := strconv.Atoi(rowsStr)
+		if !ok || errCols != nil || errRows != nil {
+			return env, fmt.Errorf("invalid resize: %q", args)
+		}
+		payload = resizeRequest{Cols: cols, Rows: rows}
+
+	case "ssh-setup-key":
+		parts := strings.SplitN(args, ":", 3)
+		if len(parts) != 3 {
+			return env, fmt.Errorf("invalid ssh-setup-key")
+		}
+		payload = sshSetupKeyRequest{Username: parts[0], MobileID: parts[1], Key: parts[2]}
+
+	case "file-upload":
+		// Base64 has no colon, so the last one separates name and data
+		i := strings.LastIndex(args, ":")
+		if i < 0 {
+			return env, fmt.Errorf("invalid file-upload")
+		}
+		payload = fileUploadRequest{Name: args[:i], Data: args[i+1:]}
+
+	case "file-upload-start":
+		// uploadId:name:chunks:size - the name may contain colons
+		uploadID, rest, ok := strings.Cut(args, ":")
+		sizeIdx := strings.LastIndex(rest, ":")
+		if !ok || sizeIdx < 0 {
+			return env, fmt.Errorf("invalid file-upload-start")
+		}
+		chunksIdx := strings.LastIndex(rest[:sizeIdx], ":")
+		if chunksIdx < 0 {
+			return env, fmt.Errorf("invalid file-upload-start")
+		}
+		totalChunks, _ := strconv.Atoi(rest[chunksIdx+1 : sizeIdx])
+		totalSize, _ := strconv.ParseInt(rest[sizeIdx+1:], 10, 64)
+		payload = uploadStartRequest{
+			UploadID:    uploadID,
+			Name:        rest[:chunksIdx],
+			TotalChunks: totalChunks,
+			TotalSize:   totalSize,
+		}
+
+	case "file-upload-chunk":
+		parts := strings.SplitN(args, ":", 3)
+		if len(parts) != 3 {
+			return env, fmt.Errorf("invalid file-upload-chunk")
+		}
+		index, _ := strconv.Atoi(parts[1])
+		payload = uploadChunkRequest{UploadID: parts[0], Index: index, Data: parts[2]}
+
+	case "file-upload-cancel":
+		payload = uploadCancelRequest{UploadID: args}
+
+	case "mobile-info", "integrity-error":
+		// Already JSON
+		env.Payload = json.RawMessage(args)
+		return env, nil
+	}
+
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return env, err
+		}
+		env.Payload = data
+	}
+	return env, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	pty "github.com/aymanbagabas/go-pty"
+)
+
+func TestParseLegacyControl(t *testing.T) {
+	env, err := parseLegacyControl("resize:120,40")
+	if err != nil || env.Type != "resize" || env.Version != 1 {
+		t.Fatalf("unexpected envelope %+v (%v)", env, err)
+	}
+	var resize resizeRequest
+	json.Unmarshal(env.Payload, &resize)
+	if resize.Cols != 120 || resize.Rows != 40 {
+		t.Fatalf("unexpected resize %+v", resize)
+	}
+
+	// File names may contain colons
+	env, err = parseLegacyControl("file-upload-start:up1:notes 10:30.txt:3:2048")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var start uploadStartRequest
+	json.Unmarshal(env.Payload, &start)
+	if start.UploadID != "up1" || start.Name != "notes 10:30.txt" || start.TotalChunks != 3 || start.TotalSize != 2048 {
+		t.Fatalf("unexpected upload start %+v", start)
+	}
+
+	env, err = parseLegacyControl("file-upload:a:b.txt:aGVsbG8=")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var upload fileUploadRequest
+	json.Unmarshal(env.Payload, &upload)
+	if upload.Name != "a:b.txt" || upload.Data != "aGVsbG8=" {
+		t.Fatalf("unexpected upload %+v", upload)
+	}
+
+	if _, err := parseLegacyControl("resize:oops"); err == nil {
+		t.Fatal("expected invalid resize to fail")
+	}
+}
+
+func TestLegacyControl(t *testing.T) {
+	tests := []struct {
+		msgType string
+		payload interface{}
+		want    string
+	}{
+		{"replay-end", nil, "replay-end"},
+		{"agent-status", agentStatusPayload{Status: "busy"}, "agent-status:busy"},
+		{"replay-start", replayStartPayload{Bytes: 42}, "replay-start:42"},
+		{"file-upload-ack", uploadAckPayload{UploadID: "u", Status: "started"}, "file-upload-ack:u:started"},
+		{"file-upload-ack", uploadAckPayload{UploadID: "u", Status: "chunk", Chunk: 2}, "file-upload-ack:u:2"},
+		{"file-upload-result", uploadResultPayload{Path: "/tmp/x"}, "file-upload-result:success:/tmp/x"},
+		{"update-required", map[string]string{"a": "b"}, `update-required:{"a":"b"}`},
+	}
+	for _, tt := range tests {
+		if got := legacyControl(tt.msgType, tt.payload); got != tt.want {
+			t.Errorf("legacyControl(%s) = %q, want %q", tt.msgType, got, tt.want)
+		}
+	}
+}
+
+// recordingPTY records what reaches the agent's terminal
+type recordingPTY struct {
+	pty.Pty
+	mu      sync.Mutex
+	written []byte
+	resized int
+}
+
+func (p *recordingPTY) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.written = append(p.written, data...)
+	return len(data), nil
+}
+
+func (p *recordingPTY) Resize(width, height int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resized++
+	return nil
+}
+
+func TestResize_ViewOnlyMobileOnlyRecordsSize(t *testing.T) {
+	d, _ := newKeyExchangeDaemon(t)
+	config, _ := loadPCConfig()
+	if _, err := setMobileViewOnly(config, "mobile-1", true); err != nil {
+		t.Fatal(err)
+	}
+	terminal := &recordingPTY{}
+	d.ptmx = terminal
+	d.currentClient, d.pcCols, d.pcRows = "pc", 120, 40
+
+	d.handleControlMessage("resize:60,30")
+	time.Sleep(100 * time.Millisecond) // The Ctrl+L repaint is delayed
+
+	terminal.mu.Lock()
+	defer terminal.mu.Unlock()
+	if len(terminal.written) != 0 || terminal.resized != 0 {
+		t.Fatalf("view-only resize reached the PTY: %q, %d resizes", terminal.written, terminal.resized)
+	}
+	if d.currentClient != "pc" || d.mobileCols != 60 || d.mobileRows != 30 {
+		t.Fatalf("unexpected state: client %s, mobile size %dx%d", d.currentClient, d.mobileCols, d.mobileRows)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Conversation log (--log).
+// A Markdown file per session, in the logs directory of the config dir, with
+// the prompts sent to the agent (typed on the PC or sent from the phone) and
+// the agent's output as plain text:
+//
+//   ## 14:02:11 · Prompt from mobile
+//
+//   > fix the failing tests
+//
+//   ````text
+//   ● Running the tests...
+//   ````
+//
+// Escape sequences are stripped with the escapeFilter used for busy
+// detection. Agents redraw their screen, so cursor moves end a line and a
+// line already logged since the last prompt is not repeated.
+
+// conversationLogsDirName is the logs directory in the config directory
+const conversationLogsDirName = "logs"
+
+const (
+	// conversationRecentLines is how many lines are remembered to skip redraws
+	conversationRecentLines = 200
+	// conversationMaxLine ends lines longer than this, in bytes
+	conversationMaxLine = 4096
+)
+
+// ConversationLog writes the dialogue of a session as Markdown. A nil
+// ConversationLog logs nothing.
+type ConversationLog struct {
+	mu           sync.Mutex
+	file         *os.File
+	path         string
+	esc          escapeFilter
+	line         []byte   // Output line being assembled
+	pendingCR    bool     // Saw \r: the line ends with \n, or is overwritten
+	recent       []string // Lines logged since the last prompt
+	inBlock      bool     // An output block is open
+	pendingBlank bool     // A blank line to write before the next output line
+}
+
+// startConversationLog creates the conversation log of a session
+func startConversationLog(session, title, workDir string) (*ConversationLog, error) {
+	dir, err := getConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, conversationLogsDirName)
+	if err := os.MkdirAll(dir, DirPermissions); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	path := filepath.Join(dir, start.Format("20060102-150405")+"-"+shortID(session)+".md")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, FilePermissions)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(file, "# %s\n\n- Session: %s\n- Directory: %s\n- Started: %s\n",
+		title, session, workDir, start.Format("2006-01-02 15:04:05"))
+	return &ConversationLog{file: file, path: path}, nil
+}
+
+// Path returns the log file path, "" when not logging
+func (l *ConversationLog) Path() string {
+	if l == nil {
+		return ""
+	}
+	return l.path
+}
+
+// Prompt logs a line sent to the agent. source is "pc" or "mobile".
+func (l *ConversationLog) Prompt(source, text string) {
+	if l == nil {
+		return
+	}
+	text = strings.TrimSpace(strings.ToValidUTF8(text, ""))
+	if text == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return
+	}
+
+	l.endLine()
+	l.closeBlock()
+	from := "the PC"
+	if source == "mobile" {
+		from = "mobile"
+	}
+	fmt.Fprintf(l.file, "\n## %s · Prompt from %s\n\n", time.Now().Format("15:04:05"), from)
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(l.file, "> %s\n", line)
+	}
+	l.recent = nil
+}
+
+// Output logs agent output
+func (l *ConversationLog) Output(data []byte) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return
+	}
+
+	for _, b := range data {
+		if !l.esc.text(b) {
+			switch l.esc.csiFinal {
+			case 'H', 'f', 'd', 'A', 'B', 'E', 'F':
+				// Cursor moved to another line
+				if len(l.line) > 0 {
+					l.endLine()
+				}
+			}
+			continue
+		}
+
+		if l.pendingCR {
+			l.pendingCR = false
+			if b != '\n' {
+				// Carriage return without line feed: the line is redrawn
+				l.line = l.line[:0]
+			}
+		}
+		switch {
+		case b == '\n':
+			l.endLine()
+		case b == '\r':
+			l.pendingCR = true
+		case b == '\b':
+			if len(l.line) > 0 {
+				_, size := utf8.DecodeLastRune(l.line)
+				l.line = l.line[:len(l.line)-size]
+			}
+		case b == '\t' || b >= 0x20 && b != 0x7f:
+			l.line = append(l.line, b)
+			if len(l.line) >= conversationMaxLine {
+				l.endLine()
+			}
+		}
+	}
+}
+
+// endLine writes the assembled output line. The caller holds l.mu.
+func (l *ConversationLog) endLine() {
+	text := strings.TrimRight(strings.ToValidUTF8(string(l.line), ""), " \t")
+	l.line = l.line[:0]
+	if strings.TrimSpace(text) == "" {
+		l.pendingBlank = l.inBlock
+		return
+	}
+	for _, seen := range l.recent {
+		if seen == text {
+			return
+		}
+	}
+	l.recent = append(l.recent, text)
+	if len(l.recent) > conversationRecentLines {
+		l.recent = l.recent[1:]
+	}
+
+	if !l.inBlock {
+		// Four backticks: the output may contain ``` code blocks
+		fmt.Fprint(l.file, "\n````text\n")
+		l.inBlock = true
+	} else if l.pendingBlank {
+		fmt.Fprint(l.file, "\n")
+	}
+	l.pendingBlank = false
+	fmt.Fprintf(l.file, "%s\n", text)
+}
+
+// closeBlock ends the current output block. The caller holds l.mu.
+func (l *ConversationLog) closeBlock() {
+	if l.inBlock {
+		fmt.Fprint(l.file, "````\n")
+		l.inBlock = false
+		l.pendingBlank = false
+	}
+}
+
+// Close ends the log
+func (l *ConversationLog) Close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return
+	}
+	l.endLine()
+	l.closeBlock()
+	l.file.Close()
+	l.file = nil
+}
+
+// startConversationLog starts logging the session's conversation
+func (d *Daemon) startConversationLog() {
+	title := strings.Join(append([]string{d.command}, d.args...), " ")
+	conv, err := startConversationLog(d.session, title, d.workDir)
+	if err != nil {
+		fmt.Printf("%sWarning: cannot log the conversation: %v%s\n", yellow, err, reset)
+		return
+	}
+	d.mu.Lock()
+	d.conversation = conv
+	d.mu.Unlock()
+	fmt.Printf("%s[log] Conversation log: %s%s\n", dim, conv.path, reset)
+}
+
+// logPrompt logs a line sent to the agent from the PC or mobile
+func (d *Daemon) logPrompt(source, text string) {
+	d.mu.RLock()
+	conv := d.conversation
+	d.mu.RUnlock()
+	conv.Prompt(source, text)
+}
+
+// trimLastRune removes the last character of a line buffer (backspace)
+func trimLastRune(line []byte) []byte {
+	if len(line) == 0 {
+		return line
+	}
+	_, size := utf8.DecodeLastRune(line)
+	return line[:len(line)-size]
+}
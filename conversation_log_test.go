@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConversationLog_Markdown(t *testing.T) {
+	useConfigDir(t)
+	l, err := startConversationLog("1a2b3c4d-session", "claude", "/work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Output([]byte("\x1b[2J\x1b[H\x1b[1;32mWelcome\x1b[0m\r\n"))
+	l.Prompt("mobile", "fix the tests")
+	// A spinner redrawn with \r, a title set with OSC, a cursor move, then a
+	// redraw of a line already logged
+	l.Output([]byte("\x1b]0;claude\x07⠋ Thinking\r⠙ Thinking\r\n"))
+	l.Output([]byte("\x1b[5;1HDone: 3 tests fixed\x1b[6;1H\r\n\r\n"))
+	l.Output([]byte("\x1b[5;1HDone: 3 tests fixed\r\n"))
+	l.Prompt("pc", "  ")
+	l.Prompt("pc", "commit")
+	l.Output([]byte("typo\b\bed"))
+	l.Close()
+	l.Output([]byte("after close"))
+
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	for _, want := range []string{
+		"# claude\n\n- Session: 1a2b3c4d-session\n- Directory: /work\n",
+		"\n````text\nWelcome\n````\n",
+		"· Prompt from mobile\n\n> fix the tests\n\n````text\n⠙ Thinking\nDone: 3 tests fixed\n````\n",
+		"· Prompt from the PC\n\n> commit\n\n````text\ntyed\n````\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "Prompt from") != 2 || strings.Contains(got, "\x1b") || strings.Contains(got, "after close") {
+		t.Errorf("unexpected log:\n%s", got)
+	}
+	if info, _ := os.Stat(l.path); info.Mode().Perm() != FilePermissions {
+		t.Errorf("log mode %v", info.Mode().Perm())
+	}
+}
+
+func TestConversationLog_NonASCIIPrompt(t *testing.T) {
+	useConfigDir(t)
+	l, err := startConversationLog("1a2b3c4d-session", "claude", "/work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Daemon{conversation: l}
+	// Typed one byte at a time, with a backspace over a two-byte character
+	for _, char := range []byte("café ü\x7f→ ok\r") {
+		d.handleMobileInput([]byte{char})
+	}
+	l.Close()
+
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "> café → ok\n") {
+		t.Fatalf("prompt not logged as typed:\n%s", data)
+	}
+}
+
+func TestNilConversationLog(t *testing.T) {
+	var l *ConversationLog
+	l.Output([]byte("x"))
+	l.Prompt("pc", "x")
+	l.Close()
+}
+
+func TestTrimLastRune(t *testing.T) {
+	cases := map[string]string{"": "", "ab": "a", "café": "caf", "é": ""}
+	for input, want := range cases {
+		if got := string(trimLastRune([]byte(input))); got != want {
+			t.Errorf("%q: got %q, want %q", input, got, want)
+		}
+	}
+}
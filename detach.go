@@ -0,0 +1,507 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Detached mode: aipilot-cli --detach runs its sessions in a background daemon
+// that outlives the launching terminal. The daemon's stdin/stdout are pipes; a
+// local terminal reattaches through a Unix socket per session
+// (aipilot-cli attach <session>) and takes the place of the original terminal.
+// Whoever attaches can type into the agent, so the sockets live in the private
+// runtime directory of the hook sockets and, on Linux, only accept our uid.
+
+// detachKey detaches an attached terminal (Ctrl+\)
+const detachKey = 0x1c
+
+// Frame types on the attach socket. A frame is one type byte, a big-endian
+// uint32 payload length, then the payload.
+const (
+	attachFrameInput  = 'i' // client -> daemon: keyboard input
+	attachFrameOutput = 'o' // daemon -> client: terminal output
+	attachFrameResize = 'r' // client -> daemon: cols, rows (uint16 each)
+	attachFrameClose  = 'x' // daemon -> client: why the client was detached
+)
+
+func writeAttachFrame(w io.Writer, kind byte, payload []byte) error {
+	frame := make([]byte, 5+len(payload))
+	frame[0] = kind
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	copy(frame[5:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readAttachFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:5])
+	if size > MaxAttachFrameSize {
+		return 0, nil, fmt.Errorf("attach frame too large: %d bytes", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// attachSocketPrefix starts the attach socket names in the runtime directory
+const attachSocketPrefix = "attach-"
+
+// attachSocketPath returns the attach socket of a session
+func attachSocketPath(session string) (string, error) {
+	dir, err := hookRuntimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, attachSocketPrefix+session+".sock"), nil
+}
+
+// --- Launching terminal side ---
+
+// runDetached starts this command again as a background daemon running specs.
+// It shows the daemon's startup output and returns once its sessions are running.
+func runDetached(specs []sessionSpec) {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal("Failed to locate executable:", err)
+	}
+
+	encoded, err := encodeDetachedSpecs(specs)
+	if err != nil {
+		log.Fatal("Failed to encode sessions:", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		log.Fatal("Failed to create pipe:", err)
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), DetachedSessionsEnv+"="+encoded)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	cmd.SysProcAttr = detachSysProcAttr()
+	if err := cmd.Start(); err != nil {
+		log.Fatal("Failed to start background daemon:", err)
+	}
+	w.Close()
+
+	// The daemon closes its output once its sessions are running (or it failed)
+	io.Copy(os.Stdout, r)
+	cmd.Process.Release()
+}
+
+// detachedSessionSpecs returns the sessions passed by runDetached, or nil when
+// this process isn't a --detach daemon
+func detachedSessionSpecs() []sessionSpec {
+	value := os.Getenv(DetachedSessionsEnv)
+	if value == "" {
+		return nil
+	}
+	// Agents must not inherit it
+	os.Unsetenv(DetachedSessionsEnv)
+
+	specs, err := decodeDetachedSpecs(value)
+	if err != nil {
+		log.Fatalf("Invalid detached sessions: %v", err)
+	}
+	return specs
+}
+
+// detachedSpec is a resolved sessionSpec passed to the --detach daemon
+type detachedSpec struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	WorkDir string   `json:"workdir"`
+	Profile string   `json:"profile,omitempty"`
+}
+
+func encodeDetachedSpecs(specs []sessionSpec) (string, error) {
+	encoded := make([]detachedSpec, 0, len(specs))
+	for _, s := range specs {
+		encoded = append(encoded, detachedSpec{s.command, s.args, s.env, s.workDir, s.profile})
+	}
+	data, err := json.Marshal(encoded)
+	return string(data), err
+}
+
+func decodeDetachedSpecs(value string) ([]sessionSpec, error) {
+	var encoded []detachedSpec
+	if err := json.Unmarshal([]byte(value), &encoded); err != nil {
+		return nil, err
+	}
+	specs := make([]sessionSpec, 0, len(encoded))
+	for _, s := range encoded {
+		specs = append(specs, sessionSpec{command: s.Command, args: s.Args, env: s.Env, workDir: s.WorkDir, profile: s.Profile})
+	}
+	return specs, nil
+}
+
+// --- Daemon side ---
+
+// detachedTerminal stands in for the local terminal of a --detach daemon
+type detachedTerminal struct {
+	mux    *Multiplexer
+	input  *os.File // Write end of the daemon's stdin
+	mu     sync.Mutex
+	client net.Conn // Attached terminal, nil when none
+}
+
+// detachFromTerminal opens the attach sockets, tells the launching terminal how
+// to reattach, then swaps stdin/stdout/stderr for pipes and releases it.
+func detachFromTerminal(mux *Multiplexer) {
+	t := &detachedTerminal{mux: mux}
+
+	for _, daemon := range mux.All() {
+		if err := t.startAttachSocket(daemon); err != nil {
+			log.Fatalf("Failed to create attach socket: %v", err)
+		}
+		fmt.Printf("%s✓ Running in background.%s Reattach with: %saipilot-cli attach %s%s\n",
+			green, reset, bold, shortID(daemon.session), reset)
+	}
+	fmt.Printf("%sPress Ctrl+\\ to detach again.%s\n", dim, reset)
+
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		log.Fatal("Failed to create pipe:", err)
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		log.Fatal("Failed to create pipe:", err)
+	}
+	t.input = inW
+
+	parentOut, parentErr := os.Stdout, os.Stderr
+	os.Stdin = inR
+	os.Stdout = outW
+	os.Stderr = outW
+	log.SetOutput(outW)
+	go t.forwardOutput(outR)
+
+	// Closing our end of the launcher's pipe lets it return
+	parentOut.Close()
+	parentErr.Close()
+}
+
+// startAttachSocket listens for attaching terminals of a session
+func (t *detachedTerminal) startAttachSocket(d *Daemon) error {
+	socketPath, err := attachSocketPath(d.session)
+	if err != nil {
+		return err
+	}
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socketPath, FilePermissions); err != nil {
+		listener.Close()
+		return err
+	}
+
+	d.mu.Lock()
+	d.attachListener = listener
+	d.attachSocketPath = socketPath
+	d.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				// Listener closed (session ended)
+				return
+			}
+			if err := checkHookPeer(conn); err != nil {
+				log.Printf("Rejected attach connection: %v", err)
+				conn.Close()
+				continue
+			}
+			go t.serveClient(d, conn)
+		}
+	}()
+	return nil
+}
+
+// stopAttachSocket closes the attach listener and removes the socket file
+func (d *Daemon) stopAttachSocket() {
+	d.mu.Lock()
+	listener := d.attachListener
+	socketPath := d.attachSocketPath
+	d.attachListener = nil
+	d.mu.Unlock()
+
+	if listener != nil {
+		listener.Close()
+	}
+	if socketPath != "" {
+		os.Remove(socketPath)
+	}
+}
+
+// serveClient attaches a terminal to the daemon, showing session d.
+// A newly attached terminal replaces the previous one.
+func (t *detachedTerminal) serveClient(d *Daemon, conn net.Conn) {
+	defer conn.Close()
+
+	// The client announces its size first so the repaint fits its terminal
+	kind, payload, err := readAttachFrame(conn)
+	if err != nil {
+		return
+	}
+	if kind == attachFrameResize {
+		t.resize(payload)
+	}
+
+	t.mu.Lock()
+	previous := t.client
+	t.client = conn
+	if previous != nil {
+		previous.SetWriteDeadline(time.Now().Add(AttachWriteTimeout))
+		writeAttachFrame(previous, attachFrameClose, []byte("Attached from another terminal."))
+		previous.Close()
+	}
+	t.mu.Unlock()
+
+	t.mux.SelectDaemon(d)
+
+	for {
+		kind, payload, err := readAttachFrame(conn)
+		if err != nil {
+			break
+		}
+		switch kind {
+		case attachFrameInput:
+			t.input.Write(payload)
+		case attachFrameResize:
+			t.resize(payload)
+		}
+	}
+
+	t.mu.Lock()
+	if t.client == conn {
+		t.client = nil
+	}
+	t.mu.Unlock()
+}
+
+// resize applies the size of the attached terminal
+func (t *detachedTerminal) resize(payload []byte) {
+	if len(payload) != 4 {
+		return
+	}
+	cols := int(binary.BigEndian.Uint16(payload[0:2]))
+	rows := int(binary.BigEndian.Uint16(payload[2:4]))
+	if cols > 0 && rows > 0 {
+		resizeSessions(t.mux, cols, rows)
+	}
+}
+
+// forwardOutput copies the daemon's stdout to the attached terminal.
+// Output is dropped while no terminal is attached (mobiles still get it).
+func (t *detachedTerminal) forwardOutput(r *os.File) {
+	buf := make([]byte, BufferSize)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+
+		t.mu.Lock()
+		if t.client != nil {
+			t.client.SetWriteDeadline(time.Now().Add(AttachWriteTimeout))
+			if err := writeAttachFrame(t.client, attachFrameOutput, buf[:n]); err != nil {
+				t.client.Close()
+				t.client = nil
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// --- attach subcommand ---
+
+// attachableSessions returns the sessions of running --detach daemons
+// (session ID -> attach socket path)
+func attachableSessions() map[string]string {
+	sessions := make(map[string]string)
+	dir, err := hookRuntimeDir()
+	if err != nil {
+		return sessions
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, attachSocketPrefix+"*.sock"))
+	for _, path := range paths {
+		name := filepath.Base(path)
+		id := strings.TrimSuffix(strings.TrimPrefix(name, attachSocketPrefix), ".sock")
+		sessions[id] = path
+	}
+	return sessions
+}
+
+// attachMain implements "aipilot-cli attach [session]"
+func attachMain(args []string) {
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: aipilot-cli attach [session]\n\n")
+		fmt.Fprintf(fs.Output(), "Reattach this terminal to a session started with --detach.\n")
+		fmt.Fprintf(fs.Output(), "The session ID may be abbreviated. Press Ctrl+\\ to detach.\n")
+	}
+	fs.Parse(args)
+
+	sessions := attachableSessions()
+	var ids []string
+	for id := range sessions {
+		if fs.NArg() == 0 || strings.HasPrefix(id, fs.Arg(0)) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	switch {
+	case len(ids) == 1:
+		// Found it
+	case len(ids) == 0 && fs.NArg() > 0:
+		fmt.Printf("%sError: no detached session matches %s%s\n", red, fs.Arg(0), reset)
+		os.Exit(1)
+	case len(ids) == 0:
+		fmt.Printf("%sNo detached sessions.%s\n", dim, reset)
+		os.Exit(1)
+	default:
+		fmt.Printf("%sSeveral detached sessions, pick one:%s\n\n", bold, reset)
+		for _, id := range ids {
+			fmt.Printf("  aipilot-cli attach %s\n", shortID(id))
+		}
+		fmt.Println()
+		os.Exit(1)
+	}
+
+	id := ids[0]
+	conn, err := net.Dial("unix", sessions[id])
+	if err != nil {
+		// The daemon is gone without cleaning up
+		os.Remove(sessions[id])
+		fmt.Printf("%sError: session %s is no longer running%s\n", red, shortID(id), reset)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	runAttachClient(conn, id)
+}
+
+// runAttachClient relays the local terminal to an attached daemon until the
+// user detaches or the daemon closes the connection
+func runAttachClient(conn net.Conn, id string) {
+	stdinFd := int(os.Stdin.Fd())
+	if !term.IsTerminal(stdinFd) {
+		fmt.Printf("%sError: attach needs an interactive terminal%s\n", red, reset)
+		os.Exit(1)
+	}
+
+	var writeMu sync.Mutex
+	send := func(kind byte, payload []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return writeAttachFrame(conn, kind, payload)
+	}
+	sendSize := func() {
+		width, height, err := term.GetSize(stdinFd)
+		if err != nil || width <= 0 || height <= 0 {
+			return
+		}
+		size := make([]byte, 4)
+		binary.BigEndian.PutUint16(size[0:2], uint16(width))
+		binary.BigEndian.PutUint16(size[2:4], uint16(height))
+		send(attachFrameResize, size)
+	}
+
+	sendSize()
+
+	oldState, err := term.MakeRaw(stdinFd)
+	if err != nil {
+		fmt.Printf("%sError: could not set raw mode: %v%s\n", red, err, reset)
+		os.Exit(1)
+	}
+
+	// Keyboard input, up to the detach key
+	detached := make(chan struct{})
+	go func() {
+		buf := make([]byte, BufferSize)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil || n == 0 {
+				return
+			}
+			data := buf[:n]
+			if i := strings.IndexByte(string(data), detachKey); i >= 0 {
+				if i > 0 {
+					send(attachFrameInput, data[:i])
+				}
+				close(detached)
+				conn.Close()
+				return
+			}
+			if send(attachFrameInput, data) != nil {
+				return
+			}
+		}
+	}()
+
+	// Window resizes
+	resizeChan := setupResizeSignal()
+	go func() {
+		for range resizeChan {
+			sendSize()
+		}
+	}()
+
+	// Daemon output
+	reason := ""
+	for {
+		kind, payload, err := readAttachFrame(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				reason = err.Error()
+			}
+			break
+		}
+		switch kind {
+		case attachFrameOutput:
+			os.Stdout.Write(payload)
+		case attachFrameClose:
+			reason = string(payload)
+		}
+	}
+
+	term.Restore(stdinFd, oldState)
+
+	select {
+	case <-detached:
+		fmt.Printf("\n%sDetached from session %s. Reattach with: aipilot-cli attach %s%s\n", dim, shortID(id), shortID(id), reset)
+	default:
+		if reason == "" {
+			reason = "Session ended."
+		}
+		fmt.Printf("\n%s%s%s\n", dim, reason, reset)
+	}
+}
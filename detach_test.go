@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAttachFrames_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	writeAttachFrame(&buf, attachFrameOutput, []byte("hello"))
+	writeAttachFrame(&buf, attachFrameResize, []byte{0, 80, 0, 24})
+
+	kind, payload, err := readAttachFrame(&buf)
+	if err != nil || kind != attachFrameOutput || string(payload) != "hello" {
+		t.Fatalf("unexpected frame %c %q (%v)", kind, payload, err)
+	}
+	kind, payload, err = readAttachFrame(&buf)
+	if err != nil || kind != attachFrameResize || !bytes.Equal(payload, []byte{0, 80, 0, 24}) {
+		t.Fatalf("unexpected frame %c %v (%v)", kind, payload, err)
+	}
+	if _, _, err := readAttachFrame(&buf); err == nil {
+		t.Fatal("expected error at end of stream")
+	}
+}
+
+func TestAttachFrames_RejectsOversized(t *testing.T) {
+	header := []byte{attachFrameOutput, 0xff, 0xff, 0xff, 0xff}
+	if _, _, err := readAttachFrame(bytes.NewReader(header)); err == nil {
+		t.Fatal("expected oversized frame to be rejected")
+	}
+}
+
+func TestDetachedSpecs_RoundTrip(t *testing.T) {
+	specs := []sessionSpec{
+		{command: "claude", args: []string{"--model", "x y"}, env: []string{"A=1"}, workDir: "/w", profile: "p"},
+		{command: "gemini", workDir: "/v"},
+	}
+	encoded, err := encodeDetachedSpecs(specs)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	decoded, err := decodeDetachedSpecs(encoded)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, specs) {
+		t.Fatalf("got %+v, want %+v", decoded, specs)
+	}
+}
+
+func TestAttachSockets_InPrivateRuntimeDir(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	d := &Daemon{session: "1a2b3c4d-session"}
+	terminal := &detachedTerminal{}
+	if err := terminal.startAttachSocket(d); err != nil {
+		t.Fatalf("startAttachSocket: %v", err)
+	}
+	defer d.stopAttachSocket()
+
+	info, err := os.Stat(filepath.Dir(d.attachSocketPath))
+	if err != nil || info.Mode().Perm() != 0700 || filepath.Dir(filepath.Dir(d.attachSocketPath)) != runtimeDir {
+		t.Fatalf("socket not in a private runtime directory: %s (%v)", d.attachSocketPath, err)
+	}
+
+	// Another session, with an ID shorter than usual
+	os.WriteFile(filepath.Join(filepath.Dir(d.attachSocketPath), attachSocketPrefix+"ab.sock"), nil, FilePermissions)
+	sessions := attachableSessions()
+	if sessions[d.session] != d.attachSocketPath || sessions["ab"] == "" || len(sessions) != 2 {
+		t.Fatalf("unexpected sessions %v", sessions)
+	}
+}
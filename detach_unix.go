@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// detachSysProcAttr starts the daemon in its own session, so closing the
+// launching terminal (SIGHUP) doesn't reach it
+func detachSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
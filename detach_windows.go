@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+// detachedProcess is DETACHED_PROCESS: the daemon gets no console
+const detachedProcess = 0x00000008
+
+// detachSysProcAttr starts the daemon without a console, in its own process
+// group, so closing the launching console doesn't reach it
+func detachSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: detachedProcess | syscall.CREATE_NEW_PROCESS_GROUP}
+}
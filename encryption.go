@@ -6,8 +6,11 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"time"
 )
 
 // initEncryption derives AES-256-GCM key from token
@@ -29,9 +32,101 @@ func (d *Daemon) initEncryption() error {
 	return nil
 }
 
-// encrypt encrypts data using AES-GCM
-// Returns base64(nonce || ciphertext)
-func (d *Daemon) encrypt(plaintext []byte) (string, error) {
+// Data frames.
+// With session keys every frame is strict: base64(counter || nonce || ciphertext),
+// where the 8-byte big-endian counter grows by one per frame and is
+// authenticated as additional data together with the direction. A frame whose
+// counter is not above the last accepted one was replayed or reordered and is
+// refused. Mobiles without session keys use legacy frames with the token key,
+// base64(nonce || ciphertext), unless the CLI runs in strict mode: set by
+// --strict, or once a mobile announced session keys (see setMobileProtocol).
+// The relay and the LAN connection each have their own keys (see keySlot).
+// Unencrypted frames are always refused.
+
+// Frame errors, reported to both sides as integrity errors
+var (
+	errFrameReplayed  = errors.New("replayed or reordered frame")
+	errFrameIntegrity = errors.New("frame failed integrity check")
+	errLegacyFrame    = errors.New("legacy frame refused in strict mode")
+)
+
+// Additional data labels of the two directions
+const (
+	frameLabelCLIToMobile = "aipilot-c2m"
+	frameLabelMobileToCLI = "aipilot-m2c"
+)
+
+// frameCounterSize is the size of the counter prefix of strict frames
+const frameCounterSize = 8
+
+// frameKey is a session key with the counter of its direction
+type frameKey struct {
+	aead    cipher.AEAD
+	counter uint64 // Last counter sent, or last counter accepted
+}
+
+// frameAAD is the additional data of a strict frame
+func frameAAD(label string, counter []byte) []byte {
+	return append([]byte(label), counter...)
+}
+
+// sealFrame encrypts a strict frame with the next counter of key
+func sealFrame(key *frameKey, label string, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	key.counter++
+	frame := make([]byte, frameCounterSize, frameCounterSize+len(nonce)+len(plaintext)+key.aead.Overhead())
+	binary.BigEndian.PutUint64(frame, key.counter)
+	aad := frameAAD(label, frame)
+	frame = append(frame, nonce...)
+	return key.aead.Seal(frame, nonce, plaintext, aad), nil
+}
+
+// openFrame decrypts a strict frame and advances the counter of key
+func openFrame(key *frameKey, label string, frame []byte) ([]byte, error) {
+	nonceSize := key.aead.NonceSize()
+	if len(frame) < frameCounterSize+nonceSize {
+		return nil, errFrameIntegrity
+	}
+
+	counterBytes := frame[:frameCounterSize]
+	nonce := frame[frameCounterSize : frameCounterSize+nonceSize]
+	plaintext, err := key.aead.Open(nil, nonce, frame[frameCounterSize+nonceSize:], frameAAD(label, counterBytes))
+	if err != nil {
+		return nil, errFrameIntegrity
+	}
+
+	counter := binary.BigEndian.Uint64(counterBytes)
+	if counter <= key.counter {
+		return nil, errFrameReplayed
+	}
+	key.counter = counter
+	return plaintext, nil
+}
+
+// encrypt encrypts data for the mobile on slot's transport: a strict frame
+// with the session keys when negotiated, a legacy frame with the token key
+// otherwise. Returns base64 of the frame.
+func (d *Daemon) encrypt(slot *keySlot, plaintext []byte) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if slot.revoked {
+		return "", errMobileRevoked
+	}
+	if slot.keys != nil {
+		frame, err := sealFrame(slot.keys.send, frameLabelCLIToMobile, plaintext)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(frame), nil
+	}
+	if d.strict || d.legacyKeyRevoked {
+		return "", errLegacyFrame
+	}
 	if d.aesGCM == nil {
 		return "", fmt.Errorf("encryption not initialized")
 	}
@@ -48,17 +143,41 @@ func (d *Daemon) encrypt(plaintext []byte) (string, error) {
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
-// decrypt decrypts base64(nonce || ciphertext) using AES-GCM
-func (d *Daemon) decrypt(encoded string) ([]byte, error) {
-	if d.aesGCM == nil {
-		return nil, fmt.Errorf("encryption not initialized")
-	}
-
+// decrypt decrypts a base64 frame received from the mobile on slot's transport
+func (d *Daemon) decrypt(slot *keySlot, encoded string) ([]byte, error) {
 	data, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode base64: %w", err)
 	}
 
+	d.mu.Lock()
+	if slot.keys != nil || slot.pending != nil {
+		plaintext, proven, promoted, err := d.openSessionFrame(slot, data)
+		// Without keys of its own, a frame not proving the pending ones may be legacy
+		if slot.keys != nil {
+			d.mu.Unlock()
+			if proven != nil {
+				d.sessionKeysProven(proven.mobileID)
+			}
+			if promoted {
+				// Output sent with the replaced keys meanwhile was lost
+				go d.sendScreenSnapshot("")
+			}
+			return plaintext, err
+		}
+	}
+	defer d.mu.Unlock()
+
+	if slot.revoked {
+		return nil, errMobileRevoked
+	}
+	if d.strict || d.legacyKeyRevoked {
+		return nil, errLegacyFrame
+	}
+	if d.aesGCM == nil {
+		return nil, fmt.Errorf("encryption not initialized")
+	}
+
 	nonceSize := d.aesGCM.NonceSize()
 	if len(data) < nonceSize {
 		return nil, fmt.Errorf("ciphertext too short")
@@ -72,3 +191,55 @@ func (d *Daemon) decrypt(encoded string) ([]byte, error) {
 
 	return plaintext, nil
 }
+
+// openSessionFrame decrypts a frame with the session keys of slot, or with
+// the pending keys of a new exchange, which the frame proves and installs
+// (promoted). Returns the keys when this is their first frame. Must be called
+// with d.mu held and session keys installed or pending.
+func (d *Daemon) openSessionFrame(slot *keySlot, data []byte) ([]byte, *mobileKeys, bool, error) {
+	if slot.revoked {
+		return nil, nil, false, errMobileRevoked
+	}
+	keys := slot.keys
+	var plaintext []byte
+	err := errFrameIntegrity
+	if keys != nil {
+		plaintext, err = openFrame(keys.recv, frameLabelMobileToCLI, data)
+		if errors.Is(err, errFrameIntegrity) && keys.previousRecv != nil {
+			// Sent with the previous keys before the mobile saw the rekey
+			plaintext, err = openFrame(keys.previousRecv, frameLabelMobileToCLI, data)
+		}
+	}
+	promoted := false
+	if errors.Is(err, errFrameIntegrity) && slot.pending != nil {
+		if pending, pendingErr := openFrame(slot.pending.recv, frameLabelMobileToCLI, data); pendingErr == nil {
+			keys = slot.pending
+			d.installMobileKeysLocked(slot, keys)
+			plaintext, err, promoted = pending, nil, true
+		}
+	}
+	if err != nil || keys.proven {
+		return plaintext, nil, promoted, err
+	}
+	keys.proven = true
+	return plaintext, keys, promoted, nil
+}
+
+// reportIntegrityError reports a refused frame on the PC and to the mobile,
+// at most once per IntegrityReportInterval so a replaying relay can't flood them
+func (d *Daemon) reportIntegrityError(err error) {
+	if errors.Is(err, errMobileRevoked) {
+		return
+	}
+
+	d.mu.Lock()
+	if time.Since(d.lastIntegrityReport) < IntegrityReportInterval {
+		d.mu.Unlock()
+		return
+	}
+	d.lastIntegrityReport = time.Now()
+	d.mu.Unlock()
+
+	fmt.Printf("%s[security] Refused frame from mobile: %v%s\n", yellow, err, reset)
+	d.sendControl("integrity-error", "", integrityErrorPayload{Reason: err.Error()})
+}
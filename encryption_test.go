@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func newFrameKeys(t *testing.T) (*frameKey, *frameKey) {
+	t.Helper()
+	key := make([]byte, 32)
+	sender, err := newGCM(key)
+	if err != nil {
+		t.Fatalf("gcm: %v", err)
+	}
+	receiver, _ := newGCM(key)
+	return &frameKey{aead: sender}, &frameKey{aead: receiver}
+}
+
+func TestFrames_ReplayAndReorderRefused(t *testing.T) {
+	send, recv := newFrameKeys(t)
+
+	first, _ := sealFrame(send, frameLabelMobileToCLI, []byte("a"))
+	second, _ := sealFrame(send, frameLabelMobileToCLI, []byte("b"))
+	third, _ := sealFrame(send, frameLabelMobileToCLI, []byte("c"))
+
+	if plaintext, err := openFrame(recv, frameLabelMobileToCLI, first); err != nil || string(plaintext) != "a" {
+		t.Fatalf("open first: %q %v", plaintext, err)
+	}
+	// A gap is fine (dropped frame), going back is not
+	if _, err := openFrame(recv, frameLabelMobileToCLI, third); err != nil {
+		t.Fatalf("open third: %v", err)
+	}
+	if _, err := openFrame(recv, frameLabelMobileToCLI, second); !errors.Is(err, errFrameReplayed) {
+		t.Fatalf("expected reordered frame to be refused, got %v", err)
+	}
+	if _, err := openFrame(recv, frameLabelMobileToCLI, third); !errors.Is(err, errFrameReplayed) {
+		t.Fatalf("expected replayed frame to be refused, got %v", err)
+	}
+}
+
+func TestFrames_TamperedCounterRefused(t *testing.T) {
+	send, recv := newFrameKeys(t)
+
+	frame, _ := sealFrame(send, frameLabelMobileToCLI, []byte("a"))
+	frame[frameCounterSize-1] = 9 // Claim a later counter
+	if _, err := openFrame(recv, frameLabelMobileToCLI, frame); !errors.Is(err, errFrameIntegrity) {
+		t.Fatalf("expected tampered counter to be refused, got %v", err)
+	}
+	if recv.counter != 0 {
+		t.Fatalf("refused frame moved the counter to %d", recv.counter)
+	}
+
+	// A frame of the other direction is refused (no reflection)
+	frame, _ = sealFrame(send, frameLabelCLIToMobile, []byte("a"))
+	if _, err := openFrame(recv, frameLabelMobileToCLI, frame); !errors.Is(err, errFrameIntegrity) {
+		t.Fatalf("expected reflected frame to be refused, got %v", err)
+	}
+}
+
+func TestStrictMode_RefusesLegacyFrames(t *testing.T) {
+	d := &Daemon{token: "token-1"}
+	if err := d.initEncryption(); err != nil {
+		t.Fatalf("init encryption: %v", err)
+	}
+	legacy, err := d.encrypt(&d.relayKeys, []byte("hello"))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	// Outside strict mode, legacy frames are still accepted, plaintext never
+	if data, err := d.decodeMobilePayload(&d.relayKeys, legacy); err != nil || string(data) != "hello" {
+		t.Fatalf("legacy frame: %q %v", data, err)
+	}
+	plain := base64.StdEncoding.EncodeToString([]byte("plain"))
+	if _, err := d.decodeMobilePayload(&d.relayKeys, plain); err == nil {
+		t.Fatal("expected plaintext frame to be refused")
+	}
+
+	d.strict = true
+	if _, err := d.decodeMobilePayload(&d.relayKeys, legacy); !errors.Is(err, errLegacyFrame) {
+		t.Fatalf("expected legacy frame to be refused, got %v", err)
+	}
+	if _, err := d.decodeMobilePayload(&d.relayKeys, plain); err == nil {
+		t.Fatal("expected plaintext frame to be refused")
+	}
+	if _, err := d.encrypt(&d.relayKeys, []byte("out")); !errors.Is(err, errLegacyFrame) {
+		t.Fatalf("expected no legacy frame to be sent, got %v", err)
+	}
+}
+
+func TestStrictMode_OnOnceSessionKeysAnnounced(t *testing.T) {
+	for _, announce := range []struct {
+		version      int
+		capabilities []string
+		strict       bool
+	}{
+		{1, []string{"replay", "session-keys"}, true},
+		{2, []string{"session-keys"}, true},
+		// A v2 envelope alone doesn't tell the mobile has session keys
+		{2, nil, false},
+	} {
+		d := &Daemon{token: "token-1"}
+		d.initEncryption()
+		legacy, _ := d.encrypt(&d.relayKeys, []byte("hello"))
+
+		d.setMobileProtocol(1, []string{"replay"})
+		if _, err := d.decodeMobilePayload(&d.relayKeys, legacy); err != nil {
+			t.Fatalf("legacy frame refused before session keys were announced: %v", err)
+		}
+
+		d.setMobileProtocol(announce.version, announce.capabilities)
+		// The relay reports a new mobile connection
+		d.setMobileProtocol(0, nil)
+		d.resetMobileKeys(&d.relayKeys)
+		_, err := d.decodeMobilePayload(&d.relayKeys, legacy)
+		if refused := errors.Is(err, errLegacyFrame); refused != announce.strict {
+			t.Fatalf("v%d %v: legacy frame refused %v, want %v (%v)", announce.version, announce.capabilities, refused, announce.strict, err)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 // Claude Code specific hook installation.
@@ -20,132 +22,101 @@ func claudeSettingsPath() string {
 	return filepath.Join(home, ".claude", "settings.json")
 }
 
+// claudeProjectSettingsPath returns the path to the project-local Claude Code
+// settings of a directory, which are not meant to be committed
+func claudeProjectSettingsPath(workDir string) string {
+	return filepath.Join(workDir, ".claude", "settings.local.json")
+}
+
 // hookCommand is the command that Claude Code will execute for each hook event
 const hookCommand = "aipilot-cli --agent-event"
 
 // claudeHookEvents lists the Claude Code events we want to hook into
-var claudeHookEvents = []string{"UserPromptSubmit", "Stop", "StopFailure", "Notification"}
-
-// ensureClaudeHooksInstalled reads ~/.claude/settings.json and adds
-// aipilot hook entries for agent status detection if not already present.
-func ensureClaudeHooksInstalled() {
-	settingsPath := claudeSettingsPath()
-	if settingsPath == "" {
-		return
-	}
-
-	// Read existing settings
-	settings, err := readClaudeSettings(settingsPath)
-	if err != nil {
-		fmt.Printf("%s[hook] Cannot read Claude settings: %v%s\n", dim, err, reset)
-		return
-	}
-
-	// Ensure hooks section exists
-	hooks, ok := settings["hooks"].(map[string]interface{})
-	if !ok {
-		hooks = make(map[string]interface{})
-		settings["hooks"] = hooks
-	}
-
-	modified := false
-	for _, eventName := range claudeHookEvents {
-		if addClaudeHookIfMissing(hooks, eventName) {
-			modified = true
-		}
-	}
+var claudeHookEvents = []string{
+	"UserPromptSubmit", "Stop", "StopFailure", "Notification",
+	"PreToolUse", "PostToolUse", "SubagentStop",
+}
 
-	if !modified {
-		return
+// claudeHookTarget returns the aipilot hooks of a directory: in
+// ~/.claude/settings.json, or .claude/settings.local.json in the directory
+// when its hook scope is HookScopeProject
+func claudeHookTarget(workDir string) hookTarget {
+	target := &jsonHookTarget{
+		name:   "claude",
+		file:   claudeSettingsPath(),
+		events: claudeHookEvents,
+		hook:   claudeHook,
 	}
-
-	// Write back
-	if err := writeClaudeSettings(settingsPath, settings); err != nil {
-		fmt.Printf("%s[hook] Cannot write Claude settings: %v%s\n", dim, err, reset)
-		return
+	if directoryHookScope(workDir) == HookScopeProject {
+		target.file = claudeProjectSettingsPath(workDir)
+		target.saved = excludeFromGit
 	}
-
-	fmt.Printf("%s[hook] Installed aipilot hooks in %s%s\n", dim, settingsPath, reset)
+	return target
 }
 
-// addClaudeHookIfMissing adds an aipilot hook entry to the given event array
-// if one doesn't already exist. Returns true if the settings were modified.
-func addClaudeHookIfMissing(hooks map[string]interface{}, eventName string) bool {
-	// Get or create the event array
-	var eventEntries []interface{}
-	if existing, ok := hooks[eventName]; ok {
-		if arr, ok := existing.([]interface{}); ok {
-			eventEntries = arr
-		}
-	}
-
-	// Check if aipilot hook is already installed
-	for _, entry := range eventEntries {
-		entryMap, ok := entry.(map[string]interface{})
-		if !ok {
-			continue
-		}
-		hooksList, ok := entryMap["hooks"].([]interface{})
-		if !ok {
-			continue
-		}
-		for _, h := range hooksList {
-			hookMap, ok := h.(map[string]interface{})
-			if !ok {
-				continue
-			}
-			if cmd, ok := hookMap["command"].(string); ok && cmd == hookCommand {
-				return false // Already installed
-			}
-		}
+// excludeFromGit keeps a project settings file from being committed: when the
+// file is in a git repository that does not ignore it, it is added to the
+// repository's info/exclude. Nothing is done outside a git repository.
+func excludeFromGit(file string) {
+	if err := gitExclude(file); err != nil {
+		fmt.Printf("%s[hook] Cannot exclude %s from git: %v%s\n", dim, file, err, reset)
 	}
+}
 
-	// Add new entry
-	newEntry := map[string]interface{}{
-		"matcher": "",
-		"hooks": []interface{}{
-			map[string]interface{}{
-				"type":    "command",
-				"command": hookCommand,
-				"timeout": 5,
-				"async":   true,
-			},
-		},
+// gitExclude adds a file to info/exclude of its git repository if git does
+// not already ignore it
+func gitExclude(file string) error {
+	dir, name := filepath.Dir(file), filepath.Base(file)
+	err := exec.Command("git", "-C", dir, "check-ignore", "-q", name).Run()
+	var exitErr *exec.ExitError
+	if err == nil || !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		// Already ignored, not in a repository, or no git
+		return nil
 	}
 
-	hooks[eventName] = append(eventEntries, newEntry)
-	return true
-}
-
-// readClaudeSettings reads and parses Claude Code's settings.json
-func readClaudeSettings(path string) (map[string]interface{}, error) {
-	data, err := os.ReadFile(path)
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--git-path", "info/exclude", "--show-prefix").Output()
 	if err != nil {
-		if os.IsNotExist(err) {
-			// No settings file yet — start fresh
-			return make(map[string]interface{}), nil
-		}
-		return nil, err
+		return err
 	}
-
-	var settings map[string]interface{}
-	if err := json.Unmarshal(data, &settings); err != nil {
-		return nil, err
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	if len(lines) != 2 {
+		return fmt.Errorf("unexpected git rev-parse output %q", out)
+	}
+	exclude, prefix := lines[0], lines[1]
+	if !filepath.IsAbs(exclude) {
+		exclude = filepath.Join(dir, exclude)
 	}
-	return settings, nil
-}
 
-// writeClaudeSettings writes settings back to Claude Code's settings.json
-func writeClaudeSettings(path string, settings map[string]interface{}) error {
-	// Ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(exclude), DirPermissions); err != nil {
 		return err
 	}
-
-	data, err := json.MarshalIndent(settings, "", "  ")
+	f, err := os.OpenFile(exclude, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "/%s%s\n", prefix, name)
+	return err
+}
 
-	return os.WriteFile(path, append(data, '\n'), 0644)
+// ensureClaudeHooksInstalled adds the aipilot hook entries for agent status
+// detection to the directory's Claude settings if not already present.
+func ensureClaudeHooksInstalled(workDir string) {
+	ensureHooksInstalled(claudeHookTarget(workDir))
+}
+
+// claudeHook returns the aipilot hook for an event. PreToolUse runs
+// synchronously: Claude waits for the decision from mobile (see approval.go).
+// Hooks installed before that ran it async; `aipilot-cli hooks repair` fixes them.
+func claudeHook(eventName string) *jsonObject {
+	hook := newJSONObject()
+	hook.Set("type", "command")
+	hook.Set("command", hookCommand)
+	if eventName == "PreToolUse" {
+		hook.Set("timeout", ApprovalHookTimeout)
+		return hook
+	}
+	hook.Set("timeout", 5)
+	hook.Set("async", true)
+	return hook
 }
@@ -1,48 +1,59 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"time"
+	"unicode/utf8"
 )
 
 // agentEventMain is the entry point for --hook mode.
-// It reads a Claude Code hook payload from stdin, maps it to a generic
-// HookMessage, and sends it to the AIPILOT_HOOK_SOCKET Unix socket.
-// Exits silently with code 0 in all cases (must not block the agent).
+// It reads a Claude Code or Gemini CLI hook payload from stdin, or a Codex
+// notify payload from the last argument, maps it to a generic HookMessage,
+// and sends it to the AIPILOT_HOOK_SOCKET Unix socket, with the
+// AIPILOT_HOOK_SECRET of the session.
+// Exits silently with code 0 in all cases (must not block the agent, except
+// PreToolUse while waiting for a decision from mobile, see approval.go).
 func agentEventMain() {
 	socketPath := os.Getenv("AIPILOT_HOOK_SOCKET")
 	if socketPath == "" {
 		// Not running under aipilot — exit silently
 		os.Exit(0)
 	}
+	secret := os.Getenv("AIPILOT_HOOK_SECRET")
 
-	// Read hook payload from stdin
-	input, err := io.ReadAll(os.Stdin)
-	if err != nil || len(input) == 0 {
-		os.Exit(0)
+	// Read hook payload: Codex passes it as argument, others on stdin
+	var input []byte
+	if args := flag.Args(); len(args) > 0 {
+		input = []byte(args[len(args)-1])
+	} else {
+		var err error
+		input, err = io.ReadAll(os.Stdin)
+		if err != nil || len(input) == 0 {
+			os.Exit(0)
+		}
 	}
 
-	// Extract hook_event_name from the payload
-	var payload struct {
-		HookEventName string `json:"hook_event_name"`
-	}
-	if err := json.Unmarshal(input, &payload); err != nil {
+	payload, err := parseHookPayload(input)
+	if err != nil {
 		os.Exit(0)
 	}
 
-	// Map agent-specific event to generic hook message
-	msg := mapHookEvent(payload.HookEventName)
-	if msg == nil {
-		// Unknown or unhandled event — ignore
-		os.Exit(0)
+	// Map agent-specific event to generic hook messages
+	var messages []*HookMessage
+	if msg := mapHookEvent(payload.eventName()); msg != nil {
+		messages = append(messages, msg)
 	}
-
-	// Send to socket
-	data, err := json.Marshal(msg)
-	if err != nil {
+	if msg := mapHookDetails(payload); msg != nil {
+		messages = append(messages, msg)
+	}
+	if len(messages) == 0 {
+		// Unknown or unhandled event — ignore
 		os.Exit(0)
 	}
 
@@ -53,16 +64,104 @@ func agentEventMain() {
 	}
 	defer conn.Close()
 
-	fmt.Fprintf(conn, "%s\n", data)
+	// Send to socket, one message per line
+	for _, msg := range messages {
+		msg.Secret = secret
+		data, err := json.Marshal(msg)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(conn, "%s\n", data)
+	}
+
+	// Claude waits for this hook before running a tool: the phone may decide
+	// when Claude would ask
+	if payload.HookEventName == "PreToolUse" && payload.wouldPrompt(loadClaudePermissions(claudeProjectDir(payload))) {
+		if output := requestApproval(conn, secret, payload); output != nil {
+			os.Stdout.Write(output)
+		}
+	}
+}
+
+// requestApproval sends an approval request on the hook socket and waits for
+// the decision. Returns the PreToolUse hook output for Claude, or nil to let
+// Claude ask in the terminal.
+func requestApproval(conn net.Conn, secret string, payload hookPayload) []byte {
+	data, err := json.Marshal(toolUseData("pre", payload))
+	if err != nil {
+		return nil
+	}
+	request, _ := json.Marshal(HookMessage{Event: "approval_request", Data: data, Secret: secret})
+	if _, err := fmt.Fprintf(conn, "%s\n", request); err != nil {
+		return nil
+	}
+
+	// The daemon answers within ApprovalTimeout, plus a margin
+	conn.SetReadDeadline(time.Now().Add(ApprovalTimeout + 5*time.Second))
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return nil
+	}
+	var decision ApprovalDecision
+	if err := json.Unmarshal(line, &decision); err != nil || decision.Decision == "" {
+		return nil
+	}
+
+	reason := decision.Reason
+	if reason == "" {
+		reason = "Decided from the AIPilot mobile app"
+	}
+	output, err := json.Marshal(map[string]interface{}{
+		"hookSpecificOutput": map[string]string{
+			"hookEventName":            "PreToolUse",
+			"permissionDecision":       decision.Decision,
+			"permissionDecisionReason": reason,
+		},
+	})
+	if err != nil {
+		return nil
+	}
+	return output
+}
+
+// hookPayload is the part of agent hook payloads aipilot uses
+type hookPayload struct {
+	HookEventName  string                 `json:"hook_event_name"` // Claude Code, Gemini CLI
+	Type           string                 `json:"type"`            // Codex notify
+	ToolName       string                 `json:"tool_name"`
+	ToolInput      map[string]interface{} `json:"tool_input"`
+	PermissionMode string                 `json:"permission_mode"` // Claude Code
+	Cwd            string                 `json:"cwd"`             // Claude Code
+	Message        string                 `json:"message"`         // Notification text
+	Title          string                 `json:"title"`
+}
+
+// parseHookPayload parses the JSON payload of a hook
+func parseHookPayload(input []byte) (hookPayload, error) {
+	var payload hookPayload
+	err := json.Unmarshal(input, &payload)
+	return payload, err
+}
+
+// eventName returns the event of the payload: hook_event_name for Claude
+// Code and Gemini CLI, type for Codex notify
+func (p hookPayload) eventName() string {
+	if p.HookEventName != "" {
+		return p.HookEventName
+	}
+	return p.Type
 }
 
 // mapHookEvent maps a hook event name to a generic HookMessage.
-// Currently supports Claude Code events. Add other agents here.
+// Supports Claude Code, Gemini CLI and Codex events. Add other agents here.
 func mapHookEvent(eventName string) *HookMessage {
 	switch eventName {
-	case "UserPromptSubmit":
+	case "UserPromptSubmit", // Claude
+		"BeforeAgent": // Gemini
 		return newAgentStatusMessage("busy")
-	case "Stop", "StopFailure", "Notification":
+	case "Stop", "StopFailure", "Notification", // Claude (Gemini: Notification)
+		"AfterAgent", "SessionEnd", // Gemini
+		"agent-turn-complete": // Codex
 		return newAgentStatusMessage("idle")
 	default:
 		return nil
@@ -77,3 +176,76 @@ func newAgentStatusMessage(status string) *HookMessage {
 		Data:  data,
 	}
 }
+
+// mapHookDetails maps tool use, notification and subagent events to a
+// HookMessage with their details. Returns nil for other events.
+func mapHookDetails(p hookPayload) *HookMessage {
+	var event string
+	var data interface{}
+
+	switch p.eventName() {
+	case "PreToolUse", // Claude
+		"BeforeTool": // Gemini
+		event, data = "tool_use", toolUseData("pre", p)
+	case "PostToolUse", // Claude
+		"AfterTool": // Gemini
+		event, data = "tool_use", toolUseData("post", p)
+	case "Notification":
+		if p.Message == "" {
+			return nil
+		}
+		event, data = "notification", NotificationData{
+			Message: truncateHookField(p.Message),
+			Title:   truncateHookField(p.Title),
+		}
+	case "SubagentStop":
+		event, data = "subagent_stop", struct{}{}
+	default:
+		return nil
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+	return &HookMessage{Event: event, Data: encoded}
+}
+
+// toolUseData extracts what a tool is about to do, or did, from its input.
+// Only the command, paths and pattern are kept: file contents never leave.
+func toolUseData(phase string, p hookPayload) ToolUseData {
+	data := ToolUseData{Phase: phase, Tool: p.ToolName}
+	if command, ok := p.ToolInput["command"].(string); ok {
+		data.Command = truncateHookField(command)
+	}
+	if pattern, ok := p.ToolInput["pattern"].(string); ok {
+		data.Pattern = truncateHookField(pattern)
+	}
+	for _, key := range []string{"file_path", "notebook_path", "absolute_path", "path"} {
+		if path, ok := p.ToolInput[key].(string); ok && path != "" {
+			data.FilePaths = append(data.FilePaths, path)
+			break
+		}
+	}
+	if paths, ok := p.ToolInput["paths"].([]interface{}); ok {
+		for _, path := range paths {
+			if s, ok := path.(string); ok {
+				data.FilePaths = append(data.FilePaths, s)
+			}
+		}
+	}
+	return data
+}
+
+// truncateHookField bounds a text field forwarded to mobile, cutting it on a
+// character boundary
+func truncateHookField(s string) string {
+	if len(s) <= MaxHookFieldLength {
+		return s
+	}
+	cut := MaxHookFieldLength - 3
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Codex CLI specific hook installation.
+// Codex runs the top-level `notify` program of ~/.codex/config.toml when a
+// turn completes, with a JSON payload ({"type": "agent-turn-complete", ...})
+// as last argument. It has no event for the start of a turn, so Codex gets
+// idle from the hook and busy from the PTY scan.
+
+// codexNotifyValue is the notify setting we install
+const codexNotifyValue = `["aipilot-cli", "--agent-event"]`
+
+// codexConfigPath returns the path to Codex's config.toml
+func codexConfigPath() string {
+	if dir := os.Getenv("CODEX_HOME"); dir != "" {
+		return filepath.Join(dir, "config.toml")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".codex", "config.toml")
+}
+
+// codexHookTarget returns the aipilot notify program in ~/.codex/config.toml
+func codexHookTarget() hookTarget {
+	return &codexNotifyTarget{file: codexConfigPath()}
+}
+
+// ensureCodexHooksInstalled sets aipilot as the Codex notify program if
+// config.toml doesn't have one. A user's own notify program is left alone.
+// Codex only has a user-wide config.
+func ensureCodexHooksInstalled() {
+	ensureHooksInstalled(codexHookTarget())
+}
+
+// errCodexNotifyTaken is returned when installing over another notify program
+var errCodexNotifyTaken = errors.New("Codex already has a notify program, agent status uses output scanning")
+
+// codexNotifyTarget is the notify key of a Codex config.toml
+type codexNotifyTarget struct {
+	file string
+}
+
+func (t *codexNotifyTarget) agent() string { return "codex" }
+func (t *codexNotifyTarget) path() string  { return t.file }
+
+// read returns the config, "" if there is none yet
+func (t *codexNotifyTarget) read() (string, error) {
+	data, err := os.ReadFile(t.file)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (t *codexNotifyTarget) check() ([]hookEntryState, error) {
+	config, err := t.read()
+	if err != nil {
+		return nil, err
+	}
+	state := hookMissing
+	switch _, status := addCodexNotify(config); status {
+	case codexNotifyInstalled:
+		state = hookInstalled
+	case codexNotifyTaken:
+		state = hookTaken
+	}
+	return []hookEntryState{{Name: "notify", State: state}}, nil
+}
+
+func (t *codexNotifyTarget) install(repair bool) (bool, error) {
+	config, err := t.read()
+	if err != nil {
+		return false, err
+	}
+	updated, status := addCodexNotify(config)
+	switch status {
+	case codexNotifyInstalled:
+		return false, nil
+	case codexNotifyTaken:
+		return false, errCodexNotifyTaken
+	}
+	return true, writeSettingsWithBackup(t.file, []byte(updated))
+}
+
+func (t *codexNotifyTarget) uninstall() (bool, error) {
+	config, err := t.read()
+	if err != nil {
+		return false, err
+	}
+	updated, removed := removeCodexNotify(config)
+	if !removed {
+		return false, nil
+	}
+	return true, writeSettingsWithBackup(t.file, []byte(updated))
+}
+
+// Result of addCodexNotify
+const (
+	codexNotifyAdded     = iota // notify added
+	codexNotifyInstalled        // aipilot already is the notify program
+	codexNotifyTaken            // Another notify program is configured
+)
+
+// addCodexNotify adds the notify key to a config.toml. Only text is inserted:
+// comments, formatting and all other settings are kept as they are.
+// The key must be top-level, so it goes before the first table header.
+func addCodexNotify(config string) (string, int) {
+	lines := strings.SplitAfter(config, "\n")
+	start, insertAt := codexNotifyLines(lines)
+	if start >= 0 {
+		if strings.Contains(strings.Join(lines[start:insertAt], ""), "aipilot-cli") {
+			return config, codexNotifyInstalled
+		}
+		return config, codexNotifyTaken
+	}
+
+	entry := "notify = " + codexNotifyValue + "\n"
+	before := strings.Join(lines[:insertAt], "")
+	after := strings.Join(lines[insertAt:], "")
+	if before != "" && !strings.HasSuffix(before, "\n") {
+		before += "\n"
+	}
+	if after != "" {
+		entry += "\n"
+	}
+	return before + entry + after, codexNotifyAdded
+}
+
+// codexNotifyLines locates the top-level notify key of a config.toml split
+// in lines: its value is lines[start:end], which may span lines. Without the
+// key, start is -1 and end is the first table header, or len(lines).
+func codexNotifyLines(lines []string) (start, end int) {
+	var scan tomlScanner
+	for i, line := range lines {
+		if !scan.atTopOfValue() {
+			scan.feed(line)
+			continue
+		}
+		key := strings.TrimSpace(line)
+		if strings.HasPrefix(key, "[") {
+			return -1, i
+		}
+		name, _, ok := strings.Cut(key, "=")
+		if !ok || strings.Trim(strings.TrimSpace(name), `"'`) != "notify" {
+			scan.feed(line)
+			continue
+		}
+
+		end := i + 1
+		for scan.feed(line); !scan.atTopOfValue() && end < len(lines); end++ {
+			scan.feed(lines[end])
+		}
+		return i, end
+	}
+	return -1, len(lines)
+}
+
+// tomlScanner tracks whether a line starts inside a multi-line value
+// (array, inline table or multi-line string), where "[" or "key =" at the
+// start of a line is not a header or key.
+type tomlScanner struct {
+	depth     int    // Open [ and {
+	multiline string // Open """ or ''', "" when none
+}
+
+func (s *tomlScanner) atTopOfValue() bool {
+	return s.depth == 0 && s.multiline == ""
+}
+
+// feed consumes one line
+func (s *tomlScanner) feed(line string) {
+	for i := 0; i < len(line); i++ {
+		rest := line[i:]
+		if s.multiline != "" {
+			if s.multiline == `"""` && line[i] == '\\' {
+				i++ // Escaped character
+				continue
+			}
+			if strings.HasPrefix(rest, s.multiline) {
+				i += len(s.multiline) - 1
+				s.multiline = ""
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(rest, `"""`), strings.HasPrefix(rest, `'''`):
+			s.multiline = rest[:3]
+			i += 2
+		case line[i] == '"':
+			// Basic string, ends on this line
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+		case line[i] == '\'':
+			// Literal string, no escapes
+			for i++; i < len(line) && line[i] != '\''; i++ {
+			}
+		case line[i] == '#':
+			return
+		case line[i] == '[' || line[i] == '{':
+			s.depth++
+		case line[i] == ']' || line[i] == '}':
+			if s.depth > 0 {
+				s.depth--
+			}
+		}
+	}
+}
+
+// removeCodexNotify removes the top-level notify key if it runs aipilot,
+// with the blank line addCodexNotify put before the next table header.
+func removeCodexNotify(config string) (string, bool) {
+	lines := strings.SplitAfter(config, "\n")
+	start, end := codexNotifyLines(lines)
+	if start < 0 || !strings.Contains(strings.Join(lines[start:end], ""), "aipilot-cli") {
+		return config, false
+	}
+	if end+1 < len(lines) && strings.TrimSpace(lines[end]) == "" && strings.HasPrefix(strings.TrimSpace(lines[end+1]), "[") {
+		end++
+	}
+	return strings.Join(lines[:start], "") + strings.Join(lines[end:], ""), true
+}
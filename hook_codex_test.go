@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddCodexNotify(t *testing.T) {
+	config := `# My Codex settings
+model = "o3"
+instructions = """
+[not a header]
+notify = "not a key"
+"""
+writable_roots = [
+  "/tmp",
+  ["nested"],
+]
+
+[tui]
+notify = true # Table key, not the top-level notify
+
+[mcp_servers.docs]
+command = "docs-mcp"
+`
+	updated, status := addCodexNotify(config)
+	if status != codexNotifyAdded {
+		t.Fatalf("expected notify to be added, got %d", status)
+	}
+
+	want := strings.Replace(config, "\n[tui]", "\nnotify = "+codexNotifyValue+"\n\n[tui]", 1)
+	if updated != want {
+		t.Fatalf("unexpected config:\n%s", updated)
+	}
+
+	if _, status := addCodexNotify(updated); status != codexNotifyInstalled {
+		t.Fatalf("expected notify to be detected as installed, got %d", status)
+	}
+}
+
+func TestAddCodexNotify_KeepsUserNotify(t *testing.T) {
+	config := "notify = [\"notify-send\", \"Codex\"]\n"
+	updated, status := addCodexNotify(config)
+	if status != codexNotifyTaken || updated != config {
+		t.Fatalf("user notify must be kept, got %d:\n%s", status, updated)
+	}
+}
+
+func TestAddCodexNotify_MultiLineValue(t *testing.T) {
+	config := "notify = [\n  \"aipilot-cli\",\n  \"--agent-event\",\n]\n\n[tui]\nnotifications = true\n"
+	if updated, status := addCodexNotify(config); status != codexNotifyInstalled || updated != config {
+		t.Fatalf("multi-line aipilot notify not recognized, got %d:\n%s", status, updated)
+	}
+	user := "notify = [\n  \"notify-send\",\n]\n"
+	if _, status := addCodexNotify(user); status != codexNotifyTaken {
+		t.Fatalf("multi-line user notify not recognized, got %d", status)
+	}
+}
+
+func TestAddCodexNotify_EmptyAndUnterminated(t *testing.T) {
+	if updated, _ := addCodexNotify(""); updated != "notify = "+codexNotifyValue+"\n" {
+		t.Fatalf("unexpected config for empty file: %q", updated)
+	}
+	if updated, _ := addCodexNotify(`model = "o3"`); updated != "model = \"o3\"\nnotify = "+codexNotifyValue+"\n" {
+		t.Fatalf("unexpected config: %q", updated)
+	}
+}
+
+func TestHookPayload_CodexEventName(t *testing.T) {
+	payload, err := parseHookPayload([]byte(`{"type": "agent-turn-complete", "turn-id": "1", "last-assistant-message": "done"}`))
+	name := payload.eventName()
+	if err != nil || name != "agent-turn-complete" || mapHookEvent(name) == nil {
+		t.Fatalf("unexpected event %q (%v)", name, err)
+	}
+	payload, _ = parseHookPayload([]byte(`{"hook_event_name": "Stop", "type": "ignored"}`))
+	if payload.eventName() != "Stop" {
+		t.Fatalf("hook_event_name must win, got %q", payload.eventName())
+	}
+}
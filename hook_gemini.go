@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Gemini CLI specific hook installation.
+// Gemini CLI reads hooks from ~/.gemini/settings.json, in the same shape as
+// Claude Code, and passes hook_event_name on stdin. Its events are mapped
+// onto HookMessage in mapHookEvent.
+
+// geminiSettingsPath returns the path to Gemini CLI's settings.json
+func geminiSettingsPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".gemini", "settings.json")
+}
+
+// geminiHookEvents lists the Gemini CLI events we want to hook into
+var geminiHookEvents = []string{"BeforeAgent", "AfterAgent", "Notification", "SessionEnd", "BeforeTool", "AfterTool"}
+
+// geminiHookTimeout is the hook timeout, in milliseconds for Gemini CLI
+const geminiHookTimeout = 5000
+
+// geminiHookTarget returns the aipilot hooks in ~/.gemini/settings.json
+func geminiHookTarget() hookTarget {
+	return &jsonHookTarget{
+		name:   "gemini",
+		file:   geminiSettingsPath(),
+		events: geminiHookEvents,
+		hook:   geminiHook,
+		enable: enableGeminiHooks,
+	}
+}
+
+// ensureGeminiHooksInstalled adds the aipilot hook entries for agent status
+// detection to ~/.gemini/settings.json if not already present. Gemini hooks
+// are always user-wide.
+func ensureGeminiHooksInstalled(workDir string) {
+	ensureHooksInstalled(geminiHookTarget())
+}
+
+// geminiHook returns the aipilot hook for a Gemini CLI event
+func geminiHook(eventName string) *jsonObject {
+	hook := newJSONObject()
+	hook.Set("type", "command")
+	hook.Set("command", hookCommand)
+	hook.Set("timeout", geminiHookTimeout)
+	return hook
+}
+
+// enableGeminiHooks turns on tools.enableHooks, which Gemini CLI needs to run
+// hooks. An explicit opt-out is respected. Returns true if settings changed.
+func enableGeminiHooks(settings *jsonObject) bool {
+	tools := settings.Object("tools")
+	if tools == nil {
+		if _, exists := settings.Get("tools"); exists {
+			return false
+		}
+		tools = newJSONObject()
+		settings.Set("tools", tools)
+	}
+	if _, set := tools.Get("enableHooks"); set {
+		return false
+	}
+	tools.Set("enableHooks", true)
+	return true
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeminiHooksInstall(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	os.WriteFile(path, []byte(`{
+		"theme": "Dracula",
+		"tools": {"enableHooks": false},
+		"hooks": {"AfterAgent": [{"matcher": "", "hooks": [{"type": "command", "command": "notify-send done"}]}]}
+	}`), 0600)
+	target := &jsonHookTarget{name: "gemini", file: path, events: geminiHookEvents, hook: geminiHook, enable: enableGeminiHooks}
+
+	if changed, err := target.install(false); err != nil || !changed {
+		t.Fatalf("expected settings to be modified, got %v, %v", changed, err)
+	}
+	if changed, _ := target.install(false); changed {
+		t.Fatal("second install must be a no-op")
+	}
+
+	f, err := loadSettingsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if theme, _ := f.root.Get("theme"); theme != "Dracula" {
+		t.Fatal("unrelated settings lost")
+	}
+	if enabled, _ := f.root.Object("tools").Get("enableHooks"); enabled != false {
+		t.Fatal("explicit enableHooks opt-out overwritten")
+	}
+	hooks := f.root.Object("hooks")
+	afterAgent := hooks.Array("AfterAgent")
+	if len(afterAgent) != 2 || firstAipilotHook(afterAgent) == nil {
+		t.Fatalf("expected user hook kept and aipilot hook added, got %v", afterAgent)
+	}
+	for _, event := range geminiHookEvents {
+		if firstAipilotHook(hooks.Array(event)) == nil {
+			t.Errorf("hook missing for %s", event)
+		}
+	}
+}
+
+func TestEnableGeminiHooks(t *testing.T) {
+	settings, _ := parseJSONObject([]byte(`{"theme": "Dracula"}`))
+	if !enableGeminiHooks(settings) {
+		t.Fatal("expected enableHooks to be set")
+	}
+	if enabled, _ := settings.Object("tools").Get("enableHooks"); enabled != true {
+		t.Fatalf("enableHooks = %v", enabled)
+	}
+}
+
+func TestMapHookEvent_Gemini(t *testing.T) {
+	cases := map[string]string{"BeforeAgent": "busy", "AfterAgent": "idle", "SessionEnd": "idle"}
+	for event, want := range cases {
+		msg := mapHookEvent(event)
+		if msg == nil {
+			t.Fatalf("%s not mapped", event)
+		}
+		var data AgentStatusData
+		json.Unmarshal(msg.Data, &data)
+		if data.Status != want {
+			t.Errorf("%s: got %s, want %s", event, data.Status, want)
+		}
+	}
+}
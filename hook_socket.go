@@ -2,18 +2,29 @@ package main
 
 import (
 	"bufio"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 )
 
+// The hook socket only accepts the agent started by this session:
+//   - it lives in a private (0700) runtime directory of the user,
+//   - on Linux, the peer's uid (SO_PEERCRED) must be ours,
+//   - each message carries the session's secret, passed to the agent in
+//     AIPILOT_HOOK_SECRET next to AIPILOT_HOOK_SOCKET.
+
 // HookMessage is the generic JSON format received on the hook socket.
 // Extensible: new event types can be added without changing the socket protocol.
 type HookMessage struct {
-	Event string          `json:"event"`
-	Data  json.RawMessage `json:"data"`
+	Event  string          `json:"event"`
+	Data   json.RawMessage `json:"data"`
+	Secret string          `json:"secret"` // AIPILOT_HOOK_SECRET of the session
 }
 
 // AgentStatusData is the data payload for "agent_status" events
@@ -21,26 +32,86 @@ type AgentStatusData struct {
 	Status string `json:"status"` // "busy" or "idle"
 }
 
+// ToolUseData is the data payload for "tool_use" events
+type ToolUseData struct {
+	Phase     string   `json:"phase"` // "pre" (about to run) or "post" (done)
+	Tool      string   `json:"tool"`
+	Command   string   `json:"command,omitempty"` // Shell command
+	FilePaths []string `json:"file_paths,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"` // Search pattern
+}
+
+// NotificationData is the data payload for "notification" events, from the
+// agent or from `aipilot-cli notify`
+type NotificationData struct {
+	Message string `json:"message"`
+	Title   string `json:"title,omitempty"`
+	Level   string `json:"level,omitempty"`  // NotifyLevel*, for script notifications
+	Source  string `json:"source,omitempty"` // notifySourceScript, "" for the agent
+}
+
+// hookRuntimeDir returns the private directory holding the hook sockets:
+// $XDG_RUNTIME_DIR/aipilot, or aipilot-<uid> in the temp directory. It is
+// created 0700 and refused if another user owns it.
+func hookRuntimeDir() (string, error) {
+	base, name := os.Getenv("XDG_RUNTIME_DIR"), "aipilot"
+	if base == "" {
+		// The temp directory is shared between users
+		base = os.TempDir()
+		if uid := os.Getuid(); uid >= 0 {
+			name += "-" + strconv.Itoa(uid)
+		}
+	}
+	dir := filepath.Join(base, name)
+
+	if err := os.Mkdir(dir, DirPermissions); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	if err := checkPrivateDir(dir, info); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
 // startHookSocket creates a Unix domain socket and listens for hook events.
 // Each connection sends one JSON message per line, then closes.
-func (d *Daemon) startHookSocket(socketPath string) {
+// Returns the variables to pass to the agent, nil if the socket is unavailable.
+func (d *Daemon) startHookSocket() []string {
+	dir, err := hookRuntimeDir()
+	if err != nil {
+		fmt.Printf("%s[hook] No private directory for the hook socket: %v%s\n", dim, err, reset)
+		return nil
+	}
+	socketPath := filepath.Join(dir, d.session+".sock")
+
 	// Remove stale socket file if it exists
 	os.Remove(socketPath)
 
 	listener, err := net.Listen("unix", socketPath)
 	if err != nil {
 		fmt.Printf("%s[hook] Failed to create socket %s: %v%s\n", dim, socketPath, err, reset)
-		return
+		return nil
 	}
+	os.Chmod(socketPath, FilePermissions)
 
+	secret := generateRandomToken()
 	d.mu.Lock()
 	d.hookSocketListener = listener
 	d.hookSocketPath = socketPath
+	d.hookSecret = secret
 	d.mu.Unlock()
 
 	fmt.Printf("%s[hook] Listening on %s%s\n", dim, socketPath, reset)
 
 	go d.hookSocketAcceptLoop(listener)
+	return []string{"AIPILOT_HOOK_SOCKET=" + socketPath, "AIPILOT_HOOK_SECRET=" + secret}
 }
 
 // hookSocketAcceptLoop accepts connections and processes messages
@@ -52,6 +123,11 @@ func (d *Daemon) hookSocketAcceptLoop(listener net.Listener) {
 			// Listener closed (shutdown)
 			break
 		}
+		if err := checkHookPeer(conn); err != nil {
+			fmt.Printf("%s[hook] Rejected connection: %v%s\n", dim, err, reset)
+			conn.Close()
+			continue
+		}
 		wg.Add(1)
 		go func(c net.Conn) {
 			defer wg.Done()
@@ -76,16 +152,40 @@ func (d *Daemon) hookSocketHandleConn(conn net.Conn) {
 			fmt.Printf("%s[hook] Invalid JSON: %v%s\n", dim, err, reset)
 			continue
 		}
+		if !d.validHookSecret(msg.Secret) {
+			fmt.Printf("%s[hook] Rejected message with a wrong secret%s\n", dim, reset)
+			return
+		}
+
+		// The hook waits for the answer on this connection
+		if msg.Event == "approval_request" {
+			d.handleApprovalRequest(conn, msg.Data)
+			continue
+		}
 
 		d.handleHookMessage(msg)
 	}
 }
 
+// validHookSecret reports whether a message carries the session's hook secret
+func (d *Daemon) validHookSecret(secret string) bool {
+	d.mu.RLock()
+	expected := d.hookSecret
+	d.mu.RUnlock()
+	return expected != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) == 1
+}
+
 // handleHookMessage dispatches a hook message by event type
 func (d *Daemon) handleHookMessage(msg HookMessage) {
 	switch msg.Event {
 	case "agent_status":
 		d.handleHookAgentStatus(msg.Data)
+	case "tool_use":
+		d.handleHookToolUse(msg.Data)
+	case "notification":
+		d.handleHookNotification(msg.Data)
+	case "subagent_stop":
+		d.forwardHookEvent("subagent-stop", nil)
 	default:
 		fmt.Printf("%s[hook] Unknown event: %s%s\n", dim, msg.Event, reset)
 	}
@@ -99,8 +199,11 @@ func (d *Daemon) handleHookAgentStatus(data json.RawMessage) {
 		return
 	}
 
-	// Mark that we're receiving status via socket — disable PTY scan
-	d.agentStatusViaSocket = true
+	// Mark that we're receiving busy status via socket — disable PTY scan.
+	// Agents that only report idle (Codex) keep the scan for busy.
+	if status.Status == "busy" {
+		d.agentStatusViaSocket = true
+	}
 
 	switch status.Status {
 	case "busy":
@@ -110,18 +213,95 @@ func (d *Daemon) handleHookAgentStatus(data json.RawMessage) {
 		}
 		if !d.agentBusy {
 			d.agentBusy = true
-			d.sendControlMessage("agent-status:busy")
+			d.sendControl("agent-status", "", agentStatusPayload{Status: "busy"})
 			fmt.Printf("%s[hook] Agent status: busy%s\n", dim, reset)
 		}
 	case "idle":
 		if d.agentBusy {
 			d.agentBusy = false
-			d.sendControlMessage("agent-status:idle")
+			d.sendControl("agent-status", "", agentStatusPayload{Status: "idle"})
 			fmt.Printf("%s[hook] Agent status: idle%s\n", dim, reset)
 		}
 	}
 }
 
+// handleHookToolUse forwards a tool use to mobile, with a readable summary
+func (d *Daemon) handleHookToolUse(data json.RawMessage) {
+	var tool ToolUseData
+	if err := json.Unmarshal(data, &tool); err != nil {
+		fmt.Printf("%s[hook] Invalid tool_use data: %v%s\n", dim, err, reset)
+		return
+	}
+	for i, path := range tool.FilePaths {
+		tool.FilePaths[i] = d.relativePath(path)
+	}
+	d.forwardHookEvent("tool-use", toolUsePayload{ToolUseData: tool, Summary: toolSummary(tool)})
+}
+
+// handleHookNotification forwards the text of an agent notification to mobile
+func (d *Daemon) handleHookNotification(data json.RawMessage) {
+	var notification NotificationData
+	if err := json.Unmarshal(data, &notification); err != nil {
+		fmt.Printf("%s[hook] Invalid notification data: %v%s\n", dim, err, reset)
+		return
+	}
+
+	if notification.Source == notifySourceScript {
+		if !validNotifyLevel(notification.Level) {
+			notification.Level = NotifyLevelInfo
+		}
+		text := notification.Message
+		if notification.Title != "" {
+			text = notification.Title + ": " + text
+		}
+		fmt.Printf("%s[notify] %s%s\n", dim, text, reset)
+		d.forwardHookEvent("notification", notification)
+		return
+	}
+	d.forwardHookEvent("agent-notification", notification)
+}
+
+// forwardHookEvent sends a hook event to mobile. Legacy apps would show the
+// unknown message, so only protocol v2 apps get them.
+func (d *Daemon) forwardHookEvent(msgType string, payload interface{}) {
+	if d.mobileProtocolVersion() < 2 {
+		return
+	}
+	d.sendControl(msgType, "", payload)
+}
+
+// relativePath shortens a path inside the working directory
+func (d *Daemon) relativePath(path string) string {
+	if rel, err := filepath.Rel(d.workDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// toolSummary describes a tool use in a few words: "Run `make test`", "Edit src/main.go"
+func toolSummary(tool ToolUseData) string {
+	if tool.Command != "" {
+		return "Run `" + tool.Command + "`"
+	}
+
+	verb := tool.Tool
+	name := strings.ToLower(tool.Tool)
+	switch {
+	case strings.Contains(name, "edit") || strings.Contains(name, "replace"):
+		verb = "Edit"
+	case strings.Contains(name, "write"):
+		verb = "Write"
+	case strings.Contains(name, "read"):
+		verb = "Read"
+	case tool.Pattern != "":
+		return fmt.Sprintf("Search %q", tool.Pattern)
+	}
+	if len(tool.FilePaths) > 0 {
+		return verb + " " + strings.Join(tool.FilePaths, ", ")
+	}
+	return verb
+}
+
 // stopHookSocket closes the socket listener and removes the socket file
 func (d *Daemon) stopHookSocket() {
 	d.mu.Lock()
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMapHookDetails_ToolUse(t *testing.T) {
+	payload, _ := parseHookPayload([]byte(`{
+		"hook_event_name": "PreToolUse",
+		"tool_name": "Bash",
+		"tool_input": {"command": "rm -rf build/", "description": "Clean"}
+	}`))
+	msg := mapHookDetails(payload)
+	if msg == nil || msg.Event != "tool_use" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	var tool ToolUseData
+	json.Unmarshal(msg.Data, &tool)
+	if tool.Phase != "pre" || tool.Tool != "Bash" || tool.Command != "rm -rf build/" {
+		t.Fatalf("unexpected tool use %+v", tool)
+	}
+	if toolSummary(tool) != "Run `rm -rf build/`" {
+		t.Fatalf("unexpected summary %q", toolSummary(tool))
+	}
+
+	// File contents are never forwarded
+	payload, _ = parseHookPayload([]byte(`{
+		"hook_event_name": "PostToolUse",
+		"tool_name": "Write",
+		"tool_input": {"file_path": "/work/src/main.go", "content": "secret"}
+	}`))
+	msg = mapHookDetails(payload)
+	if strings.Contains(string(msg.Data), "secret") {
+		t.Fatalf("content forwarded: %s", msg.Data)
+	}
+	json.Unmarshal(msg.Data, &tool)
+	if tool.Phase != "post" || !reflect.DeepEqual(tool.FilePaths, []string{"/work/src/main.go"}) {
+		t.Fatalf("unexpected tool use %+v", tool)
+	}
+
+	d := &Daemon{workDir: "/work"}
+	if got := d.relativePath("/work/src/main.go"); got != "src/main.go" {
+		t.Fatalf("unexpected relative path %q", got)
+	}
+	if got := d.relativePath("/etc/hosts"); got != "/etc/hosts" {
+		t.Fatalf("paths outside the workdir must stay absolute, got %q", got)
+	}
+	if got := toolSummary(ToolUseData{Tool: "Edit", FilePaths: []string{"src/main.go"}}); got != "Edit src/main.go" {
+		t.Fatalf("unexpected summary %q", got)
+	}
+}
+
+func TestMapHookDetails_Notification(t *testing.T) {
+	payload, _ := parseHookPayload([]byte(`{"hook_event_name": "Notification", "message": "Claude needs your permission to use Bash"}`))
+	msg := mapHookDetails(payload)
+	if msg == nil || msg.Event != "notification" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	var notification NotificationData
+	json.Unmarshal(msg.Data, &notification)
+	if notification.Message != "Claude needs your permission to use Bash" {
+		t.Fatalf("unexpected notification %+v", notification)
+	}
+
+	if mapHookDetails(hookPayload{HookEventName: "Stop"}) != nil {
+		t.Fatal("Stop has no details")
+	}
+	long := hookPayload{HookEventName: "PreToolUse", ToolInput: map[string]interface{}{"command": strings.Repeat("x", 2*MaxHookFieldLength)}}
+	if len(toolUseData("pre", long).Command) != MaxHookFieldLength {
+		t.Fatal("long commands must be truncated")
+	}
+}
+
+func TestHookSocket_RequiresSessionSecret(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	d := &Daemon{session: "hook-test"}
+	env := d.startHookSocket()
+	defer d.stopHookSocket()
+	if len(env) != 2 {
+		t.Fatalf("unexpected hook env %v", env)
+	}
+	socketPath := strings.TrimPrefix(env[0], "AIPILOT_HOOK_SOCKET=")
+	secret := strings.TrimPrefix(env[1], "AIPILOT_HOOK_SECRET=")
+
+	info, err := os.Stat(filepath.Dir(socketPath))
+	if err != nil || info.Mode().Perm() != 0700 || filepath.Dir(filepath.Dir(socketPath)) != runtimeDir {
+		t.Fatalf("socket not in a private runtime directory: %s (%v)", socketPath, err)
+	}
+
+	send := func(secret string) {
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		msg, _ := json.Marshal(HookMessage{Event: "agent_status", Data: json.RawMessage(`{"status":"busy"}`), Secret: secret})
+		fmt.Fprintf(conn, "%s\n", msg)
+		conn.(*net.UnixConn).CloseWrite()
+		io.ReadAll(conn) // Until the daemon is done with the connection
+	}
+
+	send("wrong")
+	if d.agentBusy {
+		t.Fatal("message with a wrong secret accepted")
+	}
+	send(secret)
+	if !d.agentBusy {
+		t.Fatal("message with the session secret rejected")
+	}
+}
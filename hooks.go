@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Hook installation across agents.
+// Each agent with hooks has a hookTarget: the settings file aipilot writes
+// its entries into. Sessions install missing entries on launch (hookInstallers);
+// `aipilot-cli hooks` shows, installs, repairs or removes them explicitly.
+// Only aipilot's own entries (those running hookCommand) are ever touched.
+//
+// Claude hooks can be scoped to a project instead of the user (HookScope in
+// directories.json, set with `aipilot-cli hooks scope project`): they then go
+// in .claude/settings.local.json in the working directory, which is kept out
+// of git through .git/info/exclude when the project does not ignore it.
+
+// Hook scopes of a directory
+const (
+	HookScopeUser    = "user"    // User-wide agent settings
+	HookScopeProject = "project" // Project-local settings in the working directory
+)
+
+// States of an aipilot hook entry
+const (
+	hookInstalled = "installed"
+	hookMissing   = "missing"
+	hookOutdated  = "outdated" // Installed with other options, fixed by repair
+	hookTaken     = "taken"    // The agent only has one slot and another program uses it
+)
+
+// hookEntryState is the state of one aipilot entry in a settings file
+type hookEntryState struct {
+	Name  string // Event (or setting) name
+	State string
+}
+
+// hookTarget is an agent settings file that aipilot installs hooks into
+type hookTarget interface {
+	agent() string
+	path() string // "" when it cannot be located
+	check() ([]hookEntryState, error)
+	// install adds missing entries, and with repair also rewrites outdated
+	// ones and drops duplicates. Returns whether the file changed.
+	install(repair bool) (bool, error)
+	// uninstall removes all aipilot entries. Returns whether the file changed.
+	uninstall() (bool, error)
+}
+
+// hookTargets returns the hook targets of all supported agents for a working directory
+func hookTargets(workDir string) []hookTarget {
+	return []hookTarget{claudeHookTarget(workDir), geminiHookTarget(), codexHookTarget()}
+}
+
+// directoryHookScope returns the hook scope of a directory, HookScopeUser by default
+func directoryHookScope(workDir string) string {
+	if dc, ok := getDirectoryConfig(workDir); ok && dc.HookScope == HookScopeProject {
+		return HookScopeProject
+	}
+	return HookScopeUser
+}
+
+// ensureHooksInstalled installs missing aipilot hooks when a session starts
+func ensureHooksInstalled(target hookTarget) {
+	if target.path() == "" {
+		return
+	}
+	changed, err := target.install(false)
+	if err != nil {
+		fmt.Printf("%s[hook] Cannot install %s hooks: %v%s\n", dim, target.agent(), err, reset)
+		return
+	}
+	if changed {
+		fmt.Printf("%s[hook] Installed aipilot hooks in %s%s\n", dim, target.path(), reset)
+	}
+}
+
+// jsonHookTarget is a settings.json in the Claude Code hooks format
+// ({"hooks": {"<event>": [{"matcher": "", "hooks": [{"command": ...}]}]}}),
+// used by Claude Code and Gemini CLI
+type jsonHookTarget struct {
+	name   string
+	file   string
+	events []string
+	hook   func(event string) *jsonObject // The aipilot hook for an event
+	enable func(root *jsonObject) bool    // Sets what the agent needs to run hooks, nil if nothing
+	saved  func(file string)              // Called after the file is written, nil if nothing
+}
+
+func (t *jsonHookTarget) agent() string { return t.name }
+func (t *jsonHookTarget) path() string  { return t.file }
+
+func (t *jsonHookTarget) check() ([]hookEntryState, error) {
+	f, err := loadSettingsFile(t.file)
+	if err != nil {
+		return nil, err
+	}
+	hooks := f.root.Object("hooks")
+
+	states := make([]hookEntryState, 0, len(t.events))
+	for _, event := range t.events {
+		state := hookMissing
+		if hooks != nil {
+			if hook := firstAipilotHook(hooks.Array(event)); hook != nil {
+				state = hookOutdated
+				if sameJSON(hook, t.hook(event)) {
+					state = hookInstalled
+				}
+			}
+		}
+		states = append(states, hookEntryState{Name: event, State: state})
+	}
+	return states, nil
+}
+
+func (t *jsonHookTarget) install(repair bool) (bool, error) {
+	f, err := loadSettingsFile(t.file)
+	if err != nil {
+		return false, err
+	}
+	hooks := f.root.Object("hooks")
+	if hooks == nil {
+		if _, exists := f.root.Get("hooks"); exists {
+			return false, fmt.Errorf("%s: \"hooks\" is not an object", t.file)
+		}
+		hooks = newJSONObject()
+		f.root.Set("hooks", hooks)
+	}
+
+	changed := false
+	for _, event := range t.events {
+		entries := hooks.Array(event)
+		hook := firstAipilotHook(entries)
+		switch {
+		case hook == nil:
+			hooks.Set(event, append(entries, newHookEntry(t.hook(event))))
+			changed = true
+		case repair:
+			entries, removed := removeAipilotHooks(entries, true)
+			if removed {
+				hooks.Set(event, entries)
+				changed = true
+			}
+			if !sameJSON(hook, t.hook(event)) {
+				replaceAipilotHook(entries, t.hook(event))
+				changed = true
+			}
+		}
+	}
+	if t.enable != nil && t.enable(f.root) {
+		changed = true
+	}
+
+	if !changed {
+		return false, nil
+	}
+	if err := f.save(); err != nil {
+		return false, err
+	}
+	if t.saved != nil {
+		t.saved(t.file)
+	}
+	return true, nil
+}
+
+func (t *jsonHookTarget) uninstall() (bool, error) {
+	f, err := loadSettingsFile(t.file)
+	if err != nil || !f.exists {
+		return false, err
+	}
+	hooks := f.root.Object("hooks")
+	if hooks == nil {
+		return false, nil
+	}
+
+	// Every event, including ones aipilot no longer installs
+	changed := false
+	for _, event := range append([]string(nil), hooks.keys...) {
+		entries, removed := removeAipilotHooks(hooks.Array(event), false)
+		if !removed {
+			continue
+		}
+		changed = true
+		if len(entries) == 0 {
+			hooks.Delete(event)
+		} else {
+			hooks.Set(event, entries)
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	if hooks.Len() == 0 {
+		f.root.Delete("hooks")
+	}
+	return true, f.save()
+}
+
+// newHookEntry wraps a hook in an entry matching every tool
+func newHookEntry(hook *jsonObject) *jsonObject {
+	entry := newJSONObject()
+	entry.Set("matcher", "")
+	entry.Set("hooks", []interface{}{hook})
+	return entry
+}
+
+// isAipilotHook reports whether a hook runs the aipilot hook command
+func isAipilotHook(hook interface{}) bool {
+	obj, ok := hook.(*jsonObject)
+	if !ok {
+		return false
+	}
+	cmd, _ := obj.values["command"].(string)
+	return cmd == hookCommand
+}
+
+// firstAipilotHook returns the first aipilot hook of an event, nil if none
+func firstAipilotHook(entries []interface{}) *jsonObject {
+	for _, entry := range entries {
+		obj, ok := entry.(*jsonObject)
+		if !ok {
+			continue
+		}
+		for _, hook := range obj.Array("hooks") {
+			if isAipilotHook(hook) {
+				return hook.(*jsonObject)
+			}
+		}
+	}
+	return nil
+}
+
+// replaceAipilotHook replaces the first aipilot hook of an event in place
+func replaceAipilotHook(entries []interface{}, hook *jsonObject) {
+	for _, entry := range entries {
+		obj, ok := entry.(*jsonObject)
+		if !ok {
+			continue
+		}
+		list := obj.Array("hooks")
+		for i, h := range list {
+			if isAipilotHook(h) {
+				list[i] = hook
+				return
+			}
+		}
+	}
+}
+
+// removeAipilotHooks removes aipilot hooks from an event's entries (all of
+// them, or all but the first with keepFirst). Entries left without hooks are
+// dropped; the user's hooks and entries are kept in order.
+func removeAipilotHooks(entries []interface{}, keepFirst bool) ([]interface{}, bool) {
+	var result []interface{}
+	removed, seen := false, false
+	for _, entry := range entries {
+		obj, ok := entry.(*jsonObject)
+		if !ok || len(obj.Array("hooks")) == 0 {
+			result = append(result, entry)
+			continue
+		}
+
+		list := obj.Array("hooks")
+		var kept []interface{}
+		for _, hook := range list {
+			if isAipilotHook(hook) {
+				if keepFirst && !seen {
+					seen = true
+				} else {
+					removed = true
+					continue
+				}
+			}
+			kept = append(kept, hook)
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		if len(kept) != len(list) {
+			obj.Set("hooks", kept)
+		}
+		result = append(result, obj)
+	}
+	return result, removed
+}
+
+// sameJSON reports whether two values encode the same JSON, ignoring key order
+func sameJSON(a, b interface{}) bool {
+	return canonicalJSON(a) == canonicalJSON(b)
+}
+
+func canonicalJSON(value interface{}) string {
+	data, err := encodeJSON(value, "")
+	if err != nil {
+		return ""
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return ""
+	}
+	canonical, _ := json.Marshal(decoded) // Map keys are sorted
+	return string(canonical)
+}
+
+// hooksMain implements "aipilot-cli hooks status|install|uninstall|repair [agent...]"
+// and "aipilot-cli hooks scope [user|project]"
+func hooksMain(args []string) {
+	fs := flag.NewFlagSet("hooks", flag.ExitOnError)
+	workDirFlag := fs.String("workdir", "", "Working directory (default: current dir)")
+	configDir := fs.String("config-dir", "", "Custom config directory (default: ~/.config/aipilot)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: aipilot-cli hooks [flags] <status|install|uninstall|repair> [agent...]\n")
+		fmt.Fprintf(fs.Output(), "       aipilot-cli hooks [flags] scope [user|project]\n\n")
+		fmt.Fprintf(fs.Output(), "Manage the aipilot entries in the agents' settings files.\n")
+		fmt.Fprintf(fs.Output(), "  status     show which entries are installed\n")
+		fmt.Fprintf(fs.Output(), "  install    add missing entries\n")
+		fmt.Fprintf(fs.Output(), "  repair     add missing entries and fix outdated ones\n")
+		fmt.Fprintf(fs.Output(), "  uninstall  remove all aipilot entries\n")
+		fmt.Fprintf(fs.Output(), "  scope      show or set where Claude hooks go for the directory:\n")
+		fmt.Fprintf(fs.Output(), "             user (~/.claude/settings.json) or project (.claude/settings.local.json)\n\n")
+		fmt.Fprintf(fs.Output(), "Agents: claude, gemini, codex (default: all). A settings file is\n")
+		fmt.Fprintf(fs.Output(), "backed up next to itself (.aipilot-backup-<time>) before each edit.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *configDir != "" {
+		customConfigDir = *configDir
+	}
+	workDir := resolveWorkDir(*workDirFlag)
+
+	action := fs.Arg(0)
+	if action == "scope" {
+		hookScopeMain(workDir, fs.Args()[1:])
+		return
+	}
+	if action != "status" && action != "install" && action != "uninstall" && action != "repair" {
+		fmt.Printf("%sError: unknown action %s%s\n", red, action, reset)
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	targets, err := selectHookTargets(workDir, fs.Args()[1:])
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", red, err, reset)
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, target := range targets {
+		if target.path() == "" {
+			fmt.Printf("%s%s: settings file not found%s\n", dim, target.agent(), reset)
+			continue
+		}
+		if action == "status" {
+			failed = printHookStatus(target) || failed
+			continue
+		}
+
+		var changed bool
+		switch action {
+		case "install":
+			changed, err = target.install(false)
+		case "repair":
+			changed, err = target.install(true)
+		case "uninstall":
+			changed, err = target.uninstall()
+		}
+		switch {
+		case err != nil:
+			fmt.Printf("%s%s: %v%s\n", red, target.agent(), err, reset)
+			failed = true
+		case changed:
+			fmt.Printf("%s%s: updated %s%s\n", green, target.agent(), target.path(), reset)
+		default:
+			fmt.Printf("%s%s: nothing to do%s\n", dim, target.agent(), reset)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// selectHookTargets returns the targets of the named agents, all when none
+func selectHookTargets(workDir string, names []string) ([]hookTarget, error) {
+	all := hookTargets(workDir)
+	if len(names) == 0 {
+		return all, nil
+	}
+	var selected []hookTarget
+	for _, name := range names {
+		found := false
+		for _, target := range all {
+			if target.agent() == name {
+				selected = append(selected, target)
+				found = true
+			}
+		}
+		if !found {
+			var known []string
+			for _, target := range all {
+				known = append(known, target.agent())
+			}
+			return nil, fmt.Errorf("unknown agent %s (%s)", name, strings.Join(known, ", "))
+		}
+	}
+	return selected, nil
+}
+
+// printHookStatus prints the state of each aipilot entry of a target.
+// Returns true if the settings could not be read.
+func printHookStatus(target hookTarget) bool {
+	fmt.Printf("%s%s%s  %s%s%s\n", bold, target.agent(), reset, dim, target.path(), reset)
+	states, err := target.check()
+	if err != nil {
+		fmt.Printf("  %s%v%s\n", red, err, reset)
+		return true
+	}
+	for _, s := range states {
+		switch s.State {
+		case hookInstalled:
+			fmt.Printf("  %s✓%s %s\n", green, reset, s.Name)
+		case hookOutdated:
+			fmt.Printf("  %s!%s %s %s(outdated, run: aipilot-cli hooks repair %s)%s\n", yellow, reset, s.Name, dim, target.agent(), reset)
+		case hookTaken:
+			fmt.Printf("  %s!%s %s %s(used by another program)%s\n", yellow, reset, s.Name, dim, reset)
+		default:
+			fmt.Printf("  %s✗%s %s %s(missing)%s\n", red, reset, s.Name, dim, reset)
+		}
+	}
+	return false
+}
+
+// hookScopeMain shows or sets the hook scope of a directory
+func hookScopeMain(workDir string, args []string) {
+	if len(args) == 0 {
+		fmt.Printf("%s  %s%s%s\n", directoryHookScope(workDir), dim, workDir, reset)
+		return
+	}
+
+	scope := args[0]
+	if scope != HookScopeUser && scope != HookScopeProject {
+		fmt.Printf("%sError: scope must be %s or %s%s\n", red, HookScopeUser, HookScopeProject, reset)
+		os.Exit(2)
+	}
+	previous := claudeHookTarget(workDir)
+	stored := scope
+	if scope == HookScopeUser {
+		stored = "" // The default
+	}
+	if err := setDirectoryHookScope(workDir, stored); err != nil {
+		fmt.Printf("%sError: %v%s\n", red, err, reset)
+		os.Exit(1)
+	}
+	fmt.Printf("%sClaude hooks for %s now go in %s%s\n", green, workDir, claudeHookTarget(workDir).path(), reset)
+
+	// Hooks in both files would run twice
+	if previous.path() != claudeHookTarget(workDir).path() {
+		if states, err := previous.check(); err == nil && hooksPresent(states) {
+			fmt.Printf("%s%s still has aipilot hooks, which also run here: events would be reported twice%s\n",
+				yellow, previous.path(), reset)
+		}
+	}
+}
+
+// hooksPresent reports whether any aipilot entry is installed
+func hooksPresent(states []hookEntryState) bool {
+	for _, s := range states {
+		if s.State == hookInstalled || s.State == hookOutdated {
+			return true
+		}
+	}
+	return false
+}
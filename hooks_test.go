@@ -0,0 +1,259 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Settings with unsorted keys, tab indentation, an HTML-like command and a
+// user hook on one of our events
+const userClaudeSettings = `{
+	"model": "opus",
+	"env": {"FOO": "a&b"},
+	"hooks": {
+		"Stop": [
+			{
+				"matcher": "",
+				"hooks": [
+					{
+						"type": "command",
+						"command": "say <done> && true"
+					}
+				]
+			}
+		]
+	},
+	"cleanupPeriodDays": 30
+}
+`
+
+func claudeTargetAt(path string) *jsonHookTarget {
+	return &jsonHookTarget{name: "claude", file: path, events: claudeHookEvents, hook: claudeHook}
+}
+
+func TestClaudeHooksInstallUninstall(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "settings.json")
+	os.WriteFile(path, []byte(userClaudeSettings), 0600)
+	target := claudeTargetAt(path)
+
+	if changed, err := target.install(false); err != nil || !changed {
+		t.Fatalf("install: %v, %v", changed, err)
+	}
+
+	data, _ := os.ReadFile(path)
+	text := string(data)
+	if strings.Index(text, `"model"`) > strings.Index(text, `"cleanupPeriodDays"`) {
+		t.Error("key order changed")
+	}
+	if !strings.Contains(text, "\n\t\"model\"") {
+		t.Error("tab indentation lost")
+	}
+	if !strings.Contains(text, `say <done> && true`) {
+		t.Error("user command escaped")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("mode changed to %v", info.Mode().Perm())
+	}
+	backups, _ := filepath.Glob(path + ".aipilot-backup-*")
+	if len(backups) != 1 {
+		t.Fatalf("expected one backup, got %v", backups)
+	}
+	if backup, _ := os.ReadFile(backups[0]); string(backup) != userClaudeSettings {
+		t.Error("backup differs from the original")
+	}
+
+	states, _ := target.check()
+	for _, s := range states {
+		if s.State != hookInstalled {
+			t.Errorf("%s is %s", s.Name, s.State)
+		}
+	}
+
+	if changed, err := target.uninstall(); err != nil || !changed {
+		t.Fatalf("uninstall: %v, %v", changed, err)
+	}
+	data, _ = os.ReadFile(path)
+	if string(data) != strings.Replace(userClaudeSettings, `{"FOO": "a&b"}`, "{\n\t\t\"FOO\": \"a&b\"\n\t}", 1) {
+		t.Errorf("uninstall did not restore the settings:\n%s", data)
+	}
+}
+
+func TestClaudeHooksRepair(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	// PreToolUse installed async by an older version, Stop installed twice
+	os.WriteFile(path, []byte(`{"hooks": {
+		"PreToolUse": [{"matcher": "", "hooks": [{"async": true, "command": "aipilot-cli --agent-event", "timeout": 5, "type": "command"}]}],
+		"Stop": [
+			{"matcher": "", "hooks": [{"type": "command", "command": "aipilot-cli --agent-event", "timeout": 5, "async": true}]},
+			{"matcher": "", "hooks": [{"type": "command", "command": "aipilot-cli --agent-event", "timeout": 5, "async": true}]}
+		]
+	}}`), 0644)
+	target := claudeTargetAt(path)
+
+	states, _ := target.check()
+	for _, s := range states {
+		if s.Name == "PreToolUse" && s.State != hookOutdated {
+			t.Errorf("PreToolUse is %s, want outdated", s.State)
+		}
+	}
+
+	// install leaves outdated entries alone
+	target.install(false)
+	f, _ := loadSettingsFile(path)
+	if _, async := firstAipilotHook(f.root.Object("hooks").Array("PreToolUse")).Get("async"); !async {
+		t.Fatal("install rewrote an existing entry")
+	}
+
+	if changed, err := target.install(true); err != nil || !changed {
+		t.Fatalf("repair: %v, %v", changed, err)
+	}
+	f, _ = loadSettingsFile(path)
+	hooks := f.root.Object("hooks")
+	if !sameJSON(firstAipilotHook(hooks.Array("PreToolUse")), claudeHook("PreToolUse")) {
+		t.Error("PreToolUse not repaired")
+	}
+	if len(hooks.Array("Stop")) != 1 {
+		t.Errorf("duplicate Stop entry kept: %v", hooks.Array("Stop"))
+	}
+	if changed, _ := target.install(true); changed {
+		t.Error("second repair must be a no-op")
+	}
+}
+
+func TestClaudeHooksUninstallWithoutFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	if changed, err := claudeTargetAt(path).uninstall(); err != nil || changed {
+		t.Fatalf("uninstall: %v, %v", changed, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("uninstall created a settings file")
+	}
+}
+
+func TestRemoveCodexNotify(t *testing.T) {
+	cases := map[string]string{
+		"":                                       "",
+		`model = "o3"`:                           "model = \"o3\"\n",
+		"model = \"o3\"\n[tui]\nnotify = true\n": "model = \"o3\"\n[tui]\nnotify = true\n",
+		"model = \"o3\"\n\n[tui]\n":              "model = \"o3\"\n\n[tui]\n",
+	}
+	for config, want := range cases {
+		installed, _ := addCodexNotify(config)
+		if removed, ok := removeCodexNotify(installed); !ok || removed != want {
+			t.Errorf("round trip of %q gave %q", config, removed)
+		}
+	}
+
+	other := "notify = [\n  \"notify-send\",\n]\n"
+	if _, ok := removeCodexNotify(other); ok {
+		t.Error("removed another notify program")
+	}
+	multiline := "notify = [\n  \"aipilot-cli\",\n  \"--agent-event\",\n]\nmodel = \"o3\"\n"
+	if removed, ok := removeCodexNotify(multiline); !ok || removed != "model = \"o3\"\n" {
+		t.Errorf("multi-line notify: %q", removed)
+	}
+}
+
+func TestClaudeHookTarget_ProjectScope(t *testing.T) {
+	useConfigDir(t)
+	workDir := t.TempDir()
+
+	if path := claudeHookTarget(workDir).path(); path != claudeSettingsPath() {
+		t.Fatalf("default scope uses %s", path)
+	}
+
+	if err := setDirectoryHookScope(workDir, HookScopeProject); err != nil {
+		t.Fatal(err)
+	}
+	target := claudeHookTarget(workDir)
+	if want := filepath.Join(workDir, ".claude", "settings.local.json"); target.path() != want {
+		t.Fatalf("project scope uses %s, want %s", target.path(), want)
+	}
+	if changed, err := target.install(false); err != nil || !changed {
+		t.Fatalf("install: %v, %v", changed, err)
+	}
+
+	// Starting an agent there keeps the scope
+	if err := setDirectoryInvocation(sessionSpec{command: "claude", workDir: workDir}); err != nil {
+		t.Fatal(err)
+	}
+	if directoryHookScope(workDir) != HookScopeProject {
+		t.Error("hook scope lost when the invocation was saved")
+	}
+}
+
+func TestClaudeHookTarget_ProjectSettingsExcludedFromGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	useConfigDir(t)
+	workDir := t.TempDir()
+	if err := exec.Command("git", "-C", workDir, "init", "-q").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := setDirectoryHookScope(workDir, HookScopeProject); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := claudeHookTarget(workDir).install(false); err != nil {
+		t.Fatal(err)
+	}
+	ignored := exec.Command("git", "-C", workDir, "check-ignore", "-q", ".claude/settings.local.json")
+	if err := ignored.Run(); err != nil {
+		t.Fatalf("project settings not ignored by git: %v", err)
+	}
+	exclude, _ := os.ReadFile(filepath.Join(workDir, ".git", "info", "exclude"))
+	if strings.Count(string(exclude), "/.claude/settings.local.json") != 1 {
+		t.Fatalf("unexpected info/exclude:\n%s", exclude)
+	}
+
+	// Already ignored: not added twice
+	if _, err := claudeHookTarget(workDir).install(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(claudeProjectSettingsPath(workDir)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := claudeHookTarget(workDir).install(false); err != nil {
+		t.Fatal(err)
+	}
+	exclude, _ = os.ReadFile(filepath.Join(workDir, ".git", "info", "exclude"))
+	if n := strings.Count(string(exclude), "/.claude/settings.local.json"); n != 1 {
+		t.Fatalf("excluded %d times", n)
+	}
+}
+
+func TestWriteSettingsWithBackup_KeepsSymlink(t *testing.T) {
+	dir := t.TempDir()
+	dotfiles := filepath.Join(dir, "dotfiles", "settings.json")
+	os.MkdirAll(filepath.Dir(dotfiles), 0755)
+	os.WriteFile(dotfiles, []byte(userClaudeSettings), 0600)
+	path := filepath.Join(dir, "settings.json")
+	if err := os.Symlink(filepath.Join("dotfiles", "settings.json"), path); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	target := claudeTargetAt(path)
+	if _, err := target.install(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := target.uninstall(); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Lstat(path); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("settings link replaced by a file: %v", err)
+	}
+	if data, _ := os.ReadFile(dotfiles); strings.Contains(string(data), hookCommand) {
+		t.Fatal("linked file not updated")
+	}
+	// Both edits keep their backup, even within the same second
+	backups, _ := filepath.Glob(dotfiles + ".aipilot-backup-*")
+	if len(backups) != 2 {
+		t.Fatalf("expected two backups next to the linked file, got %v", backups)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Local LAN direct mode.
+// When enabled with --lan, the CLI listens for WebSocket connections on its
+// private LAN IP. The mobile connects to ws://<ip>:<port>/ws/<session> and
+// speaks the same Message protocol as with the relay, with the same AES-GCM
+// payload encryption. Lower latency, and keeps working when the relay is down.
+//
+// Handshake:
+//   CLI    -> mobile: {"type":"challenge","payload":"<hex nonce>"}
+//   mobile -> CLI:    {"type":"auth","payload":encrypt("<hex nonce>")}
+//   CLI    -> mobile: {"type":"connected","role":"bridge"}
+// Only a mobile holding the session key can answer the challenge. A mobile
+// may instead answer with its key exchange frame (see session_keys.go).
+
+var lanUpgrader = websocket.Upgrader{
+	ReadBufferSize:  BufferSize,
+	WriteBufferSize: BufferSize,
+	// The mobile app is not a browser: no Origin to check
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// startLANListener starts the local WebSocket listener on the private LAN IP.
+// port 0 uses DefaultLANPort.
+func (d *Daemon) startLANListener(port int) error {
+	if port == 0 {
+		port = DefaultLANPort
+	}
+
+	sshInfo := DetectSSHInfo()
+	if sshInfo == nil || len(sshInfo.IPs) == 0 {
+		return fmt.Errorf("no private LAN address found")
+	}
+	ip := sshInfo.IPs[0]
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, fmt.Sprintf("%d", port)))
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s:%d: %w", ip, port, err)
+	}
+
+	lanURL := "ws://" + listener.Addr().String()
+
+	d.mu.Lock()
+	d.lanListener = listener
+	d.lanURL = lanURL
+	d.mu.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/", d.handleLANWebSocket)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: LANHandshakeTimeout,
+	}
+	go server.Serve(listener)
+
+	fmt.Printf("%s[lan] Listening on %s%s\n", dim, lanURL, reset)
+	return nil
+}
+
+// getLANURL returns the advertised LAN URL, or "" when LAN mode is off
+func (d *Daemon) getLANURL() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.lanURL
+}
+
+// handleLANWebSocket upgrades a LAN connection, authenticates the mobile and
+// processes its messages until the connection closes
+func (d *Daemon) handleLANWebSocket(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	session := d.session
+	d.mu.RUnlock()
+
+	if strings.TrimPrefix(r.URL.Path, "/ws/") != session {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	conn, err := lanUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	keyExchange, proven, err := d.authenticateLANMobile(conn)
+	if err != nil {
+		conn.WriteJSON(Message{Type: "error", Error: err.Error()})
+		return
+	}
+	if proven {
+		d.claimLANConn(conn, keyExchange)
+	}
+
+	d.wsMu.Lock()
+	err = conn.WriteJSON(Message{Type: "connected", Role: "bridge"})
+	d.wsMu.Unlock()
+	if err == nil {
+		fmt.Printf("%s[lan] Mobile connected directly%s\n", dim, reset)
+		d.handleLANMessages(conn)
+	}
+
+	d.mu.Lock()
+	closed := d.lanConn == conn
+	if closed {
+		d.lanConn = nil
+	}
+	d.mu.Unlock()
+	// Output goes back to the relay, with its own keys
+	if closed {
+		d.resetMobileKeys(&d.lanKeys)
+	}
+}
+
+// claimLANConn makes conn the direct mobile connection, replacing any
+// previous one. keyExchange is false for a mobile using the legacy token key.
+func (d *Daemon) claimLANConn(conn *websocket.Conn, keyExchange bool) {
+	d.mu.Lock()
+	previous := d.lanConn
+	d.lanConn = conn
+	d.mu.Unlock()
+	if previous != nil && previous != conn {
+		previous.Close()
+	}
+	// The mobile announces its protocol in mobile-info
+	d.setMobileProtocol(0, nil)
+	if !keyExchange {
+		d.resetMobileKeys(&d.lanKeys)
+	}
+	d.checkPairings()
+}
+
+// authenticateLANMobile runs the challenge-response handshake: the mobile
+// must encrypt a random nonce with the legacy session key, or answer with a
+// key exchange (keyExchange). The connection is proven, and may replace the
+// current one, unless the exchange waits for a first frame (see offerMobileKeys).
+func (d *Daemon) authenticateLANMobile(conn *websocket.Conn) (keyExchange, proven bool, err error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return false, false, fmt.Errorf("failed to generate challenge")
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(LANHandshakeTimeout))
+	if err := conn.WriteJSON(Message{Type: "challenge", Payload: hex.EncodeToString(nonce)}); err != nil {
+		return false, false, err
+	}
+	conn.SetWriteDeadline(time.Time{})
+
+	conn.SetReadDeadline(time.Now().Add(LANHandshakeTimeout))
+	var auth Message
+	if err := conn.ReadJSON(&auth); err != nil {
+		return false, false, fmt.Errorf("no auth response")
+	}
+	if auth.Type != "auth" {
+		return false, false, fmt.Errorf("expected auth, got %s", auth.Type)
+	}
+
+	if isKeyExchange(auth.Payload) {
+		installed, err := d.handleKeyExchange(&d.lanKeys, conn, auth.Payload)
+		if err != nil {
+			return false, false, fmt.Errorf("authentication failed")
+		}
+		return true, installed, nil
+	}
+
+	if d.refusesLegacyKey() {
+		return false, false, fmt.Errorf("key exchange required")
+	}
+	answer, err := d.decrypt(&d.lanKeys, auth.Payload)
+	if err != nil || !bytes.Equal(answer, []byte(hex.EncodeToString(nonce))) {
+		return false, false, fmt.Errorf("authentication failed")
+	}
+	return false, true, nil
+}
+
+// handleLANMessages processes messages from a directly connected mobile
+func (d *Daemon) handleLANMessages(conn *websocket.Conn) {
+	for {
+		conn.SetReadDeadline(time.Now().Add(PingInterval * 3))
+
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case "data":
+			if isKeyExchange(msg.Payload) {
+				if _, err := d.handleKeyExchange(&d.lanKeys, conn, msg.Payload); err != nil {
+					return
+				}
+				continue
+			}
+			data, err := d.decodeMobilePayload(&d.lanKeys, msg.Payload)
+			if err != nil {
+				continue
+			}
+			// The first frame proves a connection whose key exchange was pending
+			if !d.isLANConn(conn) {
+				d.claimLANConn(conn, true)
+			}
+			d.handleMobileInput(data)
+
+		case "ping":
+			// The mobile keeps the connection alive, there's no relay to do it
+			d.wsMu.Lock()
+			err := conn.WriteJSON(Message{Type: "pong"})
+			d.wsMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// hasLANConn reports whether a mobile is connected directly
+func (d *Daemon) hasLANConn() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.lanConn != nil
+}
+
+// isLANConn reports whether conn is the direct mobile connection
+func (d *Daemon) isLANConn(conn *websocket.Conn) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.lanConn == conn
+}
+
+// stopLANListener closes the LAN listener and any direct mobile connection
+func (d *Daemon) stopLANListener() {
+	d.mu.Lock()
+	listener := d.lanListener
+	conn := d.lanConn
+	d.lanListener = nil
+	d.lanConn = nil
+	d.mu.Unlock()
+
+	if conn != nil {
+		d.wsMu.Lock()
+		conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, "CLI exiting"))
+		d.wsMu.Unlock()
+		conn.Close()
+	}
+	if listener != nil {
+		listener.Close()
+	}
+}
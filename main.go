@@ -15,22 +15,35 @@ import (
 	"time"
 
 	pty "github.com/aymanbagabas/go-pty"
+	"github.com/google/uuid"
 	"golang.org/x/term"
 )
 
 // cliFlags holds all parsed command-line flags
 type cliFlags struct {
-	agent         string
-	selectAgent   bool
-	workDir       string
-	listAgents    bool
-	listSessions  bool
-	killSession   string
-	killSessions  bool
-	agentEvent    bool
-	unpairMobile  string
-	showStatus    bool
-	configDir     string
+	agent        string
+	selectAgent  bool
+	workDir      string
+	listAgents   bool
+	listSessions bool
+	killSession  string
+	killSessions bool
+	agentEvent   bool
+	unpairMobile string
+	viewOnly     string
+	allowInput   string
+	showStatus   bool
+	configDir    string
+	relay        string
+	lan          bool
+	lanPort      int
+	multi        sessionSpecList
+	detach       bool
+	strict       bool
+	record       bool
+	logChat      bool
+	profile      string
+	agentArgs    []string // Command and arguments after --
 }
 
 // parseFlags parses command-line arguments and returns the flags
@@ -45,9 +58,21 @@ func parseFlags() *cliFlags {
 	killSessions := flag.Bool("kill-sessions", false, "Kill all sessions for this PC")
 	agentEvent := flag.Bool("agent-event", false, "Receive agent event from stdin and forward to socket")
 	unpairMobile := flag.String("unpair", "", "Unpair a mobile device by ID")
+	viewOnly := flag.String("view-only", "", "Make a paired mobile device view-only, by ID")
+	allowInput := flag.String("allow-input", "", "Let a view-only mobile device send input again, by ID")
 	showStatus := flag.Bool("status", false, "Show PC status, paired mobiles, and exit")
 	configDir := flag.String("config-dir", "", "Custom config directory (default: ~/.config/aipilot)")
 	doUpdate := flag.Bool("update", false, "Check for updates and install if available")
+	relay := flag.String("relay", "", "Relay URL (e.g. wss://relay.example.com for a self-hosted relay)")
+	lan := flag.Bool("lan", false, "Accept direct mobile connections on the local network")
+	lanPort := flag.Int("lan-port", DefaultLANPort, "Port for LAN direct mode")
+	var multi sessionSpecList
+	flag.Var(&multi, "multi", "Run several agents in one process: agent=workdir (repeatable)")
+	detach := flag.Bool("detach", false, "Run sessions in a background daemon (reattach with: aipilot-cli attach)")
+	profile := flag.String("profile", "", "Start a profile from directories.json (agent, args, env, workdir)")
+	strict := flag.Bool("strict", false, "Only accept mobiles using per-device session keys (refuse legacy frames)")
+	record := flag.Bool("record", false, "Record sessions (asciicast) in the config directory (see: aipilot-cli recordings)")
+	logChat := flag.Bool("log", false, "Write a Markdown log of prompts and agent output in the config directory")
 	flag.Parse()
 
 	if *showVersion {
@@ -66,17 +91,29 @@ func parseFlags() *cliFlags {
 	}
 
 	return &cliFlags{
-		agent:         *agent,
-		selectAgent:   *selectAgent,
-		workDir:       *workDir,
-		listAgents:    *listAgents,
-		listSessions:  *listSessions,
-		killSession:   *killSession,
-		killSessions:  *killSessions,
-		agentEvent:    *agentEvent,
-		unpairMobile:  *unpairMobile,
-		showStatus:    *showStatus,
-		configDir:     *configDir,
+		agent:        *agent,
+		selectAgent:  *selectAgent,
+		workDir:      *workDir,
+		listAgents:   *listAgents,
+		listSessions: *listSessions,
+		killSession:  *killSession,
+		killSessions: *killSessions,
+		agentEvent:   *agentEvent,
+		unpairMobile: *unpairMobile,
+		viewOnly:     *viewOnly,
+		allowInput:   *allowInput,
+		showStatus:   *showStatus,
+		configDir:    *configDir,
+		relay:        *relay,
+		lan:          *lan,
+		lanPort:      *lanPort,
+		multi:        multi,
+		detach:       *detach,
+		strict:       *strict,
+		record:       *record,
+		logChat:      *logChat,
+		profile:      *profile,
+		agentArgs:    flag.Args(),
 	}
 }
 
@@ -114,6 +151,19 @@ func handleSpecialModes(flags *cliFlags, pcConfig *PCConfig, relayClient *RelayC
 		return true
 	}
 
+	// Mobile permission mode
+	if flags.viewOnly != "" || flags.allowInput != "" {
+		id, viewOnly := flags.viewOnly, true
+		if id == "" {
+			id, viewOnly = flags.allowInput, false
+		}
+		if err := handleViewOnly(pcConfig, id, viewOnly); err != nil {
+			fmt.Printf("%sError: %v%s\n", red, err, reset)
+			os.Exit(1)
+		}
+		return true
+	}
+
 	return false
 }
 
@@ -139,7 +189,7 @@ func handleListAgents(listAgents bool) {
 	agents := detectAvailableAgents()
 	if len(agents) == 0 {
 		fmt.Println("No AI agents found in PATH.")
-		fmt.Println("Supported agents: claude, gemini, codex")
+		fmt.Println("Supported agents: " + strings.Join(agentNames(), ", "))
 		os.Exit(1)
 	}
 	fmt.Printf("\n%s=== Available AI Agents ===%s\n", bold, reset)
@@ -167,13 +217,13 @@ func resolveWorkDir(workDir string) string {
 }
 
 // selectAgentCommand selects the agent command based on flags and saved preferences
-func selectAgentCommand(flags *cliFlags, workDir string) string {
+func selectAgentCommand(agent string, forceSelect bool, workDir string) string {
 	// Agent selection logic:
 	// 1. If --select or --agent ?: force re-selection
 	// 2. If --agent <name> specified: use that agent
 	// 3. Otherwise: use saved agent for this directory, or detect/ask
 
-	if flags.selectAgent || flags.agent == "?" {
+	if forceSelect || agent == "?" {
 		// Force re-selection
 		agents := detectAvailableAgents()
 		if len(agents) == 0 {
@@ -183,12 +233,12 @@ func selectAgentCommand(flags *cliFlags, workDir string) string {
 		return selectAgent(agents)
 	}
 
-	if flags.agent != "" {
+	if agent != "" {
 		// Explicit command specified
-		if _, err := checkCommand(flags.agent); err != nil {
-			log.Fatalf("Error: %v\nPlease ensure '%s' is installed and in your PATH.", err, flags.agent)
+		if _, err := checkCommand(agent); err != nil {
+			log.Fatalf("Error: %v\nPlease ensure '%s' is installed and in your PATH.", err, agent)
 		}
-		return flags.agent
+		return agent
 	}
 
 	// Try to use saved agent for this directory
@@ -214,7 +264,7 @@ func selectAgentCommand(flags *cliFlags, workDir string) string {
 // printNoAgentsError prints the error message when no agents are found
 func printNoAgentsError() {
 	fmt.Printf("%sNo AI agents found in PATH.%s\n", red, reset)
-	fmt.Println("Supported agents: claude, gemini")
+	fmt.Println("Supported agents: " + strings.Join(agentNames(), ", "))
 }
 
 // createSession creates a session on the relay server
@@ -236,9 +286,12 @@ func createDaemon(session, token, relay, command, workDir string, agentType Agen
 		command:     command,
 		workDir:     workDir,
 		agentType:   agentType,
+		agent:       lookupAgent(agentType),
 		stdinFd:     int(os.Stdin.Fd()),
 		pcConfig:    pcConfig,
 		relayClient: relayClient,
+		scrollback:  NewScrollbackBuffer(ScrollbackSize),
+		screen:      NewVirtualScreen(DefaultCols, DefaultRows),
 	}
 
 	// Initialize E2E encryption
@@ -246,23 +299,34 @@ func createDaemon(session, token, relay, command, workDir string, agentType Agen
 		log.Fatal("Failed to initialize encryption:", err)
 	}
 
+	// Unpairing one of these rotates the session token (see checkPairings)
+	daemon.knownMobiles = make(map[string]bool)
+	if pcConfig != nil {
+		for _, m := range pcConfig.PairedMobiles {
+			daemon.knownMobiles[m.ID] = true
+		}
+	}
+
 	// Initialize agent busy/idle detection
 	daemon.initAgentStatus()
 
 	return daemon
 }
 
-// displayHeader displays the application header and session info
-func displayHeader(daemon *Daemon, session, command, workDir, agentVersion string) {
+// displayBanner displays the application name and version
+func displayBanner() {
 	fmt.Println()
 	versionDisplay := Version
 	if Version == "dev" {
 		versionDisplay = "dev [" + Build + "]"
 	}
-	fmt.Printf("%s%sAIPilot CLI%s %s%s%s  %s%s/qr%s %sto pair mobile%s\n",
-		bold, cyan, reset, dim, versionDisplay, reset, bold, cyan, reset, dim, reset)
+	fmt.Printf("%s%sAIPilot CLI%s %s%s%s  %s%s%s%s %sto pair mobile%s\n",
+		bold, cyan, reset, dim, versionDisplay, reset, bold, cyan, commandHint("qr"), reset, dim, reset)
 	fmt.Println()
+}
 
+// displayHeader connects the session to the relay and displays its info
+func displayHeader(daemon *Daemon, session, command, workDir, agentVersion string) {
 	// Connect to relay early
 	go daemon.connectToRelay()
 
@@ -291,8 +355,9 @@ func displayHeader(daemon *Daemon, session, command, workDir, agentVersion strin
 }
 
 // startPTY starts the PTY and returns the pty master and command.
-// socketPath is set as AIPILOT_HOOK_SOCKET env var for the agent process.
-func startPTY(command, workDir, socketPath string) (pty.Pty, *pty.Cmd) {
+// env holds extra KEY=VALUE variables for the agent process (profile
+// variables, then AIPILOT_HOOK_SOCKET and AIPILOT_HOOK_SECRET).
+func startPTY(command string, args, env []string, workDir string) (pty.Pty, *pty.Cmd) {
 	fmt.Printf("Starting %s...\n", command)
 
 	// Resolve full path before setting cmd.Dir, otherwise on Windows
@@ -306,12 +371,10 @@ func startPTY(command, workDir, socketPath string) (pty.Pty, *pty.Cmd) {
 	if err != nil {
 		log.Fatal("Failed to create PTY:", err)
 	}
-	cmd := ptmx.Command(commandPath)
+	cmd := ptmx.Command(commandPath, args...)
 	cmd.Dir = workDir
-	cmd.Env = append(os.Environ(),
-		"TERM=xterm-256color",
-		"AIPILOT_HOOK_SOCKET="+socketPath,
-	)
+	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+	cmd.Env = append(cmd.Env, env...)
 
 	if err := cmd.Start(); err != nil {
 		ptmx.Close()
@@ -335,7 +398,8 @@ func setupTerminalSize(daemon *Daemon) {
 	}
 }
 
-// startPTYReader starts a goroutine that reads from PTY and writes to stdout and mobile
+// startPTYReader starts a goroutine that reads from PTY and writes to stdout (when
+// the session is shown locally) and mobile
 func startPTYReader(daemon *Daemon) {
 	go func() {
 		buf := make([]byte, BufferSize)
@@ -353,32 +417,36 @@ func startPTYReader(daemon *Daemon) {
 			}
 
 			daemon.scanAgentStatus(buf[:n])
-			os.Stdout.Write(buf[:n])
-			daemon.sendToMobile(buf[:n])
+			daemon.broadcastPTYOutput(buf[:n])
 		}
 	}()
 }
 
 // setupRawTerminal sets up the terminal in raw mode and returns the old state
-func setupRawTerminal(daemon *Daemon) *term.State {
-	if !term.IsTerminal(daemon.stdinFd) {
+func setupRawTerminal(mux *Multiplexer) *term.State {
+	stdinFd := int(os.Stdin.Fd())
+	if !term.IsTerminal(stdinFd) {
 		return nil
 	}
 
-	oldState, err := term.MakeRaw(daemon.stdinFd)
+	oldState, err := term.MakeRaw(stdinFd)
 	if err != nil {
 		fmt.Printf("%sWarning: Could not set raw mode: %v%s\n", yellow, err, reset)
 		return nil
 	}
-	daemon.oldState = oldState
+	for _, daemon := range mux.All() {
+		daemon.oldState = oldState
+	}
 	return oldState
 }
 
-// startStdinReader starts a goroutine that reads from stdin and writes to PTY
-// It detects /qr command typed on empty line and intercepts it on Enter
-func startStdinReader(daemon *Daemon, oldState *term.State) {
+// startStdinReader starts a goroutine that reads from stdin and writes to the PTY
+// of the active session. AIPilot commands typed alone on a line are intercepted
+// on Enter; the command key, when set, opens the AIPilot command prompt.
+// With several sessions, Ctrl+] switches to the next one.
+func startStdinReader(mux *Multiplexer, oldState *term.State) {
 	go func() {
-		lineBuf := ""
+		var lineBuf []byte // Raw bytes: UTF-8 characters arrive one byte at a time
 		inEscapeSeq := false
 
 		for {
@@ -390,9 +458,28 @@ func startStdinReader(daemon *Daemon, oldState *term.State) {
 
 			char := b[0]
 
+			daemon := mux.Active()
+			if daemon == nil {
+				return
+			}
+
+			// Session switch key (only meaningful with several sessions)
+			if char == switchSessionKey && mux.Count() > 1 {
+				lineBuf = lineBuf[:0]
+				mux.Next()
+				continue
+			}
+
+			// Command key: AIPilot command prompt, whatever the agent shows
+			if commandKey != 0 && char == commandKey && !inEscapeSeq {
+				lineBuf = lineBuf[:0]
+				daemon.commandMode()
+				continue
+			}
+
 			// Track escape sequences
 			if char == 0x1b { // ESC
-				lineBuf = ""
+				lineBuf = lineBuf[:0]
 				inEscapeSeq = true
 				daemon.sendToPTY(b)
 				continue
@@ -404,48 +491,46 @@ func startStdinReader(daemon *Daemon, oldState *term.State) {
 				continue
 			}
 
-			// Printable characters - send to PTY, accumulate in lineBuf
-			if char >= 32 && char < 127 {
-				lineBuf += string(char)
+			// Printable characters (UTF-8 included) - send to PTY, accumulate in lineBuf
+			if char >= 32 && char != 127 {
+				lineBuf = append(lineBuf, char)
 				daemon.sendToPTY(b)
 				daemon.schedulePCSwitch()
 				continue
 			}
 
-			// Enter key - check for /qr command
+			// Enter key - check for AIPilot commands typed on the line
 			if char == '\r' || char == '\n' {
-				cmd := strings.TrimSpace(strings.ToLower(lineBuf))
-				if aipilotCmd := daemon.getAIPilotCommand(cmd); aipilotCmd != "" {
+				if aipilotCmd, arg := daemon.getAIPilotCommand(string(lineBuf)); aipilotCmd != "" {
 					// It's an AIPilot command - clear line with Ctrl+U and execute
 					daemon.sendToPTY([]byte{0x15}) // Ctrl+U to clear line
-					lineBuf = ""
-					daemon.executeAIPilotCommand(aipilotCmd)
+					lineBuf = lineBuf[:0]
+					daemon.executeAIPilotCommand(aipilotCmd, arg, "pc")
 				} else {
 					// Not a command - forward Enter
 					daemon.sendToPTY(b)
-					lineBuf = ""
+					daemon.logPrompt("pc", string(lineBuf))
+					lineBuf = lineBuf[:0]
 				}
 				continue
 			}
 
 			// Backspace - update lineBuf
 			if char == 127 || char == 8 {
-				if len(lineBuf) > 0 {
-					lineBuf = lineBuf[:len(lineBuf)-1]
-				}
+				lineBuf = trimLastRune(lineBuf)
 				daemon.sendToPTY(b)
 				continue
 			}
 
 			// Ctrl+C or Ctrl+U - reset lineBuf
 			if char == 3 || char == 0x15 {
-				lineBuf = ""
+				lineBuf = lineBuf[:0]
 				daemon.sendToPTY(b)
 				continue
 			}
 
 			// Other control characters - reset lineBuf and pass through
-			lineBuf = ""
+			lineBuf = lineBuf[:0]
 			daemon.sendToPTY(b)
 		}
 	}()
@@ -453,59 +538,118 @@ func startStdinReader(daemon *Daemon, oldState *term.State) {
 
 
 // startResizeHandler starts a goroutine that handles terminal resize signals
-func startResizeHandler(daemon *Daemon, resizeChan <-chan os.Signal) {
+func startResizeHandler(mux *Multiplexer, resizeChan <-chan os.Signal) {
 	go func() {
+		stdinFd := int(os.Stdin.Fd())
 		for range resizeChan {
-			if term.IsTerminal(daemon.stdinFd) {
-				width, height, err := term.GetSize(daemon.stdinFd)
-				if err == nil && width > 0 && height > 0 {
-					daemon.mu.Lock()
-					daemon.pcCols = width
-					daemon.pcRows = height
-					shouldResize := daemon.currentClient == "pc" || daemon.currentClient == ""
-					daemon.mu.Unlock()
-
-					if shouldResize {
-						daemon.resizePTY(uint16(height), uint16(width))
-						daemon.mu.Lock()
-						daemon.currentClient = "pc"
-						daemon.mu.Unlock()
-					}
-				}
+			if !term.IsTerminal(stdinFd) {
+				continue
+			}
+			width, height, err := term.GetSize(stdinFd)
+			if err != nil || width <= 0 || height <= 0 {
+				continue
 			}
+			resizeSessions(mux, width, height)
 		}
 	}()
 }
 
-// waitForTermination waits for either a signal or process exit, then cleans up
-func waitForTermination(sigChan <-chan os.Signal, cmd *pty.Cmd, daemon *Daemon) {
+// resizeSessions applies a new local terminal size. Every session shares the
+// local terminal, so all follow its size.
+func resizeSessions(mux *Multiplexer, width, height int) {
+	for _, daemon := range mux.All() {
+		daemon.mu.Lock()
+		daemon.pcCols = width
+		daemon.pcRows = height
+		shouldResize := daemon.currentClient == "pc" || daemon.currentClient == ""
+		daemon.mu.Unlock()
+
+		if shouldResize {
+			daemon.resizePTY(uint16(height), uint16(width))
+			daemon.mu.Lock()
+			daemon.currentClient = "pc"
+			daemon.mu.Unlock()
+		}
+	}
+}
+
+// agentExit reports the exit of a session's agent process
+type agentExit struct {
+	daemon *Daemon
+	err    error
+}
+
+// waitForTermination waits for either a signal or the exit of the last agent,
+// then cleans up. With several sessions, an agent exiting only ends its own session.
+func waitForTermination(sigChan <-chan os.Signal, mux *Multiplexer, oldState *term.State) {
 	var exitMsg string
 
-	select {
-	case <-sigChan:
-		exitMsg = "Shutting down AIPilot..."
-	case err := <-waitForProcess(cmd):
-		if err != nil {
-			exitMsg = fmt.Sprintf("Process exited with error: %v", err)
-		} else {
-			exitMsg = "" // Silent exit
+	exits := make(chan agentExit, mux.Count())
+	for _, daemon := range mux.All() {
+		go func(d *Daemon) {
+			exits <- agentExit{daemon: d, err: <-waitForProcess(d.cmd)}
+		}(daemon)
+	}
+
+	var last *Daemon
+wait:
+	for {
+		select {
+		case <-sigChan:
+			exitMsg = "Shutting down AIPilot..."
+			break wait
+		case exit := <-exits:
+			if mux.Remove(exit.daemon) > 0 {
+				// Other sessions keep running
+				exit.daemon.cleanup()
+				continue
+			}
+			last = exit.daemon
+			if exit.err != nil {
+				exitMsg = fmt.Sprintf("Process exited with error: %v", exit.err)
+			} else {
+				exitMsg = "" // Silent exit
+			}
+			break wait
 		}
 	}
 
 	// Restore terminal before printing (fixes raw mode line breaks)
-	if daemon.oldState != nil {
-		term.Restore(daemon.stdinFd, daemon.oldState)
+	if oldState != nil {
+		term.Restore(int(os.Stdin.Fd()), oldState)
 	}
 
 	if exitMsg != "" {
 		fmt.Printf("\n%s\n", exitMsg)
 	}
 
-	// Cleanup: delete session from relay and close WebSocket
-	daemon.cleanup()
+	// Cleanup: delete sessions from relay and close WebSockets
+	if last != nil {
+		last.cleanup()
+	}
+	for _, daemon := range mux.All() {
+		daemon.cleanup()
+	}
+}
+
+// subcommands maps subcommand names (aipilot-cli <name> ...) to their entry points
+var subcommands = map[string]func(args []string){
+	"relay":      relayMain,
+	"attach":     attachMain,
+	"hooks":      hooksMain,
+	"notify":     notifyMain,
+	"recordings": recordingsMain,
 }
 
 func main() {
+	// Subcommands have their own flags
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+	}
+
 	// Parse flags
 	flags := parseFlags()
 
@@ -514,11 +658,21 @@ func main() {
 		customConfigDir = flags.configDir
 	}
 
+	// Use a custom (e.g. self-hosted) relay if provided
+	if flags.relay != "" {
+		RelayURL = strings.TrimRight(flags.relay, "/")
+	}
+
 	// Cleanup leftover .old binary from previous Windows update
 	cleanupOldBinary()
 
+	// Inside a --detach daemon, sessions were already resolved by the parent
+	detachedSpecs := detachedSessionSpecs()
+
 	// Check for updates (non-blocking for patch, blocking for minor/major)
-	checkUpdateOnStartup()
+	if detachedSpecs == nil {
+		checkUpdateOnStartup()
+	}
 
 	// Load or create PC configuration
 	pcConfig, err := getOrCreatePCConfig()
@@ -526,6 +680,9 @@ func main() {
 		log.Fatal("Failed to load PC configuration:", err)
 	}
 
+	// Command mode key (config.json "command_key")
+	setCommandKey(pcConfig.CommandKey)
+
 	// Create relay client
 	relayClient := NewRelayClient(RelayURL, pcConfig)
 
@@ -540,26 +697,127 @@ func main() {
 	// Handle --list flag
 	handleListAgents(flags.listAgents)
 
-	// Resolve working directory
-	workDir := resolveWorkDir(flags.workDir)
+	// Resolve the agents to run: one by default, several with --multi
+	specs := detachedSpecs
+	if specs == nil {
+		specs = resolveSessionSpecs(flags)
 
-	// Select agent command
-	selectedCommand := selectAgentCommand(flags, workDir)
+		// --detach: hand the sessions over to a background daemon
+		if flags.detach {
+			runDetached(specs)
+			return
+		}
+	}
+
+	// Sessions run by the same process are grouped in --sessions
+	if len(specs) > 1 {
+		relayClient.groupID = uuid.New().String()
+	}
+
+	displayBanner()
 
+	mux := NewMultiplexer()
+	for i, spec := range specs {
+		mux.Add(prepareSession(spec, i, flags, pcConfig, relayClient))
+	}
+
+	if mux.Count() > 1 {
+		fmt.Printf("%s%d sessions running. Press Ctrl+] to switch between them.%s\n\n", dim, mux.Count(), reset)
+	}
+
+	// A --detach daemon leaves the launching terminal here; attach clients take over
+	if detachedSpecs != nil {
+		detachFromTerminal(mux)
+	}
+
+	// Handle termination signals (SIGINT, SIGTERM, SIGHUP)
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	// Handle window resize
+	resizeChan := setupResizeSignal()
+
+	// Start the agents (PTY, hook socket, PTY reader)
+	for _, daemon := range mux.All() {
+		ptmx := startSession(daemon)
+		defer ptmx.Close()
+	}
+
+	// Setup raw terminal
+	oldState := setupRawTerminal(mux)
+	if oldState != nil {
+		defer term.Restore(int(os.Stdin.Fd()), oldState)
+	}
+
+	// Start stdin reader goroutine
+	startStdinReader(mux, oldState)
+
+	// Start resize handler goroutine
+	startResizeHandler(mux, resizeChan)
+
+	// Wait for termination
+	waitForTermination(sigChan, mux, oldState)
+}
+
+// resolveSessionSpecs returns the agents to run, with their command and
+// working directory resolved
+func resolveSessionSpecs(flags *cliFlags) []sessionSpec {
+	if len(flags.multi) == 0 {
+		if flags.profile != "" {
+			spec, err := profileSpec(flags.profile, flags.workDir)
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			spec.command = selectAgentCommand(spec.command, false, spec.workDir)
+			return []sessionSpec{spec}
+		}
+
+		workDir := resolveWorkDir(flags.workDir)
+		if len(flags.agentArgs) > 0 {
+			// aipilot-cli -- claude --model X: run exactly this command
+			if flags.agent != "" {
+				log.Fatal("Error: use either --agent or -- <command> [args]")
+			}
+			command := selectAgentCommand(flags.agentArgs[0], false, workDir)
+			return []sessionSpec{{command: command, args: flags.agentArgs[1:], workDir: workDir}}
+		}
+
+		spec := sessionSpec{workDir: workDir}
+		spec.command = selectAgentCommand(flags.agent, flags.selectAgent, workDir)
+		if flags.agent == "" && !flags.selectAgent {
+			restoreDirectoryInvocation(&spec)
+		}
+		return []sessionSpec{spec}
+	}
+
+	specs := make([]sessionSpec, 0, len(flags.multi))
+	for _, spec := range flags.multi {
+		if spec.workDir == "" {
+			spec.workDir = resolveWorkDir(flags.workDir)
+		}
+		spec.command = selectAgentCommand(spec.command, flags.selectAgent, spec.workDir)
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// prepareSession creates the relay session and daemon for one agent and
+// displays its info. index offsets the LAN port when several sessions run.
+func prepareSession(spec sessionSpec, index int, flags *cliFlags, pcConfig *PCConfig, relayClient *RelayClient) *Daemon {
 	// Save agent choice for this directory
-	if err := setDirectoryAgent(workDir, selectedCommand); err != nil {
+	if err := setDirectoryInvocation(spec); err != nil {
 		fmt.Printf("%sWarning: Could not save agent preference: %v%s\n", yellow, err, reset)
 	}
 
 	// Detect agent type and version
-	agentType := detectAgentType(selectedCommand)
-	agentVersion := getAgentVersion(selectedCommand, agentType)
-	displayName := filepath.Base(workDir)
+	agentType := detectAgentType(spec.command)
+	agentVersion := getAgentVersion(spec.command, agentType)
+	displayName := filepath.Base(spec.workDir)
 
 	sshInfo := DetectSSHInfo()
 
 	// Create a fresh session
-	sessionResp, err := createSession(relayClient, agentType, workDir, displayName, sshInfo)
+	sessionResp, err := createSession(relayClient, agentType, spec.workDir, displayName, sshInfo)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -567,54 +825,58 @@ func main() {
 	token := sessionResp.Token
 
 	// Create and initialize daemon
-	daemon := createDaemon(session, token, RelayURL, selectedCommand, workDir, agentType, pcConfig, relayClient)
+	daemon := createDaemon(session, token, RelayURL, spec.command, spec.workDir, agentType, pcConfig, relayClient)
+	// Once a paired mobile used session keys, legacy frames are refused
+	daemon.strict = flags.strict || pcConfig.usesSessionKeys()
+	daemon.record = flags.record
+	daemon.logChat = flags.logChat
+	daemon.args = spec.args
+	daemon.env = spec.env
+
+	// Start LAN direct mode before the header so the QR and cli-info advertise it
+	if flags.lan {
+		if err := daemon.startLANListener(flags.lanPort + index); err != nil {
+			fmt.Printf("%sWarning: LAN direct mode unavailable: %v%s\n", yellow, err, reset)
+		}
+	}
 
 	// Display header and session info
-	displayHeader(daemon, session, selectedCommand, workDir, agentVersion)
+	displayHeader(daemon, session, spec.commandLine(), spec.workDir, agentVersion)
+	return daemon
+}
 
+// startSession starts the agent of a prepared session and returns its PTY
+func startSession(daemon *Daemon) pty.Pty {
 	// Auto-install hooks for agents that support them
-	if agentType == AgentClaude {
-		ensureClaudeHooksInstalled()
+	if install := hookInstallers[daemon.agent.Hooks]; install != nil {
+		install(daemon.workDir)
 	}
 
 	// Start hook socket and PTY
-	socketPath := filepath.Join(os.TempDir(), "aipilot-"+session+".sock")
-	daemon.startHookSocket(socketPath)
+	hookEnv := daemon.startHookSocket()
+	if daemon.record {
+		daemon.startRecorder()
+	}
+	if daemon.logChat {
+		daemon.startConversationLog()
+	}
 
-	ptmx, cmd := startPTY(selectedCommand, workDir, socketPath)
-	defer ptmx.Close()
+	args := append(append([]string(nil), daemon.agent.Args...), daemon.args...)
+	env := append(append([]string(nil), daemon.env...), hookEnv...)
+	ptmx, cmd := startPTY(daemon.command, args, env, daemon.workDir)
 
 	daemon.mu.Lock()
 	daemon.ptmx = ptmx
+	daemon.cmd = cmd
+	daemon.startedAt = time.Now()
 	daemon.mu.Unlock()
 
 	// Setup terminal
 	setupTerminalSize(daemon)
 
-	// Handle termination signals (SIGINT, SIGTERM, SIGHUP)
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
-
-	// Handle window resize
-	resizeChan := setupResizeSignal()
-
 	// Start PTY reader goroutine
 	startPTYReader(daemon)
-
-	// Setup raw terminal
-	oldState := setupRawTerminal(daemon)
-	if oldState != nil {
-		defer term.Restore(daemon.stdinFd, oldState)
-	}
-
-	// Start stdin reader goroutine
-	startStdinReader(daemon, oldState)
-
-	// Start resize handler goroutine
-	startResizeHandler(daemon, resizeChan)
-
-	// Wait for termination
-	waitForTermination(sigChan, cmd, daemon)
+	return ptmx
 }
 
 func waitForProcess(cmd *pty.Cmd) <-chan error {
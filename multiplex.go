@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Multiplexed mode: one aipilot-cli process runs several agents.
+// Each agent has its own Daemon (PTY, relay session, token, hook socket);
+// the Multiplexer decides which one owns the local terminal.
+// Ctrl+] cycles through sessions, repainting from the screen model.
+
+// switchSessionKey is the local keybinding that cycles sessions (Ctrl+])
+const switchSessionKey = 0x1d
+
+// Multiplexer tracks the daemons of this process and the one shown locally
+type Multiplexer struct {
+	mu       sync.RWMutex
+	switchMu sync.Mutex // Serializes session switches
+	daemons  []*Daemon
+	active   int
+}
+
+// NewMultiplexer creates an empty multiplexer
+func NewMultiplexer() *Multiplexer {
+	return &Multiplexer{}
+}
+
+// Add registers a daemon. The first one becomes the active session.
+func (m *Multiplexer) Add(d *Daemon) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d.mux = m
+	m.daemons = append(m.daemons, d)
+}
+
+// All returns a copy of the registered daemons
+func (m *Multiplexer) All() []*Daemon {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]*Daemon(nil), m.daemons...)
+}
+
+// Count returns the number of running sessions
+func (m *Multiplexer) Count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.daemons)
+}
+
+// Active returns the daemon owning the local terminal, or nil when none is left
+func (m *Multiplexer) Active() *Daemon {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.daemons) == 0 {
+		return nil
+	}
+	return m.daemons[m.active]
+}
+
+// isActive reports whether d owns the local terminal
+func (m *Multiplexer) isActive(d *Daemon) bool {
+	return m.Active() == d
+}
+
+// Next switches the local terminal to the next session
+func (m *Multiplexer) Next() {
+	m.mu.RLock()
+	count := len(m.daemons)
+	next := m.active + 1
+	m.mu.RUnlock()
+
+	if count > 1 {
+		m.Select(next % count)
+	}
+}
+
+// Select switches the local terminal to session i and repaints it
+func (m *Multiplexer) Select(i int) {
+	m.switchMu.Lock()
+	defer m.switchMu.Unlock()
+
+	m.mu.RLock()
+	if i < 0 || i >= len(m.daemons) {
+		m.mu.RUnlock()
+		return
+	}
+	previous := m.daemons[m.active]
+	target := m.daemons[i]
+	m.mu.RUnlock()
+
+	// Hold both output locks so no in-flight output of either session
+	// lands on the terminal around the repaint
+	previous.outputMu.Lock()
+	if target != previous {
+		target.outputMu.Lock()
+	}
+
+	m.mu.Lock()
+	m.active = i
+	count := len(m.daemons)
+	m.mu.Unlock()
+
+	m.repaint(target, i, count)
+
+	if target != previous {
+		target.outputMu.Unlock()
+	}
+	previous.outputMu.Unlock()
+}
+
+// SelectDaemon switches the local terminal to d and repaints it
+func (m *Multiplexer) SelectDaemon(d *Daemon) {
+	m.mu.RLock()
+	index := -1
+	for i, existing := range m.daemons {
+		if existing == d {
+			index = i
+		}
+	}
+	m.mu.RUnlock()
+
+	m.Select(index)
+}
+
+// Remove unregisters a daemon whose agent exited. When it was active, the
+// next session takes over the terminal. Returns the number of sessions left.
+func (m *Multiplexer) Remove(d *Daemon) int {
+	m.mu.Lock()
+	wasActive := false
+	for i, existing := range m.daemons {
+		if existing != d {
+			continue
+		}
+		wasActive = i == m.active
+		m.daemons = append(m.daemons[:i], m.daemons[i+1:]...)
+		if m.active > i || m.active >= len(m.daemons) {
+			m.active--
+		}
+		if m.active < 0 {
+			m.active = 0
+		}
+		break
+	}
+	remaining := len(m.daemons)
+	active := m.active
+	m.mu.Unlock()
+
+	if wasActive && remaining > 0 {
+		m.Select(active)
+	}
+	return remaining
+}
+
+// repaint redraws the local terminal from the session's screen model and
+// shows the session in the terminal title. Called with the session's outputMu held.
+func (m *Multiplexer) repaint(d *Daemon, index, count int) {
+	title := fmt.Sprintf("aipilot [%d/%d] %s — %s", index+1, count, d.command, filepath.Base(d.workDir))
+	fmt.Printf("\033]0;%s\007", title)
+	if d.screen != nil {
+		os.Stdout.WriteString(d.screen.Snapshot().ANSI)
+	}
+}
+
+// sessionSpec describes one agent to launch
+type sessionSpec struct {
+	command string   // Empty: saved agent for workDir, or ask
+	args    []string // Passed to the agent after its default args
+	env     []string // Extra KEY=VALUE variables
+	workDir string
+	profile string // Profile the session comes from, if any
+}
+
+// commandLine returns the agent command with its arguments, for display
+func (s sessionSpec) commandLine() string {
+	return strings.Join(append([]string{s.command}, s.args...), " ")
+}
+
+// sessionSpecList collects repeated --multi flags
+type sessionSpecList []sessionSpec
+
+func (l *sessionSpecList) String() string {
+	var parts []string
+	for _, s := range *l {
+		parts = append(parts, s.command+"="+s.workDir)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set parses "agent=workdir", "agent" or "=workdir"
+func (l *sessionSpecList) Set(value string) error {
+	command, workDir, _ := strings.Cut(value, "=")
+	if workDir != "" {
+		abs, err := filepath.Abs(workDir)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(abs)
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("not a directory: %s", workDir)
+		}
+		workDir = abs
+	}
+	*l = append(*l, sessionSpec{command: command, workDir: workDir})
+	return nil
+}
+
+var _ flag.Value = (*sessionSpecList)(nil)
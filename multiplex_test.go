@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMultiplexer_NextAndRemove(t *testing.T) {
+	m := NewMultiplexer()
+	a, b, c := &Daemon{}, &Daemon{}, &Daemon{}
+	m.Add(a)
+	m.Add(b)
+	m.Add(c)
+
+	if m.Active() != a || a.mux != m {
+		t.Fatal("expected first session to be active")
+	}
+	m.Next()
+	if m.Active() != b {
+		t.Fatal("expected Next to select the second session")
+	}
+
+	// Removing an inactive session keeps the active one
+	if left := m.Remove(a); left != 2 || m.Active() != b {
+		t.Fatalf("expected b active with 2 left, got %d", left)
+	}
+	// Removing the active session hands the terminal to another one
+	if left := m.Remove(b); left != 1 || m.Active() != c {
+		t.Fatalf("expected c active with 1 left, got %d", left)
+	}
+	if left := m.Remove(c); left != 0 || m.Active() != nil {
+		t.Fatalf("expected no session left, got %d", left)
+	}
+}
+
+func TestSessionSpecList_Set(t *testing.T) {
+	dir := t.TempDir()
+	var specs sessionSpecList
+	if err := specs.Set("claude=" + dir); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := specs.Set("gemini"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if specs[0].command != "claude" || specs[0].workDir != dir {
+		t.Fatalf("unexpected spec %+v", specs[0])
+	}
+	if specs[1].command != "gemini" || specs[1].workDir != "" {
+		t.Fatalf("unexpected spec %+v", specs[1])
+	}
+
+	file := dir + string(os.PathSeparator) + "file"
+	os.WriteFile(file, nil, 0600)
+	if err := specs.Set("claude=" + file); err == nil {
+		t.Fatal("expected error for a non-directory workdir")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// `aipilot-cli notify` pushes a notification to the phone from a script run
+// inside a session (the agent's shell inherits AIPILOT_HOOK_SOCKET):
+//
+//   make test; aipilot-cli notify --title "Tests" --level success "All green"
+//
+// It is sent as a "notification" hook message from the "script" source, and
+// forwarded to mobile as a "notification" control message.
+
+// Notification levels
+const (
+	NotifyLevelInfo    = "info"
+	NotifyLevelSuccess = "success"
+	NotifyLevelWarning = "warning"
+	NotifyLevelError   = "error"
+)
+
+// Notification sources: `aipilot-cli notify`, and the CLI itself (command palette)
+const (
+	notifySourceScript = "script"
+	notifySourceCLI    = "cli"
+)
+
+// validNotifyLevel reports whether level is a known notification level
+func validNotifyLevel(level string) bool {
+	switch level {
+	case NotifyLevelInfo, NotifyLevelSuccess, NotifyLevelWarning, NotifyLevelError:
+		return true
+	}
+	return false
+}
+
+// notifyMain implements "aipilot-cli notify [--title T] [--level L] [--body] B"
+func notifyMain(args []string) {
+	fs := flag.NewFlagSet("notify", flag.ExitOnError)
+	title := fs.String("title", "", "Notification title")
+	body := fs.String("body", "", "Notification text (or as arguments)")
+	level := fs.String("level", NotifyLevelInfo, "info, success, warning or error")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: aipilot-cli notify [--title T] [--level L] [--body] text...\n\n")
+		fmt.Fprintf(fs.Output(), "Send a notification to the phone from inside an aipilot session.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	message := *body
+	if message == "" {
+		message = strings.Join(fs.Args(), " ")
+	}
+	if message == "" && *title == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !validNotifyLevel(*level) {
+		fmt.Fprintf(os.Stderr, "%sError: unknown level %s%s\n", red, *level, reset)
+		os.Exit(2)
+	}
+
+	if err := sendNotification(NotificationData{
+		Message: truncateHookField(message),
+		Title:   truncateHookField(*title),
+		Level:   *level,
+		Source:  notifySourceScript,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "%sError: %v%s\n", red, err, reset)
+		os.Exit(1)
+	}
+}
+
+// sendNotification sends a notification hook message to the session's socket
+func sendNotification(notification NotificationData) error {
+	socketPath := os.Getenv("AIPILOT_HOOK_SOCKET")
+	if socketPath == "" {
+		return fmt.Errorf("not running inside an aipilot session (AIPILOT_HOOK_SOCKET is not set)")
+	}
+
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(HookMessage{Event: "notification", Data: data, Secret: os.Getenv("AIPILOT_HOOK_SECRET")})
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("session not reachable: %w", err)
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "%s\n", msg)
+	return err
+}
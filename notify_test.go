@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSendNotification(t *testing.T) {
+	t.Setenv("AIPILOT_HOOK_SOCKET", "")
+	if err := sendNotification(NotificationData{Message: "done"}); err == nil {
+		t.Fatal("expected an error outside a session")
+	}
+
+	socketPath := filepath.Join(t.TempDir(), "s.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	t.Setenv("AIPILOT_HOOK_SOCKET", socketPath)
+	t.Setenv("AIPILOT_HOOK_SECRET", "s3cret")
+
+	received := make(chan HookMessage, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var msg HookMessage
+		line, _ := bufio.NewReader(conn).ReadBytes('\n')
+		json.Unmarshal(line, &msg)
+		received <- msg
+	}()
+
+	if err := sendNotification(NotificationData{Message: "All green", Title: "Tests", Level: NotifyLevelSuccess, Source: notifySourceScript}); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-received
+	if msg.Event != "notification" || msg.Secret != "s3cret" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	var notification NotificationData
+	json.Unmarshal(msg.Data, &notification)
+	if notification.Title != "Tests" || notification.Message != "All green" ||
+		notification.Level != NotifyLevelSuccess || notification.Source != notifySourceScript {
+		t.Fatalf("unexpected notification %+v", notification)
+	}
+}
+
+func TestValidNotifyLevel(t *testing.T) {
+	for _, level := range []string{"info", "success", "warning", "error"} {
+		if !validNotifyLevel(level) {
+			t.Errorf("%s rejected", level)
+		}
+	}
+	if validNotifyLevel("debug") || validNotifyLevel("") {
+		t.Error("unknown level accepted")
+	}
+}
+
+func TestTruncateHookField_KeepsCharactersWhole(t *testing.T) {
+	long := strings.Repeat("é", MaxHookFieldLength)
+	got := truncateHookField(long)
+	if !utf8.ValidString(got) || len(got) > MaxHookFieldLength || !strings.HasSuffix(got, "é...") {
+		t.Fatalf("bad truncation: %q", got)
+	}
+	if short := "déjà vu"; truncateHookField(short) != short {
+		t.Fatal("short field changed")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,26 +18,85 @@ type PairedMobile struct {
 	Name      string `json:"name"`
 	PublicKey string `json:"public_key"`
 	PairedAt  string `json:"paired_at"`
+	ViewOnly  bool   `json:"view_only,omitempty"` // Output only: input from this mobile is dropped
+	// The mobile proved a session key exchange: legacy token-key frames are refused
+	SessionKeys bool `json:"session_keys,omitempty"`
 }
 
 // PCConfig represents the PC's identity and paired devices
 type PCConfig struct {
-	PCID         string         `json:"pc_id"`
-	PCName       string         `json:"pc_name"`
-	PrivateKey   string         `json:"private_key"`
-	PublicKey    string         `json:"public_key"`
+	PCID          string         `json:"pc_id"`
+	PCName        string         `json:"pc_name"`
+	PrivateKey    string         `json:"private_key"`
+	PublicKey     string         `json:"public_key"`
 	PairedMobiles []PairedMobile `json:"paired_mobiles"`
-	CreatedAt    string         `json:"created_at"`
+	CreatedAt     string         `json:"created_at"`
+	CommandKey    string         `json:"command_key,omitempty"` // AIPilot command mode key: "ctrl+a" to "ctrl+z", or "off" (default)
 }
 
-// DirectoryConfig represents remembered agent choice per directory
+// DirectoryConfig represents remembered agent invocation per directory
 type DirectoryConfig struct {
-	DefaultAgent string `json:"default_agent"`
-	LastUsed     string `json:"last_used"`
+	DefaultAgent string   `json:"default_agent"`
+	Args         []string `json:"args,omitempty"`       // Arguments passed after --
+	Profile      string   `json:"profile,omitempty"`    // Profile the agent was started with
+	HookScope    string   `json:"hook_scope,omitempty"` // Where agent hooks go: HookScopeUser (default) or HookScopeProject
+	LastUsed     string   `json:"last_used"`
 }
 
-// DirectoriesConfig maps directory paths to their config
-type DirectoriesConfig map[string]DirectoryConfig
+// AgentProfile is a named agent invocation, started with --profile
+type AgentProfile struct {
+	Agent   string            `json:"agent"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	WorkDir string            `json:"workdir,omitempty"` // Default: --workdir or current dir
+}
+
+// DirectoriesConfig is directories.json: remembered invocations keyed by
+// directory path at the top level, the shape earlier versions read, and named
+// profiles alongside under directoriesProfilesKey
+type DirectoriesConfig struct {
+	Directories map[string]DirectoryConfig
+	Profiles    map[string]AgentProfile
+}
+
+// directoriesProfilesKey holds the profiles in directories.json; it is never a
+// directory path. Earlier versions take it for a directory.
+const directoriesProfilesKey = "profiles"
+
+func (c DirectoriesConfig) MarshalJSON() ([]byte, error) {
+	entries := make(map[string]interface{}, len(c.Directories)+1)
+	for path, dc := range c.Directories {
+		entries[path] = dc
+	}
+	if len(c.Profiles) > 0 {
+		entries[directoriesProfilesKey] = c.Profiles
+	}
+	return json.Marshal(entries)
+}
+
+func (c *DirectoriesConfig) UnmarshalJSON(data []byte) error {
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	c.Directories = make(map[string]DirectoryConfig, len(entries))
+	for key, raw := range entries {
+		if key == directoriesProfilesKey {
+			if err := json.Unmarshal(raw, &c.Profiles); err != nil {
+				// Rewritten as a directory by an earlier version
+				fmt.Printf("%sWarning: Ignoring the profiles of directories.json: %v%s\n", yellow, err, reset)
+				c.Profiles = nil
+			}
+			continue
+		}
+		var dc DirectoryConfig
+		if err := json.Unmarshal(raw, &dc); err != nil {
+			return fmt.Errorf("directory %s: %w", key, err)
+		}
+		c.Directories[key] = dc
+	}
+	return nil
+}
 
 // customConfigDir overrides the default config directory when set via --config-dir
 var customConfigDir string
@@ -94,6 +154,47 @@ func loadPCConfig() (*PCConfig, error) {
 	return &config, nil
 }
 
+// pcConfigCache is config.json as last read, reused while its modification
+// time and size are unchanged
+var pcConfigCache struct {
+	sync.Mutex
+	path    string // "" when invalidated
+	modTime time.Time
+	size    int64
+	config  PCConfig
+}
+
+// loadPCConfigCached returns a copy of config.json, parsed again only when
+// the file changed: input checks read it on every keystroke
+func loadPCConfigCached() (*PCConfig, error) {
+	dir, err := getConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "config.json")
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil // No config yet
+		}
+		return nil, err
+	}
+
+	cache := &pcConfigCache
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.path != path || !cache.modTime.Equal(info.ModTime()) || cache.size != info.Size() {
+		config, err := loadPCConfig()
+		if err != nil || config == nil {
+			return config, err
+		}
+		cache.path, cache.modTime, cache.size, cache.config = path, info.ModTime(), info.Size(), *config
+	}
+	config := cache.config
+	config.PairedMobiles = append([]PairedMobile(nil), cache.config.PairedMobiles...)
+	return &config, nil
+}
+
 // savePCConfig saves the PC configuration
 func savePCConfig(config *PCConfig) error {
 	dir, err := ensureConfigDir()
@@ -107,7 +208,12 @@ func savePCConfig(config *PCConfig) error {
 		return err
 	}
 
-	return os.WriteFile(path, data, FilePermissions)
+	err = os.WriteFile(path, data, FilePermissions)
+	// Read again on next use, even if the modification time didn't change
+	pcConfigCache.Lock()
+	pcConfigCache.path = ""
+	pcConfigCache.Unlock()
+	return err
 }
 
 // createPCConfig creates a new PC configuration with generated keys
@@ -169,6 +275,51 @@ func (c *PCConfig) getPairedMobile(mobileID string) *PairedMobile {
 	return nil
 }
 
+// usesSessionKeys reports whether a paired mobile has used session keys
+func (c *PCConfig) usesSessionKeys() bool {
+	for _, m := range c.PairedMobiles {
+		if m.SessionKeys {
+			return true
+		}
+	}
+	return false
+}
+
+// hasViewOnlyMobile reports whether a paired mobile is view-only
+func (c *PCConfig) hasViewOnlyMobile() bool {
+	for _, m := range c.PairedMobiles {
+		if m.ViewOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// findPairedMobile returns a paired mobile by ID or by its first 8
+// characters, or nil if not found
+func findPairedMobile(config *PCConfig, id string) *PairedMobile {
+	for i, m := range config.PairedMobiles {
+		if m.ID == id || shortID(m.ID) == id {
+			return &config.PairedMobiles[i]
+		}
+	}
+	return nil
+}
+
+// setMobileViewOnly changes the permission of a paired mobile (found by ID
+// or its first 8 characters) and saves the config
+func setMobileViewOnly(config *PCConfig, id string, viewOnly bool) (*PairedMobile, error) {
+	mobile := findPairedMobile(config, id)
+	if mobile == nil {
+		return nil, fmt.Errorf("mobile device not found: %s", id)
+	}
+	mobile.ViewOnly = viewOnly
+	if err := savePCConfig(config); err != nil {
+		return nil, fmt.Errorf("failed to save config: %w", err)
+	}
+	return mobile, nil
+}
+
 // addPairedMobile adds a new paired mobile
 func (c *PCConfig) addPairedMobile(mobile PairedMobile) {
 	// Check if already exists
@@ -194,31 +345,31 @@ func (c *PCConfig) removePairedMobile(mobileID string) bool {
 }
 
 // loadDirectoriesConfig loads the directories configuration
-func loadDirectoriesConfig() (DirectoriesConfig, error) {
+func loadDirectoriesConfig() (*DirectoriesConfig, error) {
 	dir, err := getConfigDir()
 	if err != nil {
 		return nil, err
 	}
 
+	config := &DirectoriesConfig{}
 	path := filepath.Join(dir, "directories.json")
 	data, err := os.ReadFile(path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return make(DirectoriesConfig), nil
+		if !os.IsNotExist(err) {
+			return nil, err
 		}
+	} else if err := json.Unmarshal(data, config); err != nil {
 		return nil, err
 	}
 
-	var config DirectoriesConfig
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, err
+	if config.Directories == nil {
+		config.Directories = make(map[string]DirectoryConfig)
 	}
-
 	return config, nil
 }
 
 // saveDirectoriesConfig saves the directories configuration
-func saveDirectoriesConfig(config DirectoriesConfig) error {
+func saveDirectoriesConfig(config *DirectoriesConfig) error {
 	dir, err := ensureConfigDir()
 	if err != nil {
 		return err
@@ -233,30 +384,51 @@ func saveDirectoriesConfig(config DirectoriesConfig) error {
 	return os.WriteFile(path, data, FilePermissions)
 }
 
+// getDirectoryConfig returns the remembered invocation for a directory
+func getDirectoryConfig(workDir string) (DirectoryConfig, bool) {
+	config, err := loadDirectoriesConfig()
+	if err != nil {
+		return DirectoryConfig{}, false
+	}
+
+	dc, ok := config.Directories[workDir]
+	return dc, ok
+}
+
 // getDirectoryAgent returns the default agent for a directory
 func getDirectoryAgent(workDir string) string {
+	dc, _ := getDirectoryConfig(workDir)
+	return dc.DefaultAgent
+}
+
+// setDirectoryInvocation saves the agent, arguments and profile a directory was started with
+func setDirectoryInvocation(spec sessionSpec) error {
 	config, err := loadDirectoriesConfig()
 	if err != nil {
-		return ""
+		return err
 	}
 
-	if dc, ok := config[workDir]; ok {
-		return dc.DefaultAgent
+	config.Directories[spec.workDir] = DirectoryConfig{
+		DefaultAgent: spec.command,
+		Args:         spec.args,
+		Profile:      spec.profile,
+		HookScope:    config.Directories[spec.workDir].HookScope,
+		LastUsed:     time.Now().Format(time.RFC3339),
 	}
-	return ""
+
+	return saveDirectoriesConfig(config)
 }
 
-// setDirectoryAgent saves the default agent for a directory
-func setDirectoryAgent(workDir, agent string) error {
+// setDirectoryHookScope saves where agent hooks are installed for a directory
+func setDirectoryHookScope(workDir, scope string) error {
 	config, err := loadDirectoriesConfig()
 	if err != nil {
 		return err
 	}
 
-	config[workDir] = DirectoryConfig{
-		DefaultAgent: agent,
-		LastUsed:     time.Now().Format(time.RFC3339),
-	}
+	dc := config.Directories[workDir]
+	dc.HookScope = scope
+	config.Directories[workDir] = dc
 
 	return saveDirectoriesConfig(config)
 }
@@ -277,6 +449,7 @@ type PairingQRData struct {
 	SSHPort      int    `json:"sp,omitempty"`
 	Hostname     string `json:"h,omitempty"`
 	Username     string `json:"u,omitempty"`
+	LANURL       string `json:"lan,omitempty"` // Direct LAN WebSocket URL (--lan)
 }
 
 // SessionQRInfo holds optional session-specific data for the pairing QR code.
@@ -285,6 +458,7 @@ type SessionQRInfo struct {
 	SessionID string
 	WorkDir   string
 	AgentType string
+	LANURL    string // Empty when LAN direct mode is off
 }
 
 // buildPairingQRData constructs the PairingQRData struct used for QR code generation.
@@ -303,6 +477,7 @@ func buildPairingQRData(config *PCConfig, relayURL, pairingToken string, session
 		qrData.SessionID = sessionInfo.SessionID
 		qrData.WorkingDir = sessionInfo.WorkDir
 		qrData.AgentType = sessionInfo.AgentType
+		qrData.LANURL = sessionInfo.LANURL
 
 		sshInfo := DetectSSHInfo()
 		if sshInfo != nil && sshInfo.Available {
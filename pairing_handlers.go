@@ -20,13 +20,18 @@ func showPCStatus(config *PCConfig) {
 
 	if len(config.PairedMobiles) == 0 {
 		fmt.Printf("  %sNo paired mobile devices%s\n", dim, reset)
-		fmt.Printf("\n  Run %saipilot-cli%s and type %s/qr%s to pair a mobile device.\n", cyan, reset, cyan, reset)
+		fmt.Printf("\n  Run %saipilot-cli%s and type %s%s%s to pair a mobile device.\n", cyan, reset, cyan, commandHint("qr"), reset)
 	} else {
 		fmt.Printf("%s  Paired Mobiles:%s\n", bold, reset)
 		for _, mobile := range config.PairedMobiles {
 			fmt.Printf("    %s✓%s %s\n", green, reset, mobile.Name)
 			fmt.Printf("      ID: %s\n", mobile.ID[:8]+"...")
 			fmt.Printf("      Paired: %s\n", mobile.PairedAt)
+			if mobile.ViewOnly {
+				fmt.Printf("      Access: %sview-only%s\n", yellow, reset)
+			} else {
+				fmt.Printf("      Access: full\n")
+			}
 		}
 	}
 	fmt.Println()
@@ -35,13 +40,7 @@ func showPCStatus(config *PCConfig) {
 // handleUnpair removes a paired mobile device
 func handleUnpair(config *PCConfig, client *RelayClient, mobileID string) error {
 	// Find mobile by ID (can be partial match)
-	var foundMobile *PairedMobile
-	for _, m := range config.PairedMobiles {
-		if m.ID == mobileID || m.ID[:8] == mobileID {
-			foundMobile = &m
-			break
-		}
-	}
+	foundMobile := findPairedMobile(config, mobileID)
 
 	if foundMobile == nil {
 		fmt.Printf("%sMobile device not found: %s%s\n", red, mobileID, reset)
@@ -70,6 +69,20 @@ func handleUnpair(config *PCConfig, client *RelayClient, mobileID string) error
 	return nil
 }
 
+// handleViewOnly makes a paired mobile view-only, or gives it back control
+func handleViewOnly(config *PCConfig, mobileID string, viewOnly bool) error {
+	mobile, err := setMobileViewOnly(config, mobileID, viewOnly)
+	if err != nil {
+		return err
+	}
+	if viewOnly {
+		fmt.Printf("%s✓ %s is view-only%s\n", green, mobile.Name, reset)
+	} else {
+		fmt.Printf("%s✓ %s can send input again%s\n", green, mobile.Name, reset)
+	}
+	return nil
+}
+
 // handlePairing initiates the pairing flow with a new mobile device
 func handlePairing(config *PCConfig, client *RelayClient, relayURL string) error {
 	fmt.Printf("%s%s=== Mobile Device Pairing ===%s\n", bold, cyan, reset)
@@ -108,7 +121,7 @@ func handlePairing(config *PCConfig, client *RelayClient, relayURL string) error
 	for {
 		select {
 		case <-timeout:
-			fmt.Printf("\n%sPairing timed out. Type /qr to retry.%s\n", red, reset)
+			fmt.Printf("\n%sPairing timed out. Type %s to retry.%s\n", red, commandHint("qr"), reset)
 			return nil
 		case <-ticker.C:
 			status, err := client.CheckPairingStatus(pairingResp.Token)
@@ -135,7 +148,7 @@ func handlePairing(config *PCConfig, client *RelayClient, relayURL string) error
 				return nil
 
 			case "expired":
-				fmt.Printf("\n%sPairing token expired. Type /qr to retry.%s\n", red, reset)
+				fmt.Printf("\n%sPairing token expired. Type %s to retry.%s\n", red, commandHint("qr"), reset)
 				return nil
 
 			case "pending":
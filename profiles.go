@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Agent arguments and profiles.
+// `aipilot-cli -- claude --model X --resume` runs the agent with these
+// arguments. Profiles in directories.json bundle an agent, its arguments,
+// extra environment variables and a working directory:
+//
+//   "profiles": {
+//     "review": {"agent": "claude", "args": ["--model", "opus"], "env": {"MAX_THINKING_TOKENS": "8000"}}
+//   }
+//
+// and run with `aipilot-cli --profile review`. The invocation is remembered per
+// directory, so the next plain `aipilot-cli` there starts the agent the same way.
+
+// profileSpec returns the session a profile describes. workDir, when set,
+// overrides the profile's working directory.
+func profileSpec(name, workDir string) (sessionSpec, error) {
+	config, err := loadDirectoriesConfig()
+	if err != nil {
+		return sessionSpec{}, fmt.Errorf("cannot read profiles: %w", err)
+	}
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return sessionSpec{}, fmt.Errorf("unknown profile %q", name)
+	}
+	if profile.Agent == "" {
+		return sessionSpec{}, fmt.Errorf("profile %q has no agent", name)
+	}
+
+	if workDir == "" && profile.WorkDir != "" {
+		workDir = expandHome(profile.WorkDir)
+	}
+	return sessionSpec{
+		command: profile.Agent,
+		args:    profile.Args,
+		env:     profileEnv(profile.Env),
+		workDir: resolveWorkDir(workDir),
+		profile: name,
+	}, nil
+}
+
+// restoreDirectoryInvocation applies the arguments and profile remembered for
+// the directory, when spec runs the agent remembered there
+func restoreDirectoryInvocation(spec *sessionSpec) {
+	dc, ok := getDirectoryConfig(spec.workDir)
+	if !ok || dc.DefaultAgent != spec.command {
+		return
+	}
+	if dc.Profile != "" {
+		// Re-read the profile: its env may have changed since
+		if profile, err := profileSpec(dc.Profile, spec.workDir); err == nil && profile.command == spec.command {
+			*spec = profile
+			return
+		}
+	}
+	spec.args = dc.Args
+}
+
+// profileEnv converts profile variables to KEY=VALUE entries, sorted
+func profileEnv(env map[string]string) []string {
+	entries := make([]string, 0, len(env))
+	for key, value := range env {
+		entries = append(entries, key+"="+value)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// expandHome expands a leading ~ to the home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// useConfigDir points the config directory to a temporary directory
+func useConfigDir(t *testing.T) string {
+	t.Helper()
+	previous := customConfigDir
+	customConfigDir = t.TempDir()
+	t.Cleanup(func() { customConfigDir = previous })
+	return customConfigDir
+}
+
+func TestLoadDirectoriesConfig_LegacyFormat(t *testing.T) {
+	dir := useConfigDir(t)
+	legacy := `{"/home/me/app": {"default_agent": "gemini", "last_used": "2025-01-01T00:00:00Z"}}`
+	os.WriteFile(filepath.Join(dir, "directories.json"), []byte(legacy), 0600)
+
+	if got := getDirectoryAgent("/home/me/app"); got != "gemini" {
+		t.Fatalf("expected legacy entry to be read, got %q", got)
+	}
+
+	// Saving keeps the entry and the format earlier versions read
+	if err := setDirectoryInvocation(sessionSpec{command: "claude", args: []string{"--resume"}, workDir: "/other"}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	config, err := loadDirectoriesConfig()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if config.Directories["/home/me/app"].DefaultAgent != "gemini" {
+		t.Fatal("legacy entry lost on save")
+	}
+	if !reflect.DeepEqual(config.Directories["/other"].Args, []string{"--resume"}) {
+		t.Fatalf("unexpected saved args %+v", config.Directories["/other"])
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "directories.json"))
+	var legacyShape map[string]DirectoryConfig
+	if err := json.Unmarshal(data, &legacyShape); err != nil || legacyShape["/home/me/app"].DefaultAgent != "gemini" {
+		t.Fatalf("saved file unreadable by earlier versions (%v):\n%s", err, data)
+	}
+}
+
+func TestProfileSpec(t *testing.T) {
+	useConfigDir(t)
+	work := t.TempDir()
+	config, _ := loadDirectoriesConfig()
+	config.Profiles = map[string]AgentProfile{
+		"review": {
+			Agent:   "claude",
+			Args:    []string{"--model", "opus"},
+			Env:     map[string]string{"B": "2", "A": "1"},
+			WorkDir: work,
+		},
+	}
+	saveDirectoriesConfig(config)
+
+	spec, err := profileSpec("review", "")
+	if err != nil {
+		t.Fatalf("profileSpec: %v", err)
+	}
+	want := sessionSpec{
+		command: "claude",
+		args:    []string{"--model", "opus"},
+		env:     []string{"A=1", "B=2"},
+		workDir: work,
+		profile: "review",
+	}
+	if !reflect.DeepEqual(spec, want) {
+		t.Fatalf("got %+v, want %+v", spec, want)
+	}
+	if spec.commandLine() != "claude --model opus" {
+		t.Fatalf("unexpected command line %q", spec.commandLine())
+	}
+
+	if _, err := profileSpec("missing", ""); err == nil {
+		t.Fatal("expected unknown profile error")
+	}
+
+	// The next plain start in the directory reuses the profile
+	setDirectoryInvocation(spec)
+	restored := sessionSpec{command: "claude", workDir: work}
+	restoreDirectoryInvocation(&restored)
+	if !reflect.DeepEqual(restored, want) {
+		t.Fatalf("restored %+v, want %+v", restored, want)
+	}
+
+	// Another agent in the same directory doesn't get the profile's args
+	other := sessionSpec{command: "gemini", workDir: work}
+	restoreDirectoryInvocation(&other)
+	if other.args != nil || other.env != nil {
+		t.Fatalf("unexpected restored invocation %+v", other)
+	}
+}
+
+func TestLoadDirectoriesConfig_ProfilesRewrittenByEarlierVersion(t *testing.T) {
+	dir := useConfigDir(t)
+	rewritten := `{"/home/me/app": {"default_agent": "gemini", "last_used": ""}, "profiles": {"default_agent": "", "last_used": ""}}`
+	os.WriteFile(filepath.Join(dir, "directories.json"), []byte(rewritten), 0600)
+
+	config, err := loadDirectoriesConfig()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if config.Directories["/home/me/app"].DefaultAgent != "gemini" || len(config.Profiles) != 0 {
+		t.Fatalf("unexpected config %+v", config)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// Session recording (--record).
+// Everything the agent prints, everything sent to it (from the terminal or
+// mobile) and terminal resizes are written as asciicast v2
+// (https://docs.asciinema.org/manual/asciicast/v2/) to the recordings
+// directory in the config dir, one file per session:
+//
+//   {"version": 2, "width": 120, "height": 40, "timestamp": 1760000000, ...}
+//   [0.251, "o", "Welcome to Claude Code"]
+//   [3.002, "i", "fix the tests\r"]
+//   [7.310, "r", "100x30"]
+//
+// The files play with asciinema, or `aipilot-cli recordings play`.
+
+// recordingsDirName is the recordings directory in the config directory
+const recordingsDirName = "recordings"
+
+// recordingExt is the extension of recording files
+const recordingExt = ".cast"
+
+// castHeader is the first line of an asciicast v2 file
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder writes a session to an asciicast v2 file. A nil Recorder records
+// nothing, so call sites don't check whether recording is on.
+type Recorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	path  string
+	start time.Time
+	// Incomplete UTF-8 sequence at the end of the last chunk, per event type:
+	// asciicast data is text, and a character may be split across reads
+	pending map[string][]byte
+}
+
+// recordingsDir returns the recordings directory path
+func recordingsDir() (string, error) {
+	dir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, recordingsDirName), nil
+}
+
+// startRecording creates the recording file of a session
+func startRecording(session, title string, width, height int) (*Recorder, error) {
+	dir, err := recordingsDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, DirPermissions); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	name := start.Format("20060102-150405") + "-" + shortID(session) + recordingExt
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, FilePermissions)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{
+		file:    file,
+		w:       bufio.NewWriter(file),
+		path:    path,
+		start:   start,
+		pending: make(map[string][]byte),
+	}
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	})
+	r.w.Write(append(header, '\n'))
+	r.w.Flush()
+	return r, nil
+}
+
+// shortID returns the first 8 characters of a session ID
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// Path returns the recording file path, "" when not recording
+func (r *Recorder) Path() string {
+	if r == nil {
+		return ""
+	}
+	return r.path
+}
+
+// Output records agent output
+func (r *Recorder) Output(data []byte) {
+	r.text("o", data)
+}
+
+// Input records input sent to the agent
+func (r *Recorder) Input(data []byte) {
+	r.text("i", data)
+}
+
+// Resize records a new terminal size
+func (r *Recorder) Resize(cols, rows int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// text records a text event, holding back a trailing incomplete character
+func (r *Recorder) text(kind string, data []byte) {
+	if r == nil || len(data) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data = append(r.pending[kind], data...)
+	cut := len(data) - incompleteUTF8Suffix(data)
+	r.pending[kind] = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		r.event(kind, string(data[:cut]))
+	}
+}
+
+// event writes one event line. The caller holds r.mu.
+func (r *Recorder) event(kind, data string) {
+	if r.file == nil {
+		return
+	}
+	elapsed := time.Since(r.start).Seconds()
+	line, err := json.Marshal([]interface{}{json.Number(fmt.Sprintf("%.6f", elapsed)), kind, data})
+	if err != nil {
+		return
+	}
+	r.w.Write(append(line, '\n'))
+	// Flushed right away: the recording must survive a crash or kill
+	r.w.Flush()
+}
+
+// Close flushes and closes the recording
+func (r *Recorder) Close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	for kind, rest := range r.pending {
+		if len(rest) > 0 {
+			r.event(kind, string(rest))
+		}
+	}
+	r.w.Flush()
+	r.file.Close()
+	r.file = nil
+}
+
+// incompleteUTF8Suffix returns the length of an incomplete UTF-8 sequence at
+// the end of data, 0 if data ends on a character boundary
+func incompleteUTF8Suffix(data []byte) int {
+	// A character is at most utf8.UTFMax bytes: look at the last 3 for its start
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		b := data[len(data)-i]
+		if b < 0x80 {
+			return 0 // ASCII
+		}
+		if utf8.RuneStart(b) {
+			if utf8.FullRune(data[len(data)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// startRecorder starts recording the session at the local terminal size
+func (d *Daemon) startRecorder() {
+	width, height := 80, 24
+	if term.IsTerminal(d.stdinFd) {
+		if w, h, err := term.GetSize(d.stdinFd); err == nil && w > 0 && h > 0 {
+			width, height = w, h
+		}
+	}
+	title := strings.Join(append([]string{d.command}, d.args...), " ") + " in " + d.workDir
+
+	recorder, err := startRecording(d.session, title, width, height)
+	if err != nil {
+		fmt.Printf("%sWarning: cannot record the session: %v%s\n", yellow, err, reset)
+		return
+	}
+	d.mu.Lock()
+	d.recorder = recorder
+	d.mu.Unlock()
+	fmt.Printf("%s[record] Recording to %s%s\n", dim, recorder.path, reset)
+}
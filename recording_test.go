@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorder_WritesAsciicast(t *testing.T) {
+	useConfigDir(t)
+	r, err := startRecording("1a2b3c4d-session", "claude in /work", 100, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "é" split across two PTY reads
+	r.Output([]byte("caf\xc3"))
+	r.Output([]byte("\xa9\r\n"))
+	r.Input([]byte("fix the tests\r"))
+	r.Resize(80, 24)
+	r.Close()
+	r.Output([]byte("after close"))
+
+	var events []castEvent
+	header, err := readCast(r.path, func(_ []byte, event castEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 2 || header.Width != 100 || header.Height != 30 || header.Title != "claude in /work" {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	// The incomplete character waits for the next read
+	want := []castEvent{{kind: "o", data: "caf"}, {kind: "o", data: "é\r\n"}, {kind: "i", data: "fix the tests\r"}, {kind: "r", data: "80x24"}}
+	if len(events) != len(want) {
+		t.Fatalf("got events %+v", events)
+	}
+	for i, event := range events {
+		if event.kind != want[i].kind || event.data != want[i].data {
+			t.Errorf("event %d: got %+v, want %+v", i, event, want[i])
+		}
+	}
+}
+
+func TestNilRecorder(t *testing.T) {
+	var r *Recorder
+	r.Output([]byte("x"))
+	r.Input([]byte("x"))
+	r.Resize(1, 1)
+	r.Close()
+}
+
+func TestExportRecording_WithoutInput(t *testing.T) {
+	useConfigDir(t)
+	r, err := startRecording("5e6f7a8b-session", "codex in /work", 80, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Output([]byte("Password: "))
+	r.Input([]byte("hunter2\r"))
+	r.Close()
+
+	recording, err := findRecording("5e6f7a8b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last, _ := findRecording("last"); last.path != recording.path {
+		t.Fatalf("last recording is %s", last.path)
+	}
+
+	output := filepath.Join(t.TempDir(), "bug.cast")
+	if err := exportRecording(recording, output, true); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(output)
+	if strings.Contains(string(data), "hunter2") {
+		t.Fatal("input exported with --no-input")
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"version":2`) || !strings.Contains(lines[1], "Password: ") {
+		t.Fatalf("unexpected export:\n%s", data)
+	}
+}
+
+func TestIncompleteUTF8Suffix(t *testing.T) {
+	cases := map[string]int{"": 0, "abc": 0, "é": 0, "a\xc3": 1, "\xe2\x82": 2, "€": 0, "\xf0\x9f\x98": 3}
+	for input, want := range cases {
+		if got := incompleteUTF8Suffix([]byte(input)); got != want {
+			t.Errorf("%q: got %d, want %d", input, got, want)
+		}
+	}
+}
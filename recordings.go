@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// `aipilot-cli recordings list|play|export` works on the files written by
+// --record (see recording.go).
+
+// recordingInfo describes a recording file
+type recordingInfo struct {
+	name     string // File name without extension
+	path     string
+	header   castHeader
+	duration time.Duration // Time of the last event
+	size     int64
+}
+
+// castEvent is an asciicast v2 event line: [time, type, data]
+type castEvent struct {
+	time float64
+	kind string // "o" output, "i" input, "r" resize
+	data string
+}
+
+// parseCastEvent parses an event line
+func parseCastEvent(line []byte) (castEvent, error) {
+	var fields []interface{}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return castEvent{}, err
+	}
+	if len(fields) != 3 {
+		return castEvent{}, fmt.Errorf("expected 3 fields, got %d", len(fields))
+	}
+	t, ok1 := fields[0].(float64)
+	kind, ok2 := fields[1].(string)
+	data, ok3 := fields[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return castEvent{}, fmt.Errorf("invalid event")
+	}
+	return castEvent{time: t, kind: kind, data: data}, nil
+}
+
+// readCastHeader reads the header line of a recording
+func readCastHeader(path string) ([]byte, castHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, castHeader{}, err
+	}
+	defer file.Close()
+	return parseCastHeader(bufio.NewReader(file), path)
+}
+
+func parseCastHeader(reader *bufio.Reader, path string) ([]byte, castHeader, error) {
+	var header castHeader
+	first, err := reader.ReadBytes('\n')
+	if err != nil && len(first) == 0 {
+		return nil, header, fmt.Errorf("%s: empty recording", filepath.Base(path))
+	}
+	if err := json.Unmarshal(first, &header); err != nil || header.Version != 2 {
+		return nil, header, fmt.Errorf("%s: not an asciicast v2 file", filepath.Base(path))
+	}
+	return first, header, nil
+}
+
+// readCast reads a recording: its header, then calls fn for each event
+func readCast(path string, fn func(line []byte, event castEvent) error) (castHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return castHeader{}, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	_, header, err := parseCastHeader(reader, path)
+	if err != nil {
+		return header, err
+	}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			// A session killed mid-write leaves a partial last line: skip it
+			if event, perr := parseCastEvent(line); perr == nil {
+				if ferr := fn(line, event); ferr != nil {
+					return header, ferr
+				}
+			}
+		}
+		if err == io.EOF {
+			return header, nil
+		}
+		if err != nil {
+			return header, err
+		}
+	}
+}
+
+// listRecordings returns the recordings, oldest first
+func listRecordings() ([]recordingInfo, error) {
+	dir, err := recordingsDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"+recordingExt))
+	sort.Strings(paths) // Names start with the date
+
+	var recordings []recordingInfo
+	for _, path := range paths {
+		info := recordingInfo{name: strings.TrimSuffix(filepath.Base(path), recordingExt), path: path}
+		var last float64
+		header, err := readCast(path, func(_ []byte, event castEvent) error {
+			last = event.time
+			return nil
+		})
+		if err != nil {
+			continue
+		}
+		info.header = header
+		info.duration = time.Duration(last * float64(time.Second))
+		if stat, err := os.Stat(path); err == nil {
+			info.size = stat.Size()
+		}
+		recordings = append(recordings, info)
+	}
+	return recordings, nil
+}
+
+// findRecording returns the recording matching a name, a part of it (such as
+// the session ID) or "last"
+func findRecording(query string) (recordingInfo, error) {
+	recordings, err := listRecordings()
+	if err != nil {
+		return recordingInfo{}, err
+	}
+	if len(recordings) == 0 {
+		return recordingInfo{}, fmt.Errorf("no recordings (start sessions with --record)")
+	}
+	if query == "last" {
+		return recordings[len(recordings)-1], nil
+	}
+
+	var matches []recordingInfo
+	for _, r := range recordings {
+		if r.name == strings.TrimSuffix(query, recordingExt) {
+			return r, nil
+		}
+		if strings.Contains(r.name, query) {
+			matches = append(matches, r)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return recordingInfo{}, fmt.Errorf("no recording matches %s", query)
+	case 1:
+		return matches[0], nil
+	}
+	return recordingInfo{}, fmt.Errorf("%d recordings match %s, be more specific", len(matches), query)
+}
+
+// recordingsMain implements "aipilot-cli recordings list|play|export"
+func recordingsMain(args []string) {
+	usage := func() {
+		fmt.Printf("Usage: aipilot-cli recordings list\n")
+		fmt.Printf("       aipilot-cli recordings play [--speed N] [--idle-limit S] <name|last>\n")
+		fmt.Printf("       aipilot-cli recordings export [-o file] [--no-input] <name|last>\n\n")
+		fmt.Printf("Sessions started with --record are saved as asciicast v2 files, which\n")
+		fmt.Printf("asciinema can also play. A name may be abbreviated to any part, such\n")
+		fmt.Printf("as the session ID.\n")
+	}
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	action := args[0]
+	fs := flag.NewFlagSet("recordings "+action, flag.ExitOnError)
+	configDir := fs.String("config-dir", "", "Custom config directory (default: ~/.config/aipilot)")
+	speed := fs.Float64("speed", 1, "Playback speed")
+	idleLimit := fs.Float64("idle-limit", 2, "Longest pause during playback, in seconds (0: no limit)")
+	output := fs.String("o", "", "Export file (default: <name>.cast, - for stdout)")
+	noInput := fs.Bool("no-input", false, "Leave out what was typed (passwords, tokens)")
+	fs.Parse(args[1:])
+	if *configDir != "" {
+		customConfigDir = *configDir
+	}
+
+	switch action {
+	case "list":
+		printRecordings()
+		return
+	case "play", "export":
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if fs.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+	recording, err := findRecording(fs.Arg(0))
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", red, err, reset)
+		os.Exit(1)
+	}
+
+	if action == "play" {
+		err = playRecording(recording, *speed, *idleLimit)
+	} else {
+		err = exportRecording(recording, *output, *noInput)
+	}
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", red, err, reset)
+		os.Exit(1)
+	}
+}
+
+// printRecordings lists the recordings
+func printRecordings() {
+	recordings, err := listRecordings()
+	if err != nil {
+		fmt.Printf("%sError: %v%s\n", red, err, reset)
+		os.Exit(1)
+	}
+	if len(recordings) == 0 {
+		fmt.Printf("%sNo recordings. Start sessions with --record.%s\n", dim, reset)
+		return
+	}
+	for _, r := range recordings {
+		fmt.Printf("%s%s%s  %8s  %6d KB  %s%s%s\n", bold, r.name, reset,
+			r.duration.Round(time.Second), (r.size+1023)/1024, dim, r.header.Title, reset)
+	}
+}
+
+// playRecording replays the output of a recording in the terminal.
+// Pauses longer than idleLimit seconds are shortened.
+func playRecording(recording recordingInfo, speed, idleLimit float64) error {
+	if speed <= 0 {
+		return fmt.Errorf("speed must be positive")
+	}
+
+	if fd := int(os.Stdout.Fd()); term.IsTerminal(fd) {
+		header := recording.header
+		if width, height, err := term.GetSize(fd); err == nil && (width < header.Width || height < header.Height) {
+			fmt.Printf("%sRecorded at %dx%d, this terminal is %dx%d: output may look garbled%s\n",
+				yellow, header.Width, header.Height, width, height, reset)
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	previous := 0.0
+	_, err := readCast(recording.path, func(_ []byte, event castEvent) error {
+		if event.kind != "o" {
+			return nil
+		}
+		pause := event.time - previous
+		if idleLimit > 0 && pause > idleLimit {
+			pause = idleLimit
+		}
+		previous = event.time
+		time.Sleep(time.Duration(pause / speed * float64(time.Second)))
+		_, err := os.Stdout.WriteString(event.data)
+		return err
+	})
+	fmt.Print("\x1b[0m\n")
+	return err
+}
+
+// exportRecording copies a recording to a file (or stdout), optionally
+// without input events
+func exportRecording(recording recordingInfo, output string, noInput bool) error {
+	if output == "" {
+		output = recording.name + recordingExt
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "-" {
+		file, err := os.OpenFile(output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, FilePermissions)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	header, _, err := readCastHeader(recording.path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	bw.Write(header)
+	_, err = readCast(recording.path, func(line []byte, event castEvent) error {
+		if noInput && event.kind == "i" {
+			return nil
+		}
+		_, err := bw.WriteString(strings.TrimRight(string(line), "\n") + "\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if output != "-" {
+		fmt.Printf("%sExported %s to %s%s\n", green, recording.name, output, reset)
+	}
+	return nil
+}
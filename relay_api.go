@@ -1,11 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -14,6 +14,7 @@ type RelayClient struct {
 	baseURL    string
 	httpClient *http.Client
 	pcConfig   *PCConfig
+	groupID    string // Shared by sessions of one multi-session process
 }
 
 // NewRelayClient creates a new relay API client
@@ -60,11 +61,12 @@ func (c *RelayClient) InitPairing() (*PairingInitResponse, error) {
 		return nil, err
 	}
 
-	resp, err := c.httpClient.Post(
-		c.baseURL+"/api/pairing/init",
-		"application/json",
-		bytes.NewReader(body),
-	)
+	httpReq, err := c.newSignedRequest("POST", "/api/pairing/init", body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to relay: %w", err)
 	}
@@ -96,7 +98,12 @@ type PairingStatusResponse struct {
 
 // CheckPairingStatus checks if a pairing has been completed
 func (c *RelayClient) CheckPairingStatus(token string) (*PairingStatusResponse, error) {
-	resp, err := c.httpClient.Get(c.baseURL + "/api/pairing/status?token=" + token)
+	httpReq, err := c.newSignedRequest("GET", "/api/pairing/status?token="+url.QueryEscape(token), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -125,15 +132,18 @@ type CreateSessionRequest struct {
 	PCID            string            `json:"pc_id"`
 	AgentType       string            `json:"agent_type"`
 	WorkingDir      string            `json:"working_dir"`
-	DisplayName     string            `json:"display_name"`      // Short name for display
-	Token           string            `json:"token,omitempty"`   // Session token for E2E encryption
-	EncryptedTokens map[string]string `json:"encrypted_tokens"`  // mobile_id -> encrypted token
+	DisplayName     string            `json:"display_name"`         // Short name for display
+	Token           string            `json:"token,omitempty"`      // Session token for E2E encryption
+	EncryptedTokens map[string]string `json:"encrypted_tokens"`     // mobile_id -> encrypted token
+	PublicKey       string            `json:"public_key,omitempty"` // Registers the PC on a relay it didn't pair through
 	// SSH info for auto-setup
 	SSHAvailable bool     `json:"ssh_available,omitempty"`
 	SSHPort      int      `json:"ssh_port,omitempty"`
 	Hostname     string   `json:"hostname,omitempty"`
 	Username     string   `json:"username,omitempty"`
 	IPs          []string `json:"ips,omitempty"` // Local network IPs
+	// Sessions run by the same aipilot-cli process share a group ID
+	GroupID string `json:"group_id,omitempty"`
 }
 
 // CreateSessionResponse is the response from POST /api/sessions
@@ -188,6 +198,8 @@ func (c *RelayClient) CreateSession(agentType, workDir, displayName string, sshI
 		DisplayName:     displayName,
 		Token:           sessionToken,
 		EncryptedTokens: encryptedTokens,
+		PublicKey:       c.pcConfig.PublicKey,
+		GroupID:         c.groupID,
 	}
 
 	// Add SSH info if available
@@ -204,12 +216,10 @@ func (c *RelayClient) CreateSession(agentType, workDir, displayName string, sshI
 		return nil, err
 	}
 
-	httpReq, err := http.NewRequest("POST", c.baseURL+"/api/sessions", bytes.NewReader(body))
+	httpReq, err := c.newSignedRequest("POST", "/api/sessions", body)
 	if err != nil {
 		return nil, err
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("X-PC-ID", c.pcConfig.PCID)
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
@@ -247,12 +257,10 @@ func (c *RelayClient) AddSessionTokenForMobile(sessionID, mobileID, encryptedTok
 		return err
 	}
 
-	httpReq, err := http.NewRequest("POST", c.baseURL+"/api/sessions/"+sessionID+"/tokens", bytes.NewReader(body))
+	httpReq, err := c.newSignedRequest("POST", "/api/sessions/"+sessionID+"/tokens", body)
 	if err != nil {
 		return err
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("X-PC-ID", c.pcConfig.PCID)
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
@@ -273,16 +281,10 @@ func (c *RelayClient) AddSessionTokenForMobile(sessionID, mobileID, encryptedTok
 
 // DeleteSession removes a session from the relay
 func (c *RelayClient) DeleteSession(sessionID string) error {
-	httpReq, err := http.NewRequest("DELETE", c.baseURL+"/api/sessions/"+sessionID, nil)
+	httpReq, err := c.newSignedRequest("DELETE", "/api/sessions/"+sessionID, nil)
 	if err != nil {
 		return err
 	}
-	httpReq.Header.Set("X-PC-ID", c.pcConfig.PCID)
-	// NOTE: No signature auth implemented. Could sign requests with PC's X25519 private key
-	// and verify on relay with stored public key. Not critical because:
-	// - PC-ID is a random UUID, hard to guess
-	// - Sessions are ephemeral
-	// - Session tokens are E2E encrypted
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
@@ -303,11 +305,10 @@ func (c *RelayClient) DeleteSession(sessionID string) error {
 
 // PurgeAllSessions removes all sessions for this PC from the relay
 func (c *RelayClient) PurgeAllSessions() (int, error) {
-	httpReq, err := http.NewRequest("DELETE", c.baseURL+"/api/sessions", nil)
+	httpReq, err := c.newSignedRequest("DELETE", "/api/sessions", nil)
 	if err != nil {
 		return 0, err
 	}
-	httpReq.Header.Set("X-PC-ID", c.pcConfig.PCID)
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
@@ -345,16 +346,15 @@ type SessionInfo struct {
 	Status          string `json:"status"`
 	Token           string `json:"token,omitempty"`
 	CreatedAt       string `json:"created_at"`
+	GroupID         string `json:"group_id,omitempty"`
 }
 
 // ListAllSessions returns all sessions for this PC
 func (c *RelayClient) ListAllSessions() ([]SessionInfo, error) {
-	reqURL := c.baseURL + "/api/sessions?for_cli=true"
-	httpReq, err := http.NewRequest("GET", reqURL, nil)
+	httpReq, err := c.newSignedRequest("GET", "/api/sessions?for_cli=true", nil)
 	if err != nil {
 		return nil, err
 	}
-	httpReq.Header.Set("X-PC-ID", c.pcConfig.PCID)
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
@@ -376,11 +376,10 @@ func (c *RelayClient) ListAllSessions() ([]SessionInfo, error) {
 
 // UnpairMobile removes a paired mobile
 func (c *RelayClient) UnpairMobile(mobileID string) error {
-	httpReq, err := http.NewRequest("DELETE", c.baseURL+"/api/pairing/mobiles/"+mobileID, nil)
+	httpReq, err := c.newSignedRequest("DELETE", "/api/pairing/mobiles/"+mobileID, nil)
 	if err != nil {
 		return err
 	}
-	httpReq.Header.Set("X-PC-ID", c.pcConfig.PCID)
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
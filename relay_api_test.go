@@ -1,19 +1,22 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func newTestClient(handler http.HandlerFunc) (*RelayClient, *httptest.Server) {
 	server := httptest.NewServer(handler)
+	priv, _, _ := GenerateX25519KeyPair()
 	client := &RelayClient{
 		baseURL:    server.URL,
 		httpClient: server.Client(),
-		pcConfig:   &PCConfig{PCID: "test-pc"},
+		pcConfig:   &PCConfig{PCID: "test-pc", PrivateKey: hex.EncodeToString(priv[:])},
 	}
 	return client, server
 }
@@ -29,6 +32,9 @@ func TestDeleteSession_Success(t *testing.T) {
 		if r.Header.Get("X-PC-ID") != "test-pc" {
 			t.Fatalf("expected X-PC-ID header test-pc, got %s", r.Header.Get("X-PC-ID"))
 		}
+		if _, err := verifyRequestSignature(r, time.Now()); err != nil {
+			t.Fatalf("expected a valid signature: %v", err)
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"success":true}`))
 	})
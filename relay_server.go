@@ -0,0 +1,941 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Self-hostable relay server (aipilot-cli relay).
+// Implements the API used by RelayClient and connectToRelay:
+//
+//	POST   /api/pairing/init             PC starts a pairing
+//	GET    /api/pairing/status?token=    PC polls the pairing
+//	POST   /api/pairing/complete         mobile completes a pairing
+//	DELETE /api/pairing/mobiles/{id}     PC unpairs a mobile
+//	POST   /api/sessions                 PC creates a session
+//	GET    /api/sessions?for_cli=true    PC lists its sessions (with tokens)
+//	GET    /api/sessions?mobile_id=      mobile lists sessions shared with it (signed)
+//	DELETE /api/sessions                 PC purges its sessions
+//	DELETE /api/sessions/{id}            PC deletes a session
+//	POST   /api/sessions/{id}/tokens     PC shares a session with a mobile
+//	GET    /ws/{id}?role=bridge&pc_id=   CLI side of a session
+//	GET    /ws/{id}?role=mobile&mobile_id=&token=   mobile side of a session
+//
+// The relay only forwards WebSocket messages between the bridge and the
+// mobile; data payloads are end-to-end encrypted and never inspected.
+// PC calls (including the bridge WebSocket) and mobile session listings must
+// be signed, see signing.go.
+
+// RelayServer serves the relay API
+type RelayServer struct {
+	mu    sync.Mutex
+	store RelayStore
+	state *relayState
+	rooms map[string]*relayRoom // session ID -> live connections
+	seen  map[string]time.Time  // Recent request signatures (replay protection)
+}
+
+// relayRoom holds the live connections of a session
+type relayRoom struct {
+	bridge *relayPeer
+	mobile *relayPeer
+}
+
+// relayPeer is a WebSocket connection with serialized writes
+type relayPeer struct {
+	conn     *websocket.Conn
+	mu       sync.Mutex
+	mobileID string // Set for mobile peers
+}
+
+func (p *relayPeer) send(msg Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conn.WriteJSON(msg)
+}
+
+// PairingCompleteRequest is the request body for POST /api/pairing/complete
+type PairingCompleteRequest struct {
+	Token      string `json:"token"`
+	MobileID   string `json:"mobile_id"`
+	MobileName string `json:"mobile_name"`
+	PublicKey  string `json:"public_key"`
+	SigningKey string `json:"signing_key,omitempty"` // Hex Ed25519 key signing the mobile's requests
+}
+
+// PairingCompleteResponse is the response from POST /api/pairing/complete
+type PairingCompleteResponse struct {
+	PCID      string `json:"pc_id"`
+	PCName    string `json:"pc_name"`
+	PublicKey string `json:"public_key"`
+}
+
+// MobileSessionInfo is a session as listed for a mobile
+type MobileSessionInfo struct {
+	SessionInfo
+	PCID           string `json:"pc_id"`
+	EncryptedToken string `json:"encrypted_token"`
+	SSHAvailable   bool   `json:"ssh_available,omitempty"`
+	SSHPort        int    `json:"ssh_port,omitempty"`
+	Hostname       string `json:"hostname,omitempty"`
+	Username       string `json:"username,omitempty"`
+}
+
+var relayUpgrader = websocket.Upgrader{
+	ReadBufferSize:  BufferSize,
+	WriteBufferSize: BufferSize,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// NewRelayServer creates a relay server backed by the given store
+func NewRelayServer(store RelayStore) (*RelayServer, error) {
+	state, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load relay state: %w", err)
+	}
+	return &RelayServer{
+		store: store,
+		state: state,
+		rooms: make(map[string]*relayRoom),
+		seen:  make(map[string]time.Time),
+	}, nil
+}
+
+// Handler returns the HTTP handler for the relay API
+func (s *RelayServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/pairing/init", s.handlePairingInit)
+	mux.HandleFunc("/api/pairing/status", s.handlePairingStatus)
+	mux.HandleFunc("/api/pairing/complete", s.handlePairingComplete)
+	mux.HandleFunc("/api/pairing/mobiles/", s.handleUnpairMobile)
+	mux.HandleFunc("/api/sessions", s.handleSessions)
+	mux.HandleFunc("/api/sessions/", s.handleSession)
+	mux.HandleFunc("/ws/", s.handleWebSocket)
+	return mux
+}
+
+// persist saves the state. Must be called with s.mu held.
+func (s *RelayServer) persist() {
+	if err := s.store.Save(s.state); err != nil {
+		log.Printf("relay: failed to save state: %v", err)
+	}
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// --- Pairing ---
+
+func (s *RelayServer) handlePairingInit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pc, ok := s.authenticatePC(w, r, true)
+	if !ok {
+		return
+	}
+
+	var req PairingInitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PCID == "" || req.PublicKey == "" {
+		writeError(w, http.StatusBadRequest, "pc_id and public_key are required")
+		return
+	}
+	if req.PCID != pc.PCID {
+		writeError(w, http.StatusForbidden, "pc_id mismatch")
+		return
+	}
+	pc.PCName = req.PCName
+
+	// Drop pairings past their expiry (the PC stops polling by then)
+	for token, p := range s.state.Pairings {
+		if pairingExpired(p) {
+			delete(s.state.Pairings, token)
+		}
+	}
+
+	pairing := &relayPairing{
+		Token:     generateRandomToken(),
+		PCID:      req.PCID,
+		ExpiresAt: time.Now().Add(PairingTimeout).UTC().Format(time.RFC3339),
+		Status:    "pending",
+	}
+	s.state.Pairings[pairing.Token] = pairing
+	s.persist()
+
+	writeJSON(w, http.StatusOK, PairingInitResponse{Token: pairing.Token, ExpiresAt: pairing.ExpiresAt})
+}
+
+func (s *RelayServer) handlePairingStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pc, ok := s.authorizePC(w, r)
+	if !ok {
+		return
+	}
+
+	pairing, ok := s.state.Pairings[r.URL.Query().Get("token")]
+	if !ok || pairing.PCID != pc.PCID {
+		writeError(w, http.StatusNotFound, "pairing not found")
+		return
+	}
+
+	resp := PairingStatusResponse{Status: pairing.Status}
+	if pairing.Status == "pending" && pairingExpired(pairing) {
+		resp.Status = "expired"
+	}
+	if pairing.Status == "completed" {
+		resp.MobileID = pairing.MobileID
+		resp.MobileName = pairing.MobileName
+		resp.PublicKey = pairing.MobileKey
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *RelayServer) handlePairingComplete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req PairingCompleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.MobileID == "" || req.PublicKey == "" {
+		writeError(w, http.StatusBadRequest, "token, mobile_id and public_key are required")
+		return
+	}
+
+	s.mu.Lock()
+	pairing, ok := s.state.Pairings[req.Token]
+	if !ok || pairing.Status != "pending" || pairingExpired(pairing) {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "pairing not found or expired")
+		return
+	}
+	pc := s.state.PCs[pairing.PCID]
+	if pc == nil {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "pc not found")
+		return
+	}
+	if req.SigningKey != "" {
+		pinned := s.state.MobileSigningKeys[req.MobileID]
+		if pinned != "" && pinned != req.SigningKey {
+			s.mu.Unlock()
+			writeError(w, http.StatusForbidden, "signing key mismatch")
+			return
+		}
+		s.state.MobileSigningKeys[req.MobileID] = req.SigningKey
+	}
+
+	pairing.Status = "completed"
+	pairing.MobileID = req.MobileID
+	pairing.MobileName = req.MobileName
+	pairing.MobileKey = req.PublicKey
+	pairing.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+
+	pc.Mobiles[req.MobileID] = PairedMobile{
+		ID:        req.MobileID,
+		Name:      req.MobileName,
+		PublicKey: req.PublicKey,
+		PairedAt:  pairing.CompletedAt,
+	}
+	s.persist()
+
+	resp := PairingCompleteResponse{PCID: pc.PCID, PCName: pc.PCName, PublicKey: pc.PublicKey}
+	bridges := s.bridgesForPC(pc.PCID)
+	s.mu.Unlock()
+
+	// Let running CLIs of this PC share their sessions with the new mobile
+	for _, bridge := range bridges {
+		bridge.send(Message{
+			Type:       "mobile_paired",
+			MobileID:   req.MobileID,
+			MobileName: req.MobileName,
+			PublicKey:  req.PublicKey,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *RelayServer) handleUnpairMobile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	mobileID := strings.TrimPrefix(r.URL.Path, "/api/pairing/mobiles/")
+
+	s.mu.Lock()
+	pc, ok := s.authorizePC(w, r)
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	if _, paired := pc.Mobiles[mobileID]; !paired {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "mobile not paired")
+		return
+	}
+	delete(pc.Mobiles, mobileID)
+
+	// Revoke access to this PC's sessions and drop live connections of that mobile
+	var kicked []*relayPeer
+	for id, session := range s.state.Sessions {
+		if session.PCID != pc.PCID {
+			continue
+		}
+		delete(session.EncryptedTokens, mobileID)
+		if room := s.rooms[id]; room != nil && room.mobile != nil && room.mobile.mobileID == mobileID {
+			kicked = append(kicked, room.mobile)
+		}
+	}
+	s.persist()
+	s.mu.Unlock()
+
+	for _, peer := range kicked {
+		peer.conn.Close()
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// pairingExpired reports whether a pairing token is past its expiry
+func pairingExpired(p *relayPairing) bool {
+	expiresAt, err := time.Parse(time.RFC3339, p.ExpiresAt)
+	return err != nil || time.Now().After(expiresAt)
+}
+
+// authorizePC authenticates a signed request from a known PC.
+// Writes an error response and returns false otherwise. Must be called with s.mu held.
+func (s *RelayServer) authorizePC(w http.ResponseWriter, r *http.Request) (*relayPC, bool) {
+	return s.authenticatePC(w, r, false)
+}
+
+// authenticatePC verifies the signature of a PC request and returns the PC.
+// With register, the request carries the PC's public key (public_key in its
+// body): an unknown PC is created with that key and the signing key, both
+// bound to the PC ID from then on. Another signing key, or public key, is
+// refused unless the registered signing key signed the change. Writes an
+// error response and returns false on failure. Must be called with s.mu held.
+func (s *RelayServer) authenticatePC(w http.ResponseWriter, r *http.Request, register bool) (*relayPC, bool) {
+	pcID := r.Header.Get(HeaderPCID)
+	if pcID == "" {
+		writeError(w, http.StatusUnauthorized, "missing pc id")
+		return nil, false
+	}
+
+	signingKey, ok := s.verifySignature(w, r)
+	if !ok {
+		return nil, false
+	}
+	publicKey := ""
+	if register {
+		publicKey = registrationPublicKey(r)
+	}
+
+	pc := s.state.PCs[pcID]
+	switch {
+	case pc == nil:
+		if publicKey == "" {
+			writeError(w, http.StatusUnauthorized, "unknown pc")
+			return nil, false
+		}
+		pc = &relayPC{PCID: pcID, PublicKey: publicKey, SigningKey: signingKey, Mobiles: make(map[string]PairedMobile)}
+		s.state.PCs[pcID] = pc
+		s.persist()
+		return pc, true
+	case pc.SigningKey == "" && publicKey != "" && (pc.PublicKey == "" || publicKey == pc.PublicKey):
+		// Registered before requests were signed: bound by its next registration
+		pc.SigningKey = signingKey
+		s.persist()
+	case pc.SigningKey != signingKey:
+		if pc.SigningKey == "" || !verifyKeyChange(r, pcID, pc.SigningKey, signingKey) {
+			writeError(w, http.StatusUnauthorized, "signing key mismatch")
+			return nil, false
+		}
+		pc.SigningKey = signingKey
+		if publicKey != "" {
+			pc.PublicKey = publicKey
+		}
+		s.persist()
+	}
+
+	if publicKey != "" && publicKey != pc.PublicKey {
+		if pc.PublicKey != "" {
+			// A PC ID is bound to the key it registered with
+			writeError(w, http.StatusForbidden, "public key mismatch")
+			return nil, false
+		}
+		pc.PublicKey = publicKey
+		s.persist()
+	}
+	return pc, true
+}
+
+// registrationPublicKey returns the public_key of a PC request body, which is
+// restored for the handler
+func registrationPublicKey(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var req struct {
+		PublicKey string `json:"public_key"`
+	}
+	if err != nil || json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	return req.PublicKey
+}
+
+// verifySignature checks the signature of a request and that it is not
+// replayed, and returns the signing key. Writes an error response and returns
+// false on failure. Must be called with s.mu held.
+func (s *RelayServer) verifySignature(w http.ResponseWriter, r *http.Request) (string, bool) {
+	now := time.Now()
+	signingKey, err := verifyRequestSignature(r, now)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return "", false
+	}
+
+	// A signature is only valid once
+	for signature, expiry := range s.seen {
+		if now.After(expiry) {
+			delete(s.seen, signature)
+		}
+	}
+	signature := r.Header.Get(HeaderSignature)
+	if _, replayed := s.seen[signature]; replayed {
+		writeError(w, http.StatusUnauthorized, "replayed request")
+		return "", false
+	}
+	s.seen[signature] = now.Add(2 * RequestSignatureMaxAge)
+	return signingKey, true
+}
+
+// authenticateMobile verifies the signature of a mobile request against the
+// signing key pinned to the mobile at pairing. A mobile paired before mobiles
+// signed has no key yet: it is pinned on first use, as for PCs. Writes an
+// error response and returns false on failure. Must be called with s.mu held.
+func (s *RelayServer) authenticateMobile(w http.ResponseWriter, r *http.Request, mobileID string) bool {
+	signingKey, ok := s.verifySignature(w, r)
+	if !ok {
+		return false
+	}
+
+	pinned := s.state.MobileSigningKeys[mobileID]
+	if pinned == "" {
+		if !s.isPairedMobile(mobileID) {
+			writeError(w, http.StatusUnauthorized, "unknown mobile")
+			return false
+		}
+		s.state.MobileSigningKeys[mobileID] = signingKey
+		s.persist()
+	} else if pinned != signingKey {
+		writeError(w, http.StatusUnauthorized, "signing key mismatch")
+		return false
+	}
+	return true
+}
+
+// isPairedMobile reports whether a mobile is paired with any PC. Must be called with s.mu held.
+func (s *RelayServer) isPairedMobile(mobileID string) bool {
+	for _, pc := range s.state.PCs {
+		if _, paired := pc.Mobiles[mobileID]; paired {
+			return true
+		}
+	}
+	return false
+}
+
+// bridgesForPC returns the live bridge connections of a PC. Must be called with s.mu held.
+func (s *RelayServer) bridgesForPC(pcID string) []*relayPeer {
+	var bridges []*relayPeer
+	for id, room := range s.rooms {
+		if room.bridge == nil {
+			continue
+		}
+		if session := s.state.Sessions[id]; session != nil && session.PCID == pcID {
+			bridges = append(bridges, room.bridge)
+		}
+	}
+	return bridges
+}
+
+// --- Sessions ---
+
+func (s *RelayServer) handleSessions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.handleCreateSession(w, r)
+	case http.MethodGet:
+		if mobileID := r.URL.Query().Get("mobile_id"); mobileID != "" {
+			s.handleListMobileSessions(w, r, mobileID)
+		} else {
+			s.handleListPCSessions(w, r)
+		}
+	case http.MethodDelete:
+		s.handlePurgeSessions(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *RelayServer) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A PC paired through another relay registers on its first session,
+	// with its public key
+	pc, ok := s.authenticatePC(w, r, true)
+	if !ok {
+		return
+	}
+
+	var req CreateSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.PCID != pc.PCID {
+		writeError(w, http.StatusForbidden, "pc_id mismatch")
+		return
+	}
+	if req.Token == "" {
+		req.Token = generateRandomToken()
+	}
+	if req.EncryptedTokens == nil {
+		req.EncryptedTokens = make(map[string]string)
+	}
+
+	session := &relaySession{
+		CreateSessionRequest: req,
+		ID:                   uuid.New().String(),
+		CreatedAt:            time.Now().UTC().Format(time.RFC3339),
+	}
+	s.state.Sessions[session.ID] = session
+	s.persist()
+
+	writeJSON(w, http.StatusCreated, CreateSessionResponse{SessionID: session.ID, Token: session.Token})
+}
+
+func (s *RelayServer) handleListPCSessions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pc, ok := s.authorizePC(w, r)
+	if !ok {
+		return
+	}
+
+	forCLI := r.URL.Query().Get("for_cli") == "true"
+	sessions := []SessionInfo{}
+	for _, session := range s.state.Sessions {
+		if session.PCID != pc.PCID {
+			continue
+		}
+		info := s.sessionInfo(session)
+		if forCLI {
+			info.Token = session.Token
+		}
+		sessions = append(sessions, info)
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func (s *RelayServer) handleListMobileSessions(w http.ResponseWriter, r *http.Request, mobileID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authenticateMobile(w, r, mobileID) {
+		return
+	}
+
+	sessions := []MobileSessionInfo{}
+	for _, session := range s.state.Sessions {
+		encrypted, shared := session.EncryptedTokens[mobileID]
+		if !shared {
+			continue
+		}
+		sessions = append(sessions, MobileSessionInfo{
+			SessionInfo:    s.sessionInfo(session),
+			PCID:           session.PCID,
+			EncryptedToken: encrypted,
+			SSHAvailable:   session.SSHAvailable,
+			SSHPort:        session.SSHPort,
+			Hostname:       session.Hostname,
+			Username:       session.Username,
+		})
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func (s *RelayServer) handlePurgeSessions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	pc, ok := s.authorizePC(w, r)
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+
+	var peers []*relayPeer
+	count := 0
+	for id, session := range s.state.Sessions {
+		if session.PCID == pc.PCID {
+			peers = append(peers, s.removeSession(id)...)
+			count++
+		}
+	}
+	s.persist()
+	s.mu.Unlock()
+
+	closePeers(peers)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "deleted_count": count})
+}
+
+// handleSession serves /api/sessions/{id} and /api/sessions/{id}/tokens
+func (s *RelayServer) handleSession(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/sessions/")
+	id, sub, _ := strings.Cut(path, "/")
+
+	switch {
+	case sub == "" && r.Method == http.MethodDelete:
+		s.handleDeleteSession(w, r, id)
+	case sub == "tokens" && r.Method == http.MethodPost:
+		s.handleAddSessionToken(w, r, id)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *RelayServer) handleDeleteSession(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	session, ok := s.ownedSession(w, r, id)
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	peers := s.removeSession(session.ID)
+	s.persist()
+	s.mu.Unlock()
+
+	closePeers(peers)
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+func (s *RelayServer) handleAddSessionToken(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.ownedSession(w, r, id)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		MobileID       string `json:"mobile_id"`
+		EncryptedToken string `json:"encrypted_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MobileID == "" || req.EncryptedToken == "" {
+		writeError(w, http.StatusBadRequest, "mobile_id and encrypted_token are required")
+		return
+	}
+	if _, paired := s.state.PCs[session.PCID].Mobiles[req.MobileID]; !paired {
+		writeError(w, http.StatusForbidden, "mobile not paired with this pc")
+		return
+	}
+	if session.EncryptedTokens == nil {
+		session.EncryptedTokens = make(map[string]string)
+	}
+	session.EncryptedTokens[req.MobileID] = req.EncryptedToken
+	s.persist()
+
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// ownedSession returns a session owned by the calling PC.
+// Writes an error response and returns false otherwise. Must be called with s.mu held.
+func (s *RelayServer) ownedSession(w http.ResponseWriter, r *http.Request, id string) (*relaySession, bool) {
+	pc, ok := s.authorizePC(w, r)
+	if !ok {
+		return nil, false
+	}
+	session := s.state.Sessions[id]
+	if session == nil || session.PCID != pc.PCID {
+		writeError(w, http.StatusNotFound, "session not found")
+		return nil, false
+	}
+	return session, true
+}
+
+// sessionInfo converts a stored session to its API representation. Must be called with s.mu held.
+func (s *RelayServer) sessionInfo(session *relaySession) SessionInfo {
+	status := "inactive"
+	if room := s.rooms[session.ID]; room != nil && room.bridge != nil {
+		status = "active"
+	}
+	return SessionInfo{
+		ID:          session.ID,
+		AgentType:   session.AgentType,
+		WorkingDir:  session.WorkingDir,
+		DisplayName: session.DisplayName,
+		Status:      status,
+		CreatedAt:   session.CreatedAt,
+		GroupID:     session.GroupID,
+	}
+}
+
+// removeSession deletes a session and returns its live connections for closing.
+// Must be called with s.mu held.
+func (s *RelayServer) removeSession(id string) []*relayPeer {
+	delete(s.state.Sessions, id)
+	room := s.rooms[id]
+	delete(s.rooms, id)
+
+	var peers []*relayPeer
+	if room != nil {
+		if room.bridge != nil {
+			peers = append(peers, room.bridge)
+		}
+		if room.mobile != nil {
+			peers = append(peers, room.mobile)
+		}
+	}
+	return peers
+}
+
+func closePeers(peers []*relayPeer) {
+	for _, peer := range peers {
+		peer.conn.Close()
+	}
+}
+
+// --- WebSocket ---
+
+func (s *RelayServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/ws/")
+	query := r.URL.Query()
+	role := query.Get("role")
+
+	s.mu.Lock()
+	session := s.state.Sessions[id]
+	if session == nil {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	authorized := false
+	switch role {
+	case "bridge":
+		// The bridge upgrade request is signed like API calls
+		pc, ok := s.authorizePC(w, r)
+		if !ok {
+			s.mu.Unlock()
+			return
+		}
+		authorized = pc.PCID == session.PCID
+	case "mobile":
+		_, shared := session.EncryptedTokens[query.Get("mobile_id")]
+		authorized = shared && query.Get("token") == session.Token
+	}
+	s.mu.Unlock()
+
+	if !authorized {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	conn, err := relayUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	conn.SetReadLimit(MaxRelayFrameSize)
+	peer := &relayPeer{conn: conn, mobileID: query.Get("mobile_id")}
+	defer conn.Close()
+
+	if !s.joinRoom(id, role, peer) {
+		return
+	}
+	s.forwardMessages(id, role, peer)
+	s.leaveRoom(id, role, peer)
+}
+
+// joinRoom registers a peer in a session room and notifies the other side
+func (s *RelayServer) joinRoom(id, role string, peer *relayPeer) bool {
+	s.mu.Lock()
+	if s.state.Sessions[id] == nil {
+		s.mu.Unlock()
+		return false
+	}
+	room := s.rooms[id]
+	if room == nil {
+		room = &relayRoom{}
+		s.rooms[id] = room
+	}
+
+	// A reconnecting peer replaces the previous connection
+	var previous, other *relayPeer
+	if role == "bridge" {
+		previous, room.bridge = room.bridge, peer
+		other = room.mobile
+	} else {
+		previous, room.mobile = room.mobile, peer
+		other = room.bridge
+	}
+	s.mu.Unlock()
+
+	if previous != nil {
+		previous.conn.Close()
+	}
+	if err := peer.send(Message{Type: "registered", Session: id, Role: role}); err != nil {
+		return false
+	}
+	if other != nil {
+		// The bridge is told which mobile connected
+		toOther := Message{Type: "connected", Role: role, MobileID: peer.mobileID}
+		toPeer := Message{Type: "connected", Role: "mobile", MobileID: other.mobileID}
+		if role == "mobile" {
+			toPeer = Message{Type: "connected", Role: "bridge"}
+		} else {
+			toOther = Message{Type: "connected", Role: "bridge"}
+		}
+		other.send(toOther)
+		peer.send(toPeer)
+	}
+	return true
+}
+
+// forwardMessages relays messages from a peer to the other side of the session
+func (s *RelayServer) forwardMessages(id, role string, peer *relayPeer) {
+	for {
+		peer.conn.SetReadDeadline(time.Now().Add(PingInterval * 3))
+
+		var msg Message
+		if err := peer.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case "ping":
+			if err := peer.send(Message{Type: "pong"}); err != nil {
+				return
+			}
+		case "data":
+			s.mu.Lock()
+			var other *relayPeer
+			if room := s.rooms[id]; room != nil {
+				if role == "bridge" {
+					other = room.mobile
+				} else {
+					other = room.bridge
+				}
+			}
+			s.mu.Unlock()
+			if other != nil {
+				other.send(Message{Type: "data", Payload: msg.Payload})
+			}
+		}
+	}
+}
+
+// leaveRoom unregisters a peer. When the bridge leaves, the session is gone:
+// the CLI creates a new one when it reconnects.
+func (s *RelayServer) leaveRoom(id, role string, peer *relayPeer) {
+	s.mu.Lock()
+	room := s.rooms[id]
+	if room == nil {
+		s.mu.Unlock()
+		return
+	}
+
+	var notify []*relayPeer
+	if role == "bridge" && room.bridge == peer {
+		room.bridge = nil
+		notify = s.removeSession(id)
+		s.persist()
+	} else if role == "mobile" && room.mobile == peer {
+		room.mobile = nil
+		if room.bridge != nil {
+			notify = append(notify, room.bridge)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, other := range notify {
+		other.send(Message{Type: "disconnected", Role: role})
+		if role == "bridge" {
+			// Session deleted: disconnect the mobile as well
+			other.conn.Close()
+		}
+	}
+}
+
+// relayMain is the entry point for the "relay" subcommand
+func relayMain(args []string) {
+	fs := flag.NewFlagSet("relay", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "Address to listen on")
+	dataDir := fs.String("data-dir", "", "Directory for persistent state (default: in-memory only)")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file (serves wss://)")
+	tlsKey := fs.String("tls-key", "", "TLS private key file")
+	fs.Parse(args)
+
+	store := NewMemoryRelayStore()
+	if *dataDir != "" {
+		store = NewFileRelayStore(*dataDir)
+	}
+
+	server, err := NewRelayServer(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	httpServer := &http.Server{
+		Addr:              *listen,
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: HTTPClientTimeout,
+	}
+
+	scheme := "ws"
+	if *tlsCert != "" {
+		scheme = "wss"
+	}
+	fmt.Printf("%sAIPilot relay%s listening on %s\n", bold, reset, *listen)
+	fmt.Printf("%sPoint the CLI at it with: aipilot-cli --relay %s://<host>%s%s\n", dim, scheme, *listen, reset)
+
+	if *tlsCert != "" {
+		err = httpServer.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	log.Fatal(err)
+}
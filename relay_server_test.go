@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestRelay starts an in-memory relay and a RelayClient with a real PC identity
+func newTestRelay(t *testing.T) (*RelayClient, *httptest.Server) {
+	t.Helper()
+	relay, err := NewRelayServer(NewMemoryRelayStore())
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+	server := httptest.NewServer(relay.Handler())
+
+	priv, pub, err := GenerateX25519KeyPair()
+	if err != nil {
+		t.Fatalf("failed to generate keys: %v", err)
+	}
+	config := &PCConfig{
+		PCID:       "pc-1",
+		PCName:     "test-pc",
+		PrivateKey: hex.EncodeToString(priv[:]),
+		PublicKey:  hex.EncodeToString(pub[:]),
+	}
+	return NewRelayClient(server.URL, config), server
+}
+
+// completeTestPairing plays the mobile side of a pairing
+func completeTestPairing(t *testing.T, server *httptest.Server, token, mobileID string) PairedMobile {
+	t.Helper()
+	_, pub, _ := GenerateX25519KeyPair()
+	mobile := PairedMobile{ID: mobileID, Name: "phone", PublicKey: hex.EncodeToString(pub[:])}
+	body, _ := json.Marshal(PairingCompleteRequest{
+		Token:      token,
+		MobileID:   mobile.ID,
+		MobileName: mobile.Name,
+		PublicKey:  mobile.PublicKey,
+	})
+	resp, err := http.Post(server.URL+"/api/pairing/complete", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("pairing complete failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("pairing complete returned %s", resp.Status)
+	}
+	return mobile
+}
+
+func TestRelayServer_PairingAndSessions(t *testing.T) {
+	client, server := newTestRelay(t)
+	defer server.Close()
+
+	pairing, err := client.InitPairing()
+	if err != nil {
+		t.Fatalf("InitPairing: %v", err)
+	}
+	status, err := client.CheckPairingStatus(pairing.Token)
+	if err != nil || status.Status != "pending" {
+		t.Fatalf("expected pending pairing, got %+v (%v)", status, err)
+	}
+
+	mobile := completeTestPairing(t, server, pairing.Token, "mob-1")
+	status, err = client.CheckPairingStatus(pairing.Token)
+	if err != nil || status.Status != "completed" || status.MobileID != "mob-1" || status.PublicKey != mobile.PublicKey {
+		t.Fatalf("unexpected pairing status: %+v (%v)", status, err)
+	}
+	client.pcConfig.addPairedMobile(mobile)
+
+	session, err := client.CreateSession("claude", "/work", "work", nil)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	sessions, err := client.ListAllSessions()
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("expected 1 session, got %d (%v)", len(sessions), err)
+	}
+	if sessions[0].ID != session.SessionID || sessions[0].Token != session.Token {
+		t.Fatalf("unexpected session: %+v", sessions[0])
+	}
+
+	if err := client.AddSessionTokenForMobile(session.SessionID, "mob-1", "enc"); err != nil {
+		t.Fatalf("AddSessionTokenForMobile: %v", err)
+	}
+	if err := client.AddSessionTokenForMobile(session.SessionID, "unknown", "enc"); err == nil {
+		t.Fatal("expected error sharing a session with an unpaired mobile")
+	}
+
+	if err := client.DeleteSession(session.SessionID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if err := client.DeleteSession(session.SessionID); err == nil {
+		t.Fatal("expected error deleting a missing session")
+	}
+
+	client.CreateSession("claude", "/a", "a", nil)
+	client.CreateSession("gemini", "/b", "b", nil)
+	count, err := client.PurgeAllSessions()
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 purged sessions, got %d (%v)", count, err)
+	}
+
+	if err := client.UnpairMobile("mob-1"); err != nil {
+		t.Fatalf("UnpairMobile: %v", err)
+	}
+}
+
+func TestRelayServer_OtherPCCannotDeleteSession(t *testing.T) {
+	client, server := newTestRelay(t)
+	defer server.Close()
+
+	session, err := client.CreateSession("claude", "/work", "work", nil)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	priv, _, _ := GenerateX25519KeyPair()
+	intruder := NewRelayClient(server.URL, &PCConfig{PCID: "pc-2", PrivateKey: hex.EncodeToString(priv[:])})
+	if err := intruder.DeleteSession(session.SessionID); err == nil {
+		t.Fatal("expected another PC to be refused")
+	}
+}
+
+func TestRelayServer_LeakedPCIDIsRefused(t *testing.T) {
+	client, server := newTestRelay(t)
+	defer server.Close()
+
+	if _, err := client.CreateSession("claude", "/work", "work", nil); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	// Same PC ID, different key: the signing key pinned on first use doesn't match
+	priv, _, _ := GenerateX25519KeyPair()
+	impostor := NewRelayClient(server.URL, &PCConfig{PCID: client.pcConfig.PCID, PrivateKey: hex.EncodeToString(priv[:])})
+	if _, err := impostor.ListAllSessions(); err == nil {
+		t.Fatal("expected a foreign signing key to be refused")
+	}
+	if _, err := impostor.PurgeAllSessions(); err == nil {
+		t.Fatal("expected a foreign signing key to be refused")
+	}
+
+	// Unsigned requests are refused
+	req, _ := http.NewRequest("GET", server.URL+"/api/sessions?for_cli=true", nil)
+	req.Header.Set(HeaderPCID, client.pcConfig.PCID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an unsigned request, got %s", resp.Status)
+	}
+
+	// A captured signed request can't be replayed
+	signed, _ := client.newSignedRequest("GET", "/api/sessions?for_cli=true", nil)
+	for i, want := range []int{http.StatusOK, http.StatusUnauthorized} {
+		replay, _ := http.NewRequest("GET", signed.URL.String(), nil)
+		replay.Header = signed.Header.Clone()
+		resp, err := http.DefaultClient.Do(replay)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("attempt %d: expected %d, got %s", i+1, want, resp.Status)
+		}
+	}
+}
+
+func TestRelayServer_PCIDCannotBeTakenOver(t *testing.T) {
+	client, server := newTestRelay(t)
+	defer server.Close()
+	if _, err := client.InitPairing(); err != nil {
+		t.Fatalf("InitPairing: %v", err)
+	}
+
+	// The PC ID and public key are in every QR code: they don't register another signing key
+	priv, _, _ := GenerateX25519KeyPair()
+	impostor := NewRelayClient(server.URL, &PCConfig{
+		PCID:       client.pcConfig.PCID,
+		PublicKey:  client.pcConfig.PublicKey,
+		PrivateKey: hex.EncodeToString(priv[:]),
+	})
+	if _, err := impostor.InitPairing(); err == nil {
+		t.Fatal("expected a pairing with another signing key to be refused")
+	}
+	if _, err := impostor.CreateSession("claude", "/work", "work", nil); err == nil {
+		t.Fatal("expected a session with another signing key to be refused")
+	}
+	if _, err := client.ListAllSessions(); err != nil {
+		t.Fatalf("registered PC locked out: %v", err)
+	}
+
+	// The signed PC ID can't be swapped for another one
+	signed, _ := client.newSignedRequest("GET", "/api/sessions?for_cli=true", nil)
+	signed.Header.Set(HeaderPCID, "pc-2")
+	if _, err := verifyRequestSignature(signed, time.Now()); err == nil {
+		t.Fatal("expected a request with another PC ID to be refused")
+	}
+
+	// The registered key hands the PC ID over to a new one
+	oldKey, _ := deriveSigningKey(client.pcConfig.PrivateKey)
+	newKey, _ := deriveSigningKey(impostor.pcConfig.PrivateKey)
+	req, _ := impostor.newSignedRequest("GET", "/api/sessions?for_cli=true", nil)
+	req.Header.Set(HeaderKeyProof, keyChangeProof(oldKey, client.pcConfig.PCID, newKey.Public().(ed25519.PublicKey)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("proven key change refused: %s", resp.Status)
+	}
+	if _, err := client.ListAllSessions(); err == nil {
+		t.Fatal("expected the replaced signing key to be refused")
+	}
+}
+
+func TestRelayServer_WebSocketForwarding(t *testing.T) {
+	client, server := newTestRelay(t)
+	defer server.Close()
+
+	pairing, _ := client.InitPairing()
+	mobile := completeTestPairing(t, server, pairing.Token, "mob-1")
+	client.pcConfig.addPairedMobile(mobile)
+	session, err := client.CreateSession("claude", "/work", "work", nil)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + session.SessionID
+	if _, _, err := websocket.DefaultDialer.Dial(wsURL+"?role=bridge&pc_id=pc-1", nil); err == nil {
+		t.Fatal("expected unsigned bridge to be refused")
+	}
+	header, _ := client.signedHeaders("GET", "/ws/"+session.SessionID+"?role=bridge&pc_id=pc-1", nil)
+	bridge, _, err := websocket.DefaultDialer.Dial(wsURL+"?role=bridge&pc_id=pc-1", header)
+	if err != nil {
+		t.Fatalf("bridge dial: %v", err)
+	}
+	defer bridge.Close()
+	expectMessage(t, bridge, "registered", "")
+
+	if _, _, err := websocket.DefaultDialer.Dial(wsURL+"?role=mobile&mobile_id=mob-1&token=wrong", nil); err == nil {
+		t.Fatal("expected mobile with wrong token to be refused")
+	}
+
+	mobileConn, _, err := websocket.DefaultDialer.Dial(wsURL+"?role=mobile&mobile_id=mob-1&token="+session.Token, nil)
+	if err != nil {
+		t.Fatalf("mobile dial: %v", err)
+	}
+	defer mobileConn.Close()
+	expectMessage(t, mobileConn, "registered", "")
+	if msg := expectMessage(t, bridge, "connected", "mobile"); msg.MobileID != "mob-1" {
+		t.Fatalf("bridge not told the mobile ID: %+v", msg)
+	}
+	expectMessage(t, mobileConn, "connected", "bridge")
+
+	mobileConn.WriteJSON(Message{Type: "data", Payload: "to-bridge"})
+	if msg := expectMessage(t, bridge, "data", ""); msg.Payload != "to-bridge" {
+		t.Fatalf("unexpected payload %q", msg.Payload)
+	}
+	bridge.WriteJSON(Message{Type: "data", Payload: "to-mobile"})
+	if msg := expectMessage(t, mobileConn, "data", ""); msg.Payload != "to-mobile" {
+		t.Fatalf("unexpected payload %q", msg.Payload)
+	}
+
+	bridge.WriteJSON(Message{Type: "ping"})
+	expectMessage(t, bridge, "pong", "")
+
+	mobileConn.Close()
+	expectMessage(t, bridge, "disconnected", "mobile")
+}
+
+// listMobileSessions lists the sessions of a mobile, signed with signingKey
+// unless nil, and returns the status code and the session count
+func listMobileSessions(t *testing.T, server *httptest.Server, mobileID string, signingKey ed25519.PrivateKey) (int, int) {
+	t.Helper()
+	path := "/api/sessions?mobile_id=" + mobileID
+	req, _ := http.NewRequest("GET", server.URL+path, nil)
+	if signingKey != nil {
+		req.Header = signatureHeaders(signingKey, "", "GET", path, nil)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var sessions []MobileSessionInfo
+	json.NewDecoder(resp.Body).Decode(&sessions)
+	return resp.StatusCode, len(sessions)
+}
+
+func TestRelayServer_MobileSessionListingIsSigned(t *testing.T) {
+	client, server := newTestRelay(t)
+	defer server.Close()
+
+	// mob-1 pins its signing key at pairing, mob-2 pairs like older apps
+	mobilePriv, mobilePub, _ := GenerateX25519KeyPair()
+	mobileKey, _ := deriveSigningKey(hex.EncodeToString(mobilePriv[:]))
+	pairing, _ := client.InitPairing()
+	body, _ := json.Marshal(PairingCompleteRequest{
+		Token:      pairing.Token,
+		MobileID:   "mob-1",
+		PublicKey:  hex.EncodeToString(mobilePub[:]),
+		SigningKey: hex.EncodeToString(mobileKey.Public().(ed25519.PublicKey)),
+	})
+	resp, err := http.Post(server.URL+"/api/pairing/complete", "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("pairing complete failed: %v %v", resp, err)
+	}
+	resp.Body.Close()
+	pairing, _ = client.InitPairing()
+	completeTestPairing(t, server, pairing.Token, "mob-2")
+
+	session, _ := client.CreateSession("claude", "/work", "work", nil)
+	client.AddSessionTokenForMobile(session.SessionID, "mob-1", "enc")
+	client.AddSessionTokenForMobile(session.SessionID, "mob-2", "enc")
+
+	otherPriv, _, _ := GenerateX25519KeyPair()
+	otherKey, _ := deriveSigningKey(hex.EncodeToString(otherPriv[:]))
+
+	if status, _ := listMobileSessions(t, server, "mob-1", nil); status != http.StatusUnauthorized {
+		t.Fatalf("unsigned listing: expected 401, got %d", status)
+	}
+	if status, _ := listMobileSessions(t, server, "mob-1", otherKey); status != http.StatusUnauthorized {
+		t.Fatalf("foreign key: expected 401, got %d", status)
+	}
+	if status, count := listMobileSessions(t, server, "mob-1", mobileKey); status != http.StatusOK || count != 1 {
+		t.Fatalf("expected 1 session, got %d sessions (%d)", count, status)
+	}
+
+	// Pinned on first use, then only that key is accepted
+	if status, count := listMobileSessions(t, server, "mob-2", otherKey); status != http.StatusOK || count != 1 {
+		t.Fatalf("expected 1 session, got %d sessions (%d)", count, status)
+	}
+	if status, _ := listMobileSessions(t, server, "mob-2", mobileKey); status != http.StatusUnauthorized {
+		t.Fatalf("foreign key after pinning: expected 401, got %d", status)
+	}
+	if status, _ := listMobileSessions(t, server, "unknown", otherKey); status != http.StatusUnauthorized {
+		t.Fatalf("unknown mobile: expected 401, got %d", status)
+	}
+}
+
+func expectMessage(t *testing.T, conn *websocket.Conn, msgType, role string) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg Message
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("expected %s message: %v", msgType, err)
+	}
+	if msg.Type != msgType || (role != "" && msg.Role != role) {
+		t.Fatalf("expected %s/%s, got %+v", msgType, role, msg)
+	}
+	return msg
+}
+
+func TestFileRelayStore_NullMapsFilled(t *testing.T) {
+	dir := t.TempDir()
+	state := `{"pcs": {"pc-1": {"pc_id": "pc-1", "mobiles": null}, "pc-2": null}, "sessions": null}`
+	if err := os.WriteFile(filepath.Join(dir, "relay.json"), []byte(state), FilePermissions); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewFileRelayStore(dir).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.PCs["pc-1"].Mobiles == nil || loaded.Sessions == nil || loaded.PCs["pc-2"] != nil {
+		t.Fatalf("null maps left in the loaded state: %+v", loaded)
+	}
+	loaded.PCs["pc-1"].Mobiles["mobile-1"] = PairedMobile{ID: "mobile-1"}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Storage for the self-hosted relay (aipilot-cli relay).
+// The relay keeps its whole state in memory; a RelayStore only decides
+// whether that state survives a restart.
+
+// relayPairing is a pending or completed pairing request
+type relayPairing struct {
+	Token       string `json:"token"`
+	PCID        string `json:"pc_id"`
+	ExpiresAt   string `json:"expires_at"`
+	Status      string `json:"status"` // "pending", "completed"
+	MobileID    string `json:"mobile_id,omitempty"`
+	MobileName  string `json:"mobile_name,omitempty"`
+	MobileKey   string `json:"mobile_public_key,omitempty"`
+	CompletedAt string `json:"completed_at,omitempty"`
+}
+
+// relayPC is a PC known to the relay, with its paired mobiles
+type relayPC struct {
+	PCID       string                  `json:"pc_id"`
+	PCName     string                  `json:"pc_name"`
+	PublicKey  string                  `json:"public_key"`
+	SigningKey string                  `json:"signing_key"` // Pinned Ed25519 request signing key
+	Mobiles    map[string]PairedMobile `json:"mobiles"`     // mobile_id -> mobile
+}
+
+// relaySession is a session registered by a PC
+type relaySession struct {
+	CreateSessionRequest
+	ID        string `json:"id"`
+	CreatedAt string `json:"created_at"`
+}
+
+// relayState is everything the relay knows
+type relayState struct {
+	PCs      map[string]*relayPC      `json:"pcs"`
+	Pairings map[string]*relayPairing `json:"pairings"` // pairing token -> pairing
+	Sessions map[string]*relaySession `json:"sessions"` // session ID -> session
+	// Pinned Ed25519 request signing keys of mobiles, mobile_id -> key
+	MobileSigningKeys map[string]string `json:"mobile_signing_keys"`
+}
+
+func newRelayState() *relayState {
+	return &relayState{
+		PCs:               make(map[string]*relayPC),
+		Pairings:          make(map[string]*relayPairing),
+		Sessions:          make(map[string]*relaySession),
+		MobileSigningKeys: make(map[string]string),
+	}
+}
+
+// fillMaps replaces the maps a state file left null, which would panic on
+// the first write
+func (st *relayState) fillMaps() {
+	if st.PCs == nil {
+		st.PCs = make(map[string]*relayPC)
+	}
+	if st.Pairings == nil {
+		st.Pairings = make(map[string]*relayPairing)
+	}
+	if st.Sessions == nil {
+		st.Sessions = make(map[string]*relaySession)
+	}
+	if st.MobileSigningKeys == nil {
+		st.MobileSigningKeys = make(map[string]string)
+	}
+	for id, pc := range st.PCs {
+		if pc == nil {
+			delete(st.PCs, id)
+		} else if pc.Mobiles == nil {
+			pc.Mobiles = make(map[string]PairedMobile)
+		}
+	}
+}
+
+// RelayStore loads and persists the relay state
+type RelayStore interface {
+	Load() (*relayState, error)
+	Save(state *relayState) error
+}
+
+// memoryRelayStore keeps nothing: state is lost on restart
+type memoryRelayStore struct{}
+
+// NewMemoryRelayStore returns a store that doesn't persist anything
+func NewMemoryRelayStore() RelayStore {
+	return memoryRelayStore{}
+}
+
+func (memoryRelayStore) Load() (*relayState, error) {
+	return newRelayState(), nil
+}
+
+func (memoryRelayStore) Save(state *relayState) error {
+	return nil
+}
+
+// fileRelayStore persists the state as a JSON file
+type fileRelayStore struct {
+	path string
+}
+
+// NewFileRelayStore returns a store persisting to relay.json in dir
+func NewFileRelayStore(dir string) RelayStore {
+	return &fileRelayStore{path: filepath.Join(dir, "relay.json")}
+}
+
+func (s *fileRelayStore) Load() (*relayState, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return newRelayState(), nil
+		}
+		return nil, err
+	}
+
+	state := newRelayState()
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	state.fillMaps()
+	return state, nil
+}
+
+func (s *fileRelayStore) Save(state *relayState) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), DirPermissions); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file and rename, so a crash never leaves a truncated file
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, FilePermissions); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
@@ -0,0 +1,846 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Headless VT100/xterm screen model.
+// Fed with the same PTY output as the real terminal, it tracks the character
+// grid, cursor, attributes and alternate screen so the current screen can be
+// sent to mobile without resizing the PTY or sending Ctrl+L to the agent.
+
+// Cell attribute flags (SGR)
+const (
+	attrBold = 1 << iota
+	attrDim
+	attrItalic
+	attrUnderline
+	attrBlink
+	attrReverse
+	attrHidden
+	attrStrike
+)
+
+const (
+	// colorDefault is the terminal's default foreground/background color
+	colorDefault = -1
+	// colorRGB flags a 24-bit color; the lower 24 bits hold 0xRRGGBB
+	colorRGB = 1 << 24
+)
+
+// Parser states for the screen model
+const (
+	vtGround  = 0 // Normal text
+	vtEscape  = 1 // Just saw ESC
+	vtCharset = 2 // ESC ( ) * + # — one more byte to consume
+	vtCSI     = 3 // ESC [ ... final byte
+	vtString  = 4 // OSC/DCS/APC/PM/SOS — terminated by BEL or ST
+	vtStrEsc  = 5 // In a string, saw ESC (awaiting \ for ST)
+)
+
+// cellStyle holds the colors and attributes of a cell
+type cellStyle struct {
+	fg, bg int32
+	attrs  uint8
+}
+
+var defaultStyle = cellStyle{fg: colorDefault, bg: colorDefault}
+
+// screenCell is a single character cell. ch == 0 marks the right half of a wide character.
+type screenCell struct {
+	ch    rune
+	style cellStyle
+}
+
+// savedCursor is the state saved by DECSC (ESC 7) and restored by DECRC (ESC 8)
+type savedCursor struct {
+	x, y  int
+	style cellStyle
+}
+
+// VirtualScreen is an in-process terminal emulator tracking the visible screen
+type VirtualScreen struct {
+	mu sync.Mutex
+
+	cols, rows int
+	grid       [][]screenCell // Active grid (mainGrid or altGrid)
+	mainGrid   [][]screenCell
+	altGrid    [][]screenCell
+	altActive  bool
+
+	curX, curY    int
+	wrapNext      bool // Cursor sits past the last column, wrap on next print
+	style         cellStyle
+	cursorVisible bool
+	autoWrap      bool
+	scrollTop     int
+	scrollBottom  int
+	saved         savedCursor
+	altSaved      savedCursor // Saved by mode 1049 when entering the alt screen
+
+	state   int
+	params  []byte
+	utf8Buf []byte
+}
+
+// ScreenSnapshot is the JSON payload of the screen-snapshot control message
+type ScreenSnapshot struct {
+	Cols          int    `json:"cols"`
+	Rows          int    `json:"rows"`
+	CursorRow     int    `json:"cursor_row"`
+	CursorCol     int    `json:"cursor_col"`
+	CursorVisible bool   `json:"cursor_visible"`
+	AltScreen     bool   `json:"alt_screen"`
+	ANSI          string `json:"ansi"` // Escape sequences that repaint the screen
+}
+
+// NewVirtualScreen creates a blank screen of the given size
+func NewVirtualScreen(cols, rows int) *VirtualScreen {
+	s := &VirtualScreen{}
+	s.reset(cols, rows)
+	return s
+}
+
+// reset restores the power-on state at the given size (also used by ESC c)
+func (s *VirtualScreen) reset(cols, rows int) {
+	s.cols, s.rows = cols, rows
+	s.mainGrid = newScreenGrid(cols, rows, defaultStyle)
+	s.altGrid = newScreenGrid(cols, rows, defaultStyle)
+	s.grid = s.mainGrid
+	s.altActive = false
+	s.curX, s.curY = 0, 0
+	s.wrapNext = false
+	s.style = defaultStyle
+	s.cursorVisible = true
+	s.autoWrap = true
+	s.scrollTop, s.scrollBottom = 0, rows-1
+	s.saved = savedCursor{style: defaultStyle}
+	s.altSaved = savedCursor{style: defaultStyle}
+}
+
+func newScreenGrid(cols, rows int, style cellStyle) [][]screenCell {
+	grid := make([][]screenCell, rows)
+	for y := range grid {
+		grid[y] = newScreenRow(cols, style)
+	}
+	return grid
+}
+
+func newScreenRow(cols int, style cellStyle) []screenCell {
+	row := make([]screenCell, cols)
+	for x := range row {
+		row[x] = screenCell{ch: ' ', style: style}
+	}
+	return row
+}
+
+// blankStyle is the style used for erased cells (keeps the current background)
+func (s *VirtualScreen) blankStyle() cellStyle {
+	return cellStyle{fg: colorDefault, bg: s.style.bg}
+}
+
+// Resize changes the screen size, keeping the cursor line visible
+func (s *VirtualScreen) Resize(cols, rows int) {
+	if cols <= 0 || rows <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cols == s.cols && rows == s.rows {
+		return
+	}
+
+	// When shrinking, drop lines from the top so the cursor stays on screen
+	shift := 0
+	if s.curY >= rows {
+		shift = s.curY - rows + 1
+	}
+
+	resize := func(old [][]screenCell, shift int) [][]screenCell {
+		grid := newScreenGrid(cols, rows, defaultStyle)
+		for y := 0; y < rows && y+shift < len(old); y++ {
+			copy(grid[y], old[y+shift])
+		}
+		return grid
+	}
+
+	if s.altActive {
+		s.altGrid = resize(s.altGrid, shift)
+		s.mainGrid = resize(s.mainGrid, 0)
+		s.grid = s.altGrid
+	} else {
+		s.mainGrid = resize(s.mainGrid, shift)
+		s.altGrid = resize(s.altGrid, 0)
+		s.grid = s.mainGrid
+	}
+
+	s.cols, s.rows = cols, rows
+	s.curY -= shift
+	s.clampCursor()
+	s.wrapNext = false
+	s.scrollTop, s.scrollBottom = 0, rows-1
+}
+
+// Write feeds PTY output into the screen model
+func (s *VirtualScreen) Write(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range data {
+		s.feed(b)
+	}
+}
+
+// feed processes a single byte of terminal output
+func (s *VirtualScreen) feed(b byte) {
+	switch s.state {
+	case vtGround:
+		if len(s.utf8Buf) > 0 || b >= 0x80 {
+			if b < 0x80 {
+				// Truncated UTF-8 sequence
+				s.utf8Buf = s.utf8Buf[:0]
+				s.putRune(utf8.RuneError)
+			} else {
+				s.utf8Buf = append(s.utf8Buf, b)
+				if utf8.FullRune(s.utf8Buf) {
+					r, _ := utf8.DecodeRune(s.utf8Buf)
+					s.utf8Buf = s.utf8Buf[:0]
+					s.putRune(r)
+				}
+				return
+			}
+		}
+		switch {
+		case b == 0x1b:
+			s.state = vtEscape
+		case b < 0x20:
+			s.control(b)
+		case b == 0x7f:
+			// DEL is ignored
+		default:
+			s.putRune(rune(b))
+		}
+
+	case vtEscape:
+		s.state = vtGround
+		switch b {
+		case '[':
+			s.params = s.params[:0]
+			s.state = vtCSI
+		case ']', 'P', '_', '^', 'X':
+			s.state = vtString
+		case '(', ')', '*', '+', '#':
+			s.state = vtCharset
+		case '7':
+			s.saved = savedCursor{x: s.curX, y: s.curY, style: s.style}
+		case '8':
+			s.restoreCursor(s.saved)
+		case 'D':
+			s.lineFeed()
+		case 'E':
+			s.curX = 0
+			s.lineFeed()
+		case 'M':
+			s.reverseIndex()
+		case 'c':
+			s.reset(s.cols, s.rows)
+		}
+
+	case vtCharset:
+		// Character set designation — only line drawing matters and we keep ASCII
+		s.state = vtGround
+
+	case vtCSI:
+		switch {
+		case b >= 0x40 && b <= 0x7e:
+			s.state = vtGround
+			s.csi(b)
+		case b >= 0x20 && b <= 0x3f:
+			s.params = append(s.params, b)
+		case b == 0x1b:
+			s.state = vtEscape
+		case b < 0x20:
+			// C0 controls are executed even inside a CSI sequence
+			s.control(b)
+		}
+
+	case vtString:
+		if b == 0x07 {
+			s.state = vtGround
+		} else if b == 0x1b {
+			s.state = vtStrEsc
+		}
+
+	case vtStrEsc:
+		if b == '\\' {
+			s.state = vtGround
+		} else {
+			// Not ST — treat as a new escape sequence
+			s.state = vtEscape
+			s.feed(b)
+		}
+	}
+}
+
+// control handles C0 control characters
+func (s *VirtualScreen) control(b byte) {
+	switch b {
+	case 0x08: // BS
+		if s.curX > 0 {
+			s.curX--
+		}
+		s.wrapNext = false
+	case 0x09: // HT — tab stops every 8 columns
+		s.curX = (s.curX/8 + 1) * 8
+		if s.curX >= s.cols {
+			s.curX = s.cols - 1
+		}
+	case 0x0a, 0x0b, 0x0c: // LF, VT, FF
+		s.lineFeed()
+	case 0x0d: // CR
+		s.curX = 0
+		s.wrapNext = false
+	}
+}
+
+// putRune writes a printable character at the cursor
+func (s *VirtualScreen) putRune(r rune) {
+	width := runeWidth(r)
+	if width == 0 {
+		// Combining marks are not tracked
+		return
+	}
+
+	if s.wrapNext && s.autoWrap {
+		s.curX = 0
+		s.lineFeed()
+	}
+	s.wrapNext = false
+
+	// A wide character that doesn't fit on the line wraps as a whole
+	if width == 2 && s.curX == s.cols-1 {
+		if !s.autoWrap {
+			return
+		}
+		s.grid[s.curY][s.curX] = screenCell{ch: ' ', style: s.style}
+		s.curX = 0
+		s.lineFeed()
+	}
+
+	s.grid[s.curY][s.curX] = screenCell{ch: r, style: s.style}
+	if width == 2 && s.curX+1 < s.cols {
+		s.grid[s.curY][s.curX+1] = screenCell{ch: 0, style: s.style}
+	}
+
+	s.curX += width
+	if s.curX >= s.cols {
+		s.curX = s.cols - 1
+		s.wrapNext = true
+	}
+}
+
+// lineFeed moves the cursor down, scrolling the region at the bottom margin
+func (s *VirtualScreen) lineFeed() {
+	s.wrapNext = false
+	if s.curY == s.scrollBottom {
+		s.scrollUp(1)
+	} else if s.curY < s.rows-1 {
+		s.curY++
+	}
+}
+
+// reverseIndex moves the cursor up, scrolling the region at the top margin
+func (s *VirtualScreen) reverseIndex() {
+	s.wrapNext = false
+	if s.curY == s.scrollTop {
+		s.scrollDown(1)
+	} else if s.curY > 0 {
+		s.curY--
+	}
+}
+
+// scrollUp scrolls the scroll region up by n lines
+func (s *VirtualScreen) scrollUp(n int) {
+	s.deleteLinesAt(s.scrollTop, n)
+}
+
+// scrollDown scrolls the scroll region down by n lines
+func (s *VirtualScreen) scrollDown(n int) {
+	s.insertLinesAt(s.scrollTop, n)
+}
+
+// deleteLinesAt removes n lines at row y, pulling up lines below it within the scroll region
+func (s *VirtualScreen) deleteLinesAt(y, n int) {
+	bottom := s.scrollBottom
+	if n > bottom-y+1 {
+		n = bottom - y + 1
+	}
+	for i := 0; i < n; i++ {
+		copy(s.grid[y:bottom], s.grid[y+1:bottom+1])
+		s.grid[bottom] = newScreenRow(s.cols, s.blankStyle())
+	}
+}
+
+// insertLinesAt inserts n blank lines at row y, pushing lines below it down within the scroll region
+func (s *VirtualScreen) insertLinesAt(y, n int) {
+	bottom := s.scrollBottom
+	if n > bottom-y+1 {
+		n = bottom - y + 1
+	}
+	for i := 0; i < n; i++ {
+		copy(s.grid[y+1:bottom+1], s.grid[y:bottom])
+		s.grid[y] = newScreenRow(s.cols, s.blankStyle())
+	}
+}
+
+// eraseCells blanks cells [from, to) on row y
+func (s *VirtualScreen) eraseCells(y, from, to int) {
+	if from < 0 {
+		from = 0
+	}
+	if to > s.cols {
+		to = s.cols
+	}
+	blank := screenCell{ch: ' ', style: s.blankStyle()}
+	for x := from; x < to; x++ {
+		s.grid[y][x] = blank
+	}
+}
+
+func (s *VirtualScreen) clampCursor() {
+	if s.curX < 0 {
+		s.curX = 0
+	}
+	if s.curX >= s.cols {
+		s.curX = s.cols - 1
+	}
+	if s.curY < 0 {
+		s.curY = 0
+	}
+	if s.curY >= s.rows {
+		s.curY = s.rows - 1
+	}
+}
+
+func (s *VirtualScreen) moveCursor(x, y int) {
+	s.curX, s.curY = x, y
+	s.wrapNext = false
+	s.clampCursor()
+}
+
+func (s *VirtualScreen) restoreCursor(c savedCursor) {
+	s.style = c.style
+	s.moveCursor(c.x, c.y)
+}
+
+// csi executes a complete CSI sequence with the given final byte
+func (s *VirtualScreen) csi(final byte) {
+	raw := string(s.params)
+
+	// Private marker (e.g. ?25h) and intermediates (e.g. SP q)
+	private := byte(0)
+	if len(raw) > 0 && strings.IndexByte("?<=>", raw[0]) >= 0 {
+		private = raw[0]
+		raw = raw[1:]
+	}
+	if i := strings.IndexFunc(raw, func(r rune) bool { return r >= 0x20 && r <= 0x2f }); i >= 0 {
+		// Sequences with intermediates (cursor style, soft reset...) are not tracked
+		return
+	}
+
+	var params []int
+	if raw != "" {
+		for _, p := range strings.FieldsFunc(raw, func(r rune) bool { return r == ';' || r == ':' }) {
+			n, _ := strconv.Atoi(p)
+			params = append(params, n)
+		}
+	}
+	// param returns the i-th parameter, or def when missing or zero
+	param := func(i, def int) int {
+		if i < len(params) && params[i] > 0 {
+			return params[i]
+		}
+		return def
+	}
+
+	if private == '?' {
+		if final == 'h' || final == 'l' {
+			for _, mode := range params {
+				s.setPrivateMode(mode, final == 'h')
+			}
+		}
+		return
+	}
+	if private != 0 {
+		return
+	}
+
+	switch final {
+	case 'A': // CUU
+		s.moveCursor(s.curX, s.curY-param(0, 1))
+	case 'B', 'e': // CUD, VPR
+		s.moveCursor(s.curX, s.curY+param(0, 1))
+	case 'C', 'a': // CUF, HPR
+		s.moveCursor(s.curX+param(0, 1), s.curY)
+	case 'D': // CUB
+		s.moveCursor(s.curX-param(0, 1), s.curY)
+	case 'E': // CNL
+		s.moveCursor(0, s.curY+param(0, 1))
+	case 'F': // CPL
+		s.moveCursor(0, s.curY-param(0, 1))
+	case 'G', '`': // CHA, HPA
+		s.moveCursor(param(0, 1)-1, s.curY)
+	case 'H', 'f': // CUP, HVP
+		s.moveCursor(param(1, 1)-1, param(0, 1)-1)
+	case 'd': // VPA
+		s.moveCursor(s.curX, param(0, 1)-1)
+
+	case 'J': // ED
+		switch param(0, 0) {
+		case 0:
+			s.eraseCells(s.curY, s.curX, s.cols)
+			for y := s.curY + 1; y < s.rows; y++ {
+				s.eraseCells(y, 0, s.cols)
+			}
+		case 1:
+			for y := 0; y < s.curY; y++ {
+				s.eraseCells(y, 0, s.cols)
+			}
+			s.eraseCells(s.curY, 0, s.curX+1)
+		case 2, 3:
+			for y := 0; y < s.rows; y++ {
+				s.eraseCells(y, 0, s.cols)
+			}
+		}
+	case 'K': // EL
+		switch param(0, 0) {
+		case 0:
+			s.eraseCells(s.curY, s.curX, s.cols)
+		case 1:
+			s.eraseCells(s.curY, 0, s.curX+1)
+		case 2:
+			s.eraseCells(s.curY, 0, s.cols)
+		}
+	case 'X': // ECH
+		s.eraseCells(s.curY, s.curX, s.curX+param(0, 1))
+
+	case '@': // ICH
+		n := param(0, 1)
+		row := s.grid[s.curY]
+		if n > s.cols-s.curX {
+			n = s.cols - s.curX
+		}
+		copy(row[s.curX+n:], row[s.curX:s.cols-n])
+		s.eraseCells(s.curY, s.curX, s.curX+n)
+	case 'P': // DCH
+		n := param(0, 1)
+		row := s.grid[s.curY]
+		if n > s.cols-s.curX {
+			n = s.cols - s.curX
+		}
+		copy(row[s.curX:], row[s.curX+n:])
+		s.eraseCells(s.curY, s.cols-n, s.cols)
+
+	case 'L': // IL
+		if s.curY >= s.scrollTop && s.curY <= s.scrollBottom {
+			s.insertLinesAt(s.curY, param(0, 1))
+			s.curX = 0
+		}
+	case 'M': // DL
+		if s.curY >= s.scrollTop && s.curY <= s.scrollBottom {
+			s.deleteLinesAt(s.curY, param(0, 1))
+			s.curX = 0
+		}
+	case 'S': // SU
+		s.scrollUp(param(0, 1))
+	case 'T': // SD
+		if len(params) <= 1 {
+			s.scrollDown(param(0, 1))
+		}
+
+	case 'r': // DECSTBM
+		top := param(0, 1) - 1
+		bottom := param(1, s.rows) - 1
+		if bottom >= s.rows {
+			bottom = s.rows - 1
+		}
+		if top < bottom {
+			s.scrollTop, s.scrollBottom = top, bottom
+			s.moveCursor(0, 0)
+		}
+
+	case 'm': // SGR
+		s.sgr(params)
+
+	case 's': // SCOSC
+		s.saved = savedCursor{x: s.curX, y: s.curY, style: s.style}
+	case 'u': // SCORC
+		s.restoreCursor(s.saved)
+	}
+}
+
+// setPrivateMode handles DEC private modes (CSI ? n h / l)
+func (s *VirtualScreen) setPrivateMode(mode int, on bool) {
+	switch mode {
+	case 7:
+		s.autoWrap = on
+	case 25:
+		s.cursorVisible = on
+	case 47, 1047, 1049:
+		if on == s.altActive {
+			return
+		}
+		if on {
+			if mode == 1049 {
+				s.altSaved = savedCursor{x: s.curX, y: s.curY, style: s.style}
+			}
+			if mode != 47 {
+				s.altGrid = newScreenGrid(s.cols, s.rows, defaultStyle)
+			}
+			s.grid = s.altGrid
+		} else {
+			s.grid = s.mainGrid
+			if mode == 1049 {
+				s.restoreCursor(s.altSaved)
+			}
+		}
+		s.altActive = on
+	}
+}
+
+// sgr applies Select Graphic Rendition parameters to the current style
+func (s *VirtualScreen) sgr(params []int) {
+	if len(params) == 0 {
+		params = []int{0}
+	}
+	for i := 0; i < len(params); i++ {
+		p := params[i]
+		switch {
+		case p == 0:
+			s.style = defaultStyle
+		case p >= 1 && p <= 9 && p != 6:
+			s.style.attrs |= sgrAttr(p)
+		case p == 21 || p == 22:
+			s.style.attrs &^= attrBold | attrDim
+		case p >= 23 && p <= 29 && p != 26:
+			s.style.attrs &^= sgrAttr(p - 20)
+		case p >= 30 && p <= 37:
+			s.style.fg = int32(p - 30)
+		case p == 39:
+			s.style.fg = colorDefault
+		case p >= 40 && p <= 47:
+			s.style.bg = int32(p - 40)
+		case p == 49:
+			s.style.bg = colorDefault
+		case p >= 90 && p <= 97:
+			s.style.fg = int32(p - 90 + 8)
+		case p >= 100 && p <= 107:
+			s.style.bg = int32(p - 100 + 8)
+		case p == 38 || p == 48:
+			color, consumed := parseExtendedColor(params[i+1:])
+			i += consumed
+			if consumed == 0 {
+				continue
+			}
+			if p == 38 {
+				s.style.fg = color
+			} else {
+				s.style.bg = color
+			}
+		}
+	}
+}
+
+// sgrAttr maps an SGR attribute number (1-9) to its flag
+func sgrAttr(p int) uint8 {
+	switch p {
+	case 1:
+		return attrBold
+	case 2:
+		return attrDim
+	case 3:
+		return attrItalic
+	case 4:
+		return attrUnderline
+	case 5:
+		return attrBlink
+	case 7:
+		return attrReverse
+	case 8:
+		return attrHidden
+	case 9:
+		return attrStrike
+	}
+	return 0
+}
+
+// parseExtendedColor parses "5;n" or "2;r;g;b" following SGR 38/48.
+// Returns the color and the number of parameters consumed.
+func parseExtendedColor(params []int) (int32, int) {
+	if len(params) >= 2 && params[0] == 5 {
+		return int32(params[1] & 0xff), 2
+	}
+	if len(params) >= 4 && params[0] == 2 {
+		rgb := (params[1]&0xff)<<16 | (params[2]&0xff)<<8 | params[3]&0xff
+		return int32(colorRGB | rgb), 4
+	}
+	return colorDefault, 0
+}
+
+// Snapshot returns the current screen state and the escape sequences that repaint it
+func (s *VirtualScreen) Snapshot() ScreenSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sb strings.Builder
+	if s.altActive {
+		sb.WriteString(altScreenOn)
+	} else {
+		sb.WriteString(altScreenOff)
+	}
+	sb.WriteString("\033[0m" + cursorHome + clearScreen)
+
+	current := defaultStyle
+	for y, row := range s.grid {
+		// Trim trailing blank cells
+		end := len(row)
+		for end > 0 && row[end-1].ch == ' ' && row[end-1].style == defaultStyle {
+			end--
+		}
+		if end == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "\033[%d;1H", y+1)
+		for _, cell := range row[:end] {
+			if cell.ch == 0 {
+				continue // Right half of a wide character
+			}
+			if cell.style != current {
+				sb.WriteString(sgrSequence(cell.style))
+				current = cell.style
+			}
+			sb.WriteRune(cell.ch)
+		}
+	}
+
+	// Restore terminal modes, pen and cursor
+	sb.WriteString(sgrSequence(s.style))
+	if s.scrollTop != 0 || s.scrollBottom != s.rows-1 {
+		fmt.Fprintf(&sb, "\033[%d;%dr", s.scrollTop+1, s.scrollBottom+1)
+	}
+	if !s.autoWrap {
+		sb.WriteString("\033[?7l")
+	}
+	fmt.Fprintf(&sb, "\033[%d;%dH", s.curY+1, s.curX+1)
+	if s.cursorVisible {
+		sb.WriteString(showCursor)
+	} else {
+		sb.WriteString(hideCursor)
+	}
+
+	return ScreenSnapshot{
+		Cols:          s.cols,
+		Rows:          s.rows,
+		CursorRow:     s.curY,
+		CursorCol:     s.curX,
+		CursorVisible: s.cursorVisible,
+		AltScreen:     s.altActive,
+		ANSI:          sb.String(),
+	}
+}
+
+// Text returns the visible screen as plain text, one line per row (trailing spaces trimmed)
+func (s *VirtualScreen) Text() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines := make([]string, len(s.grid))
+	for y, row := range s.grid {
+		var sb strings.Builder
+		for _, cell := range row {
+			if cell.ch != 0 {
+				sb.WriteRune(cell.ch)
+			}
+		}
+		lines[y] = strings.TrimRight(sb.String(), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sgrSequence builds a full SGR sequence (starting with reset) for a style
+func sgrSequence(style cellStyle) string {
+	parts := []string{"0"}
+	for _, p := range []int{1, 2, 3, 4, 5, 7, 8, 9} {
+		if style.attrs&sgrAttr(p) != 0 {
+			parts = append(parts, strconv.Itoa(p))
+		}
+	}
+	if c := sgrColor(style.fg, 30, 90, 38); c != "" {
+		parts = append(parts, c)
+	}
+	if c := sgrColor(style.bg, 40, 100, 48); c != "" {
+		parts = append(parts, c)
+	}
+	return "\033[" + strings.Join(parts, ";") + "m"
+}
+
+// sgrColor encodes a color as SGR parameters for the given base codes
+func sgrColor(color int32, base, brightBase, extended int) string {
+	switch {
+	case color == colorDefault:
+		return ""
+	case color&colorRGB != 0:
+		rgb := int(color &^ colorRGB)
+		return fmt.Sprintf("%d;2;%d;%d;%d", extended, rgb>>16&0xff, rgb>>8&0xff, rgb&0xff)
+	case color < 8:
+		return strconv.Itoa(base + int(color))
+	case color < 16:
+		return strconv.Itoa(brightBase + int(color) - 8)
+	default:
+		return fmt.Sprintf("%d;5;%d", extended, color)
+	}
+}
+
+// runeWidth returns the number of cells a rune occupies (0 for combining marks)
+func runeWidth(r rune) int {
+	switch {
+	case r >= 0x0300 && r <= 0x036f, // Combining diacritics
+		r >= 0x200b && r <= 0x200f, // Zero-width space, joiners, marks
+		r >= 0x20d0 && r <= 0x20ff, // Combining marks for symbols
+		r >= 0xfe00 && r <= 0xfe0f: // Variation selectors
+		return 0
+	case r >= 0x1100 && r <= 0x115f, // Hangul Jamo
+		r >= 0x2e80 && r <= 0x303e, // CJK radicals, punctuation
+		r >= 0x3041 && r <= 0x33ff, // Kana, CJK symbols
+		r >= 0x3400 && r <= 0x4dbf, // CJK extension A
+		r >= 0x4e00 && r <= 0x9fff, // CJK unified ideographs
+		r >= 0xa000 && r <= 0xa4cf, // Yi
+		r >= 0xac00 && r <= 0xd7a3, // Hangul syllables
+		r >= 0xf900 && r <= 0xfaff, // CJK compatibility ideographs
+		r >= 0xfe30 && r <= 0xfe4f, // CJK compatibility forms
+		r >= 0xff00 && r <= 0xff60, // Fullwidth forms
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f, // Emoji
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x20000 && r <= 0x3fffd: // CJK extensions B+
+		return 2
+	}
+	return 1
+}
+
+// sendScreenSnapshot sends the current screen to mobile as a screen-snapshot control message
+func (d *Daemon) sendScreenSnapshot(reqID string) {
+	if d.screen == nil {
+		return
+	}
+
+	// Hold the output lock so no live output slips between snapshot and send
+	d.outputMu.Lock()
+	defer d.outputMu.Unlock()
+
+	d.sendControl("screen-snapshot", reqID, d.screen.Snapshot())
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVirtualScreen_TextAndCursor(t *testing.T) {
+	s := NewVirtualScreen(10, 3)
+	s.Write([]byte("hello\r\nworld"))
+
+	if got := s.Text(); got != "hello\nworld\n" {
+		t.Fatalf("unexpected text: %q", got)
+	}
+	snap := s.Snapshot()
+	if snap.CursorRow != 1 || snap.CursorCol != 5 {
+		t.Fatalf("expected cursor at 1,5, got %d,%d", snap.CursorRow, snap.CursorCol)
+	}
+}
+
+func TestVirtualScreen_WrapAndScroll(t *testing.T) {
+	s := NewVirtualScreen(4, 2)
+	s.Write([]byte("abcdefgh\r\nij"))
+
+	if got := s.Text(); got != "efgh\nij" {
+		t.Fatalf("unexpected text after wrap/scroll: %q", got)
+	}
+}
+
+func TestVirtualScreen_CursorMovementAndErase(t *testing.T) {
+	s := NewVirtualScreen(10, 3)
+	s.Write([]byte("1234567890\033[2;3Hxy\033[1;5H\033[K"))
+
+	if got := s.Text(); got != "1234\n  xy\n" {
+		t.Fatalf("unexpected text: %q", got)
+	}
+}
+
+func TestVirtualScreen_AltScreen(t *testing.T) {
+	s := NewVirtualScreen(10, 2)
+	s.Write([]byte("main"))
+	s.Write([]byte("\033[?1049h\033[Hpopup"))
+
+	snap := s.Snapshot()
+	if !snap.AltScreen {
+		t.Fatal("expected alt screen to be active")
+	}
+	if got := s.Text(); got != "popup\n" {
+		t.Fatalf("unexpected alt screen text: %q", got)
+	}
+
+	s.Write([]byte("\033[?1049l"))
+	if got := s.Text(); got != "main\n" {
+		t.Fatalf("main screen not restored: %q", got)
+	}
+	if snap := s.Snapshot(); snap.CursorCol != 4 {
+		t.Fatalf("expected cursor restored to col 4, got %d", snap.CursorCol)
+	}
+}
+
+func TestVirtualScreen_SplitUTF8AndOSC(t *testing.T) {
+	s := NewVirtualScreen(10, 1)
+	s.Write([]byte("\033]0;title\007\xc3"))
+	s.Write([]byte("\xa9t中!"))
+
+	if got := s.Text(); got != "ét中!" {
+		t.Fatalf("unexpected text: %q", got)
+	}
+	if snap := s.Snapshot(); snap.CursorCol != 5 {
+		t.Fatalf("expected wide char to take 2 cells, cursor at %d", snap.CursorCol)
+	}
+}
+
+func TestVirtualScreen_SnapshotColors(t *testing.T) {
+	s := NewVirtualScreen(10, 1)
+	s.Write([]byte("\033[1;31mR\033[38;2;1;2;3mX\033[0m"))
+
+	ansi := s.Snapshot().ANSI
+	if !strings.Contains(ansi, "\033[0;1;31mR") {
+		t.Fatalf("expected bold red cell in snapshot: %q", ansi)
+	}
+	if !strings.Contains(ansi, "\033[0;1;38;2;1;2;3mX") {
+		t.Fatalf("expected truecolor cell in snapshot: %q", ansi)
+	}
+}
+
+func TestVirtualScreen_Resize(t *testing.T) {
+	s := NewVirtualScreen(10, 3)
+	s.Write([]byte("a\r\nb\r\nc"))
+	s.Resize(5, 2)
+
+	if got := s.Text(); got != "b\nc" {
+		t.Fatalf("expected cursor line kept after shrink, got %q", got)
+	}
+}
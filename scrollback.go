@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sync"
+)
+
+// ScrollbackBuffer is a bounded ring buffer holding the most recent PTY output.
+// It lets a reconnecting mobile catch up on output it missed while offline.
+type ScrollbackBuffer struct {
+	mu   sync.Mutex
+	buf  []byte
+	size int
+	pos  int  // Next write position
+	full bool // True once the buffer has wrapped at least once
+}
+
+// NewScrollbackBuffer creates a ring buffer holding at most size bytes
+func NewScrollbackBuffer(size int) *ScrollbackBuffer {
+	return &ScrollbackBuffer{
+		buf:  make([]byte, size),
+		size: size,
+	}
+}
+
+// Write appends data to the buffer, overwriting the oldest bytes when full
+func (s *ScrollbackBuffer) Write(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only the tail of an oversized write can fit
+	if len(data) >= s.size {
+		copy(s.buf, data[len(data)-s.size:])
+		s.pos = 0
+		s.full = true
+		return
+	}
+
+	n := copy(s.buf[s.pos:], data)
+	if n < len(data) {
+		// Wrap around
+		copy(s.buf, data[n:])
+		s.full = true
+	}
+	s.pos = (s.pos + len(data)) % s.size
+	if s.pos == 0 && len(data) > 0 {
+		s.full = true
+	}
+}
+
+// Bytes returns a copy of the buffered output, oldest first
+func (s *ScrollbackBuffer) Bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.full {
+		out := make([]byte, s.pos)
+		copy(out, s.buf[:s.pos])
+		return out
+	}
+
+	out := make([]byte, 0, s.size)
+	out = append(out, s.buf[s.pos:]...)
+	out = append(out, s.buf[:s.pos]...)
+	return out
+}
+
+// Len returns the number of buffered bytes
+func (s *ScrollbackBuffer) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.full {
+		return s.size
+	}
+	return s.pos
+}
+
+// sendReplay streams the scrollback buffer to mobile, framed by control messages:
+// replay-start:<bytes>, then encrypted data frames, then replay-end.
+func (d *Daemon) sendReplay(reqID string) {
+	d.outputMu.Lock()
+	defer d.outputMu.Unlock()
+
+	var history []byte
+	if d.scrollback != nil {
+		history = d.scrollback.Bytes()
+	}
+
+	d.sendControl("replay-start", reqID, replayStartPayload{Bytes: len(history)})
+	for start := 0; start < len(history); start += BufferSize {
+		end := start + BufferSize
+		if end > len(history) {
+			end = len(history)
+		}
+		d.sendToMobile(history[start:end])
+	}
+	d.sendControl("replay-end", reqID, nil)
+}
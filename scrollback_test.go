@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestScrollbackBuffer_BeforeWrap(t *testing.T) {
+	sb := NewScrollbackBuffer(8)
+	sb.Write([]byte("abc"))
+	sb.Write([]byte("de"))
+	if got := string(sb.Bytes()); got != "abcde" {
+		t.Fatalf("expected abcde, got %q", got)
+	}
+	if sb.Len() != 5 {
+		t.Fatalf("expected len 5, got %d", sb.Len())
+	}
+}
+
+func TestScrollbackBuffer_Wrap(t *testing.T) {
+	sb := NewScrollbackBuffer(8)
+	sb.Write([]byte("abcdef"))
+	sb.Write([]byte("ghijk"))
+	if got := string(sb.Bytes()); got != "defghijk" {
+		t.Fatalf("expected defghijk, got %q", got)
+	}
+}
+
+func TestScrollbackBuffer_ExactFill(t *testing.T) {
+	sb := NewScrollbackBuffer(4)
+	sb.Write([]byte("abcd"))
+	sb.Write([]byte("e"))
+	if got := string(sb.Bytes()); got != "bcde" {
+		t.Fatalf("expected bcde, got %q", got)
+	}
+}
+
+func TestScrollbackBuffer_OversizedWrite(t *testing.T) {
+	sb := NewScrollbackBuffer(4)
+	sb.Write([]byte("xy"))
+	sb.Write([]byte("0123456789"))
+	if got := string(sb.Bytes()); got != "6789" {
+		t.Fatalf("expected 6789, got %q", got)
+	}
+	sb.Write([]byte("z"))
+	if got := string(sb.Bytes()); got != "789z" {
+		t.Fatalf("expected 789z, got %q", got)
+	}
+}
@@ -28,10 +28,20 @@ func listSessions(relayClient *RelayClient) {
 		return
 	}
 
-	// Group by working directory
+	// Sessions run by the same multi-session process are shown together,
+	// the others are grouped by working directory
+	processes := make(map[string][]SessionInfo)
+	var processOrder []string
 	groups := make(map[string][]SessionInfo)
 	var order []string
 	for _, s := range sessions {
+		if s.GroupID != "" {
+			if _, exists := processes[s.GroupID]; !exists {
+				processOrder = append(processOrder, s.GroupID)
+			}
+			processes[s.GroupID] = append(processes[s.GroupID], s)
+			continue
+		}
 		if _, exists := groups[s.WorkingDir]; !exists {
 			order = append(order, s.WorkingDir)
 		}
@@ -39,6 +49,16 @@ func listSessions(relayClient *RelayClient) {
 	}
 
 	fmt.Printf("%sSessions:%s\n\n", bold, reset)
+	for _, id := range processOrder {
+		groupID := id
+		if len(groupID) > 8 {
+			groupID = groupID[:8]
+		}
+		fmt.Printf("  %sProcess %s%s %s(%d sessions)%s\n", cyan, groupID, reset, dim, len(processes[id]), reset)
+		for _, s := range processes[id] {
+			fmt.Printf("    %s  %s  %s  %s\n", s.ID, s.AgentType, s.WorkingDir, s.CreatedAt)
+		}
+	}
 	for _, wd := range order {
 		fmt.Printf("  %s%s%s\n", cyan, wd, reset)
 		for _, s := range groups[wd] {
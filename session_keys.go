@@ -0,0 +1,524 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Per-mobile session keys.
+// Instead of the key shared by every paired mobile (sha256 of the session
+// token), a mobile runs an authenticated key exchange when it connects:
+//
+//   mobile -> CLI: data "kx:{"mobile_id":"<id>","ephemeral":"<hex E_m>"}"
+//   CLI -> mobile: data "kx:{"ephemeral":"<hex E_c>","confirm":"<hex mac>"}"
+//
+// Both sides mix DH(s_pc, E_m), DH(e_c, S_m) and DH(e_c, E_m) with HKDF:
+// only the paired mobile (S_m) talking to this PC (s_pc) gets the keys, and
+// the ephemeral keys give forward secrecy. Every direction has its own key.
+// The CLI asks for a new exchange every RekeyInterval and re-checks the
+// pairing first: an unpaired mobile loses its keys and is cut off.
+// Anyone can send "kx:" (the answer is useless without the mobile's private
+// key), so while a mobile has keys, new keys only replace them once a frame
+// sealed with them arrives.
+// Frames sent with session keys are strict (see encryption.go). Mobiles that
+// never send "kx:" keep using the legacy token key, unless strict mode is on:
+// once a paired mobile proved session keys, the legacy key is refused.
+//
+// Unpairing a mobile also rotates the session token, which the mobile may
+// know: the session is recreated on the relay and its new token is only
+// shared with the mobiles still paired.
+
+// keyExchangePrefix marks key exchange frames in data payloads (never valid base64)
+const keyExchangePrefix = "kx:"
+
+// sessionKeyContext separates session keys from other uses of the same key material
+const sessionKeyContext = "aipilot-session-v1"
+
+// keyConfirmLabel is the message MACed with the confirmation key
+const keyConfirmLabel = "aipilot-kx-confirm"
+
+// errMobileRevoked is returned when the mobile of this connection is no longer paired
+var errMobileRevoked = errors.New("mobile is no longer paired")
+
+// keyExchangeRequest is the mobile's key exchange frame
+type keyExchangeRequest struct {
+	MobileID  string `json:"mobile_id"`
+	Ephemeral string `json:"ephemeral"`
+}
+
+// keyExchangeResponse is the CLI's answer to a key exchange
+type keyExchangeResponse struct {
+	Ephemeral string `json:"ephemeral,omitempty"`
+	Confirm   string `json:"confirm,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// mobileKeys holds the keys negotiated with the connected mobile
+type mobileKeys struct {
+	mobileID     string
+	send         *frameKey // CLI -> mobile
+	recv         *frameKey // mobile -> CLI
+	previousRecv *frameKey // Accepted after a rekey for frames already in flight
+	proven       bool      // A frame sealed with these keys was received
+	established  time.Time
+	rekeyTimer   *time.Timer
+}
+
+// keySlot holds the session keys of one transport, the relay or the LAN
+type keySlot struct {
+	keys    *mobileKeys // nil: token key
+	pending *mobileKeys // Answered exchange, installed once a frame proves it
+	revoked bool        // The mobile was unpaired or kicked: nothing is sent or accepted
+}
+
+// activeKeySlotLocked returns the key slot of the transport that reaches the
+// mobile (see mobileConn). Must be called with d.mu held.
+func (d *Daemon) activeKeySlotLocked() *keySlot {
+	if d.lanConn != nil {
+		return &d.lanKeys
+	}
+	return &d.relayKeys
+}
+
+// sessionKeyMaterial is the output of the key derivation
+type sessionKeyMaterial struct {
+	mobileToCLI []byte
+	cliToMobile []byte
+	confirm     []byte
+}
+
+// isKeyExchange reports whether a data payload is a key exchange frame
+func isKeyExchange(payload string) bool {
+	return strings.HasPrefix(payload, keyExchangePrefix)
+}
+
+// deriveSessionKeys derives the directional keys from the three DH results.
+// The transcript (session, mobile ID, both ephemeral keys) binds the keys to this exchange.
+func deriveSessionKeys(token, session, mobileID string, mobileEphemeral, cliEphemeral []byte, dh ...[]byte) (*sessionKeyMaterial, error) {
+	var secret []byte
+	for _, shared := range dh {
+		secret = append(secret, shared...)
+	}
+	info := strings.Join([]string{
+		sessionKeyContext, session, mobileID,
+		hex.EncodeToString(mobileEphemeral), hex.EncodeToString(cliEphemeral),
+	}, "|")
+
+	reader := hkdf.New(sha256.New, secret, []byte(token), []byte(info))
+	keys := &sessionKeyMaterial{
+		mobileToCLI: make([]byte, 32),
+		cliToMobile: make([]byte, 32),
+		confirm:     make([]byte, 32),
+	}
+	for _, key := range [][]byte{keys.mobileToCLI, keys.cliToMobile, keys.confirm} {
+		if _, err := io.ReadFull(reader, key); err != nil {
+			return nil, fmt.Errorf("failed to derive session keys: %w", err)
+		}
+	}
+	return keys, nil
+}
+
+// keyConfirmation proves to the mobile that the CLI derived the same keys
+func keyConfirmation(confirmKey []byte) []byte {
+	mac := hmac.New(sha256.New, confirmKey)
+	mac.Write([]byte(keyConfirmLabel))
+	return mac.Sum(nil)
+}
+
+// newGCM creates an AES-256-GCM cipher from a 32-byte key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// decodeX25519Key decodes a hex X25519 public key
+func decodeX25519Key(hexKey string) ([]byte, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) != X25519PublicKeySize {
+		return nil, fmt.Errorf("invalid X25519 key")
+	}
+	return key, nil
+}
+
+// currentPairedMobile looks a mobile up in the pairing config on disk, so that an
+// unpair done by another aipilot-cli process is seen by running sessions
+func (d *Daemon) currentPairedMobile(mobileID string) *PairedMobile {
+	config := d.currentPCConfig()
+	if config == nil {
+		return nil
+	}
+	return config.getPairedMobile(mobileID)
+}
+
+// currentPCConfig returns a copy of the pairing config on disk, or the one
+// loaded at startup if it can't be read
+func (d *Daemon) currentPCConfig() *PCConfig {
+	config, err := loadPCConfigCached()
+	if err != nil || config == nil {
+		return d.pcConfig
+	}
+	return config
+}
+
+// negotiateSessionKeys answers a mobile's key exchange and returns the new keys
+func (d *Daemon) negotiateSessionKeys(req keyExchangeRequest) (*mobileKeys, keyExchangeResponse, error) {
+	d.mu.RLock()
+	revoked := d.revokedMobiles[req.MobileID]
+	d.mu.RUnlock()
+	mobile := d.currentPairedMobile(req.MobileID)
+	if mobile == nil || revoked {
+		return nil, keyExchangeResponse{}, errMobileRevoked
+	}
+
+	mobileStatic, err := decodeX25519Key(mobile.PublicKey)
+	if err != nil {
+		return nil, keyExchangeResponse{}, fmt.Errorf("paired mobile has an invalid key")
+	}
+	mobileEphemeral, err := decodeX25519Key(req.Ephemeral)
+	if err != nil {
+		return nil, keyExchangeResponse{}, err
+	}
+	pcPrivate, err := GetPrivateKeyFromHex(d.pcConfig.PrivateKey)
+	if err != nil {
+		return nil, keyExchangeResponse{}, fmt.Errorf("invalid PC key: %w", err)
+	}
+
+	ephemeralPrivate, ephemeralPublic, err := GenerateX25519KeyPair()
+	if err != nil {
+		return nil, keyExchangeResponse{}, err
+	}
+
+	// Low-order points make X25519 fail: a bogus key can't force a known secret
+	staticEphemeral, err := curve25519.X25519(pcPrivate[:], mobileEphemeral)
+	if err != nil {
+		return nil, keyExchangeResponse{}, fmt.Errorf("invalid ephemeral key")
+	}
+	ephemeralStatic, err := curve25519.X25519(ephemeralPrivate[:], mobileStatic)
+	if err != nil {
+		return nil, keyExchangeResponse{}, fmt.Errorf("paired mobile has an invalid key")
+	}
+	ephemeralEphemeral, err := curve25519.X25519(ephemeralPrivate[:], mobileEphemeral)
+	if err != nil {
+		return nil, keyExchangeResponse{}, fmt.Errorf("invalid ephemeral key")
+	}
+
+	d.mu.RLock()
+	token, session := d.token, d.session
+	d.mu.RUnlock()
+
+	material, err := deriveSessionKeys(token, session, mobile.ID, mobileEphemeral, ephemeralPublic[:],
+		staticEphemeral, ephemeralStatic, ephemeralEphemeral)
+	if err != nil {
+		return nil, keyExchangeResponse{}, err
+	}
+
+	recv, err := newGCM(material.mobileToCLI)
+	if err != nil {
+		return nil, keyExchangeResponse{}, err
+	}
+	send, err := newGCM(material.cliToMobile)
+	if err != nil {
+		return nil, keyExchangeResponse{}, err
+	}
+	keys := &mobileKeys{
+		mobileID:    mobile.ID,
+		send:        &frameKey{aead: send},
+		recv:        &frameKey{aead: recv},
+		established: time.Now(),
+	}
+
+	resp := keyExchangeResponse{
+		Ephemeral: hex.EncodeToString(ephemeralPublic[:]),
+		Confirm:   hex.EncodeToString(keyConfirmation(material.confirm)),
+	}
+	return keys, resp, nil
+}
+
+// handleKeyExchange processes a "kx:" frame received on conn and answers on
+// it; the keys go to slot, the keys of conn's transport. Returns true when the
+// keys were installed at once, false when they wait for a frame proving them
+// (see offerMobileKeys).
+func (d *Daemon) handleKeyExchange(slot *keySlot, conn *websocket.Conn, payload string) (bool, error) {
+	var req keyExchangeRequest
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(payload, keyExchangePrefix)), &req); err != nil || req.MobileID == "" {
+		d.writeKeyExchange(conn, keyExchangeResponse{Error: "invalid key exchange"})
+		return false, fmt.Errorf("invalid key exchange")
+	}
+
+	keys, resp, err := d.negotiateSessionKeys(req)
+	if err != nil {
+		if errors.Is(err, errMobileRevoked) {
+			d.revokeMobileKeys(req.MobileID)
+		}
+		d.writeKeyExchange(conn, keyExchangeResponse{Error: err.Error()})
+		return false, err
+	}
+
+	// Offer the keys and answer under wsMu: frames sent after the answer use the new keys
+	d.wsMu.Lock()
+	installed := d.offerMobileKeys(slot, keys)
+	err = conn.WriteJSON(Message{Type: "data", Payload: keyExchangePrefix + mustJSON(resp)})
+	d.wsMu.Unlock()
+	return installed, err
+}
+
+// writeKeyExchange sends a key exchange answer on conn
+func (d *Daemon) writeKeyExchange(conn *websocket.Conn, resp keyExchangeResponse) {
+	d.wsMu.Lock()
+	conn.WriteJSON(Message{Type: "data", Payload: keyExchangePrefix + mustJSON(resp)})
+	d.wsMu.Unlock()
+}
+
+// mustJSON marshals a value that can't fail to marshal
+func mustJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// offerMobileKeys installs keys in slot when no mobile has session keys, on
+// either transport. Otherwise the exchange may come from anyone: the keys wait
+// in slot.pending until a frame sealed with them proves it (see decrypt), and
+// the current mobile keeps its keys meanwhile. Returns true when the keys were
+// installed.
+func (d *Daemon) offerMobileKeys(slot *keySlot, keys *mobileKeys) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.relayKeys.keys != nil || d.lanKeys.keys != nil {
+		slot.pending = keys
+		return false
+	}
+	d.installMobileKeysLocked(slot, keys)
+	return true
+}
+
+// installMobileKeys makes keys the current session keys of slot and schedules the next rekey
+func (d *Daemon) installMobileKeys(slot *keySlot, keys *mobileKeys) {
+	d.mu.Lock()
+	d.installMobileKeysLocked(slot, keys)
+	d.mu.Unlock()
+}
+
+// installMobileKeysLocked is installMobileKeys with d.mu held
+func (d *Daemon) installMobileKeysLocked(slot *keySlot, keys *mobileKeys) {
+	previous := slot.keys
+	if previous != nil {
+		if previous.rekeyTimer != nil {
+			previous.rekeyTimer.Stop()
+		}
+		if previous.mobileID == keys.mobileID {
+			keys.previousRecv = previous.recv
+		}
+	}
+	if slot.pending == keys {
+		slot.pending = nil
+	}
+	slot.keys = keys
+	slot.revoked = false
+	keys.rekeyTimer = time.AfterFunc(RekeyInterval, func() { d.rekeyDue(slot, keys) })
+}
+
+// sessionKeysProven is called on the first frame received with new keys.
+// From then on legacy frames are refused, in this session and the next ones.
+func (d *Daemon) sessionKeysProven(mobileID string) {
+	d.mu.Lock()
+	d.strict = true
+	d.mu.Unlock()
+
+	config := d.currentPCConfig()
+	if mobile := config.getPairedMobile(mobileID); mobile != nil && !mobile.SessionKeys {
+		mobile.SessionKeys = true
+		if err := savePCConfig(config); err != nil {
+			fmt.Printf("%s[keys] Failed to save config: %v%s\n", dim, err, reset)
+		}
+	}
+}
+
+// rekeyDue re-checks the pairing of the mobile and asks it for a new key
+// exchange. A mobile on the transport not used for now is asked once it is.
+func (d *Daemon) rekeyDue(slot *keySlot, keys *mobileKeys) {
+	d.mu.RLock()
+	current := slot.keys == keys
+	active := d.activeKeySlotLocked() == slot
+	d.mu.RUnlock()
+	if !current {
+		return
+	}
+
+	d.checkPairings()
+	if d.currentPairedMobile(keys.mobileID) == nil {
+		d.revokeMobile(keys.mobileID)
+		return
+	}
+	if active {
+		d.sendControl("rekey-request", "", nil)
+	}
+
+	// Keep checking the pairing until the mobile rekeys
+	d.mu.Lock()
+	if slot.keys == keys {
+		keys.rekeyTimer = time.AfterFunc(RekeyInterval, func() { d.rekeyDue(slot, keys) })
+	}
+	d.mu.Unlock()
+}
+
+// revokeMobileKeys drops the session keys of an unpaired mobile. Nothing more
+// is sent or accepted on the connections it uses, and a LAN connection is closed.
+func (d *Daemon) revokeMobileKeys(mobileID string) {
+	d.mu.Lock()
+	revoked := false
+	var conn *websocket.Conn
+	for _, slot := range []*keySlot{&d.relayKeys, &d.lanKeys} {
+		if slot.pending != nil && slot.pending.mobileID == mobileID {
+			slot.pending = nil
+		}
+		if slot.keys == nil || slot.keys.mobileID != mobileID {
+			// Not the mobile of this connection: only its exchange is refused
+			continue
+		}
+		if slot.keys.rekeyTimer != nil {
+			slot.keys.rekeyTimer.Stop()
+		}
+		slot.keys = nil
+		slot.revoked = true
+		revoked = true
+		if slot == &d.lanKeys {
+			conn = d.lanConn
+		}
+	}
+	d.mu.Unlock()
+	if !revoked {
+		return
+	}
+
+	if conn != nil {
+		conn.Close()
+	}
+	fmt.Printf("%s[keys] Mobile %s is no longer paired, disconnected%s\n", dim, mobileID, reset)
+}
+
+// revokeMobile cuts off an unpaired mobile for the rest of the session and
+// rotates the session token it may know
+func (d *Daemon) revokeMobile(mobileID string) {
+	d.revokeMobileKeys(mobileID)
+
+	d.mu.Lock()
+	if d.revokedMobiles == nil {
+		d.revokedMobiles = make(map[string]bool)
+	}
+	d.revokedMobiles[mobileID] = true
+	delete(d.knownMobiles, mobileID)
+	d.legacyKeyRevoked = true
+	d.rotateToken = true
+	conn := d.wsConn
+	d.mu.Unlock()
+
+	// connectToRelay reconnects with a new session and token
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// mobilePaired lifts the revocation of a mobile paired (again) during the session
+func (d *Daemon) mobilePaired(mobileID string) {
+	d.mu.Lock()
+	delete(d.revokedMobiles, mobileID)
+	if d.knownMobiles != nil {
+		d.knownMobiles[mobileID] = true
+	}
+	d.mu.Unlock()
+}
+
+// savePairedMobile adds or updates a paired mobile in config.json, read again
+// so that changes made meanwhile by other aipilot-cli processes are kept, and
+// in the config loaded at startup
+func (d *Daemon) savePairedMobile(mobile PairedMobile) error {
+	config := d.currentPCConfig()
+	if config == nil {
+		return fmt.Errorf("no PC configuration")
+	}
+	config.addPairedMobile(mobile)
+	if d.pcConfig != nil && d.pcConfig != config {
+		d.pcConfig.addPairedMobile(mobile)
+	}
+	return savePCConfig(config)
+}
+
+// checkPairings revokes the mobiles unpaired since the last check, by this or
+// another aipilot-cli process, and turns strict mode on when a paired mobile
+// uses session keys
+func (d *Daemon) checkPairings() {
+	config := d.currentPCConfig()
+	if config == nil {
+		return
+	}
+	paired := make(map[string]bool)
+	for _, m := range config.PairedMobiles {
+		paired[m.ID] = true
+	}
+
+	d.mu.Lock()
+	var unpaired []string
+	for id := range d.knownMobiles {
+		if !paired[id] {
+			unpaired = append(unpaired, id)
+		}
+	}
+	d.knownMobiles = paired
+	if config.usesSessionKeys() {
+		d.strict = true
+	}
+	d.mu.Unlock()
+
+	for _, id := range unpaired {
+		d.revokeMobile(id)
+	}
+}
+
+// tokenRotationDue reports whether the session token must be rotated
+func (d *Daemon) tokenRotationDue() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.rotateToken
+}
+
+// resetMobileKeys forgets the keys of the previous connection on slot's
+// transport: a new mobile connection starts with a new key exchange, or with
+// the legacy token key. Revoked mobiles stay revoked.
+func (d *Daemon) resetMobileKeys(slot *keySlot) {
+	d.mu.Lock()
+	if slot.keys != nil && slot.keys.rekeyTimer != nil {
+		slot.keys.rekeyTimer.Stop()
+	}
+	slot.keys = nil
+	slot.pending = nil
+	slot.revoked = false
+	d.mu.Unlock()
+}
+
+// refusesLegacyKey reports whether legacy token-key frames are refused:
+// strict mode, or the token may be known by an unpaired mobile
+func (d *Daemon) refusesLegacyKey() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.strict || d.legacyKeyRevoked
+}
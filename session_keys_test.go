@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/curve25519"
+)
+
+// newKeyExchangeDaemon returns a daemon whose PC has one paired mobile,
+// with the pairing config saved in a temporary config directory
+func newKeyExchangeDaemon(t *testing.T) (*Daemon, [32]byte) {
+	t.Helper()
+	previous := customConfigDir
+	customConfigDir = t.TempDir()
+	t.Cleanup(func() { customConfigDir = previous })
+
+	pcPrivate, pcPublic, _ := GenerateX25519KeyPair()
+	mobilePrivate, mobilePublic, _ := GenerateX25519KeyPair()
+	config := &PCConfig{
+		PCID:       "pc-1",
+		PrivateKey: hex.EncodeToString(pcPrivate[:]),
+		PublicKey:  hex.EncodeToString(pcPublic[:]),
+		PairedMobiles: []PairedMobile{
+			{ID: "mobile-1", Name: "Phone", PublicKey: hex.EncodeToString(mobilePublic[:])},
+		},
+	}
+	if err := savePCConfig(config); err != nil {
+		t.Fatalf("save config: %v", err)
+	}
+
+	d := &Daemon{pcConfig: config, session: "sess-1", token: "token-1"}
+	if err := d.initEncryption(); err != nil {
+		t.Fatalf("init encryption: %v", err)
+	}
+	return d, mobilePrivate
+}
+
+// mobileSideKeys runs the mobile half of the key exchange against the CLI answer
+func mobileSideKeys(t *testing.T, d *Daemon, mobilePrivate, ephemeralPrivate [32]byte, ephemeralPublic []byte, resp keyExchangeResponse) *sessionKeyMaterial {
+	t.Helper()
+	pcPublic, _ := hex.DecodeString(d.pcConfig.PublicKey)
+	cliEphemeral, _ := hex.DecodeString(resp.Ephemeral)
+
+	staticEphemeral, _ := curve25519.X25519(ephemeralPrivate[:], pcPublic)
+	ephemeralStatic, _ := curve25519.X25519(mobilePrivate[:], cliEphemeral)
+	ephemeralEphemeral, _ := curve25519.X25519(ephemeralPrivate[:], cliEphemeral)
+
+	material, err := deriveSessionKeys("token-1", "sess-1", "mobile-1", ephemeralPublic, cliEphemeral,
+		staticEphemeral, ephemeralStatic, ephemeralEphemeral)
+	if err != nil {
+		t.Fatalf("derive: %v", err)
+	}
+	return material
+}
+
+// sealForTest seals a strict mobile -> CLI frame with the given counter
+func sealForTest(t *testing.T, key []byte, counter uint64, plaintext []byte) string {
+	t.Helper()
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatalf("gcm: %v", err)
+	}
+	frame, err := sealFrame(&frameKey{aead: gcm, counter: counter - 1}, frameLabelMobileToCLI, plaintext)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(frame)
+}
+
+func TestKeyExchange_PairedMobile(t *testing.T) {
+	d, mobilePrivate := newKeyExchangeDaemon(t)
+
+	// Encrypted with the token key before the exchange
+	legacy, err := d.encrypt(&d.relayKeys, []byte("legacy"))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	ephemeralPrivate, ephemeralPublic, _ := GenerateX25519KeyPair()
+	keys, resp, err := d.negotiateSessionKeys(keyExchangeRequest{
+		MobileID:  "mobile-1",
+		Ephemeral: hex.EncodeToString(ephemeralPublic[:]),
+	})
+	if err != nil {
+		t.Fatalf("negotiate: %v", err)
+	}
+	d.installMobileKeys(&d.relayKeys, keys)
+	defer d.resetMobileKeys(&d.relayKeys)
+
+	material := mobileSideKeys(t, d, mobilePrivate, ephemeralPrivate, ephemeralPublic[:], resp)
+	if hex.EncodeToString(keyConfirmation(material.confirm)) != resp.Confirm {
+		t.Fatal("key confirmation mismatch")
+	}
+
+	// mobile -> CLI with the mobile's key
+	plaintext, err := d.decrypt(&d.relayKeys, sealForTest(t, material.mobileToCLI, 1, []byte("ls\r")))
+	if err != nil || string(plaintext) != "ls\r" {
+		t.Fatalf("decrypt: %q %v", plaintext, err)
+	}
+
+	// The key of the other direction, and the token key, are refused
+	if _, err := d.decrypt(&d.relayKeys, sealForTest(t, material.cliToMobile, 2, []byte("x"))); err == nil {
+		t.Fatal("expected frame sealed with the CLI key to be refused")
+	}
+	if _, err := d.decodeMobilePayload(&d.relayKeys, legacy); err == nil {
+		t.Fatal("expected token-key frame to be refused after key exchange")
+	}
+	if _, err := d.decodeMobilePayload(&d.relayKeys, base64.StdEncoding.EncodeToString([]byte("plain"))); err == nil {
+		t.Fatal("expected plaintext frame to be refused after key exchange")
+	}
+}
+
+func TestKeyExchange_UnknownMobileRefused(t *testing.T) {
+	d, _ := newKeyExchangeDaemon(t)
+
+	_, ephemeralPublic, _ := GenerateX25519KeyPair()
+	_, _, err := d.negotiateSessionKeys(keyExchangeRequest{
+		MobileID:  "stranger",
+		Ephemeral: hex.EncodeToString(ephemeralPublic[:]),
+	})
+	if !errors.Is(err, errMobileRevoked) {
+		t.Fatalf("expected errMobileRevoked, got %v", err)
+	}
+
+	_, _, err = d.negotiateSessionKeys(keyExchangeRequest{MobileID: "mobile-1", Ephemeral: "00"})
+	if err == nil {
+		t.Fatal("expected invalid ephemeral key to be refused")
+	}
+}
+
+func TestKeyExchange_UnpairCutsOffMobile(t *testing.T) {
+	d, _ := newKeyExchangeDaemon(t)
+
+	_, ephemeralPublic, _ := GenerateX25519KeyPair()
+	keys, _, err := d.negotiateSessionKeys(keyExchangeRequest{
+		MobileID:  "mobile-1",
+		Ephemeral: hex.EncodeToString(ephemeralPublic[:]),
+	})
+	if err != nil {
+		t.Fatalf("negotiate: %v", err)
+	}
+	d.installMobileKeys(&d.relayKeys, keys)
+	defer d.resetMobileKeys(&d.relayKeys)
+
+	// Another aipilot-cli process unpairs the mobile
+	config, _ := loadPCConfig()
+	config.removePairedMobile("mobile-1")
+	if err := savePCConfig(config); err != nil {
+		t.Fatalf("save config: %v", err)
+	}
+
+	d.rekeyDue(&d.relayKeys, keys)
+
+	if _, err := d.encrypt(&d.relayKeys, []byte("output")); !errors.Is(err, errMobileRevoked) {
+		t.Fatalf("expected errMobileRevoked on send, got %v", err)
+	}
+	if _, err := d.decrypt(&d.relayKeys, "AAAA"); !errors.Is(err, errMobileRevoked) {
+		t.Fatalf("expected errMobileRevoked on receive, got %v", err)
+	}
+
+	// A new connection doesn't bring back the token key it may know
+	d.resetMobileKeys(&d.relayKeys)
+	if _, err := d.encrypt(&d.relayKeys, []byte("output")); !errors.Is(err, errLegacyFrame) {
+		t.Fatalf("expected the token key to stay refused, got %v", err)
+	}
+	if !d.tokenRotationDue() {
+		t.Fatal("token rotation not scheduled")
+	}
+}
+
+func TestKeyExchange_UnprovenExchangeKeepsKeys(t *testing.T) {
+	d, mobilePrivate := newKeyExchangeDaemon(t)
+
+	negotiate := func() (*mobileKeys, *sessionKeyMaterial) {
+		ephemeralPrivate, ephemeralPublic, _ := GenerateX25519KeyPair()
+		keys, resp, err := d.negotiateSessionKeys(keyExchangeRequest{
+			MobileID:  "mobile-1",
+			Ephemeral: hex.EncodeToString(ephemeralPublic[:]),
+		})
+		if err != nil {
+			t.Fatalf("negotiate: %v", err)
+		}
+		return keys, mobileSideKeys(t, d, mobilePrivate, ephemeralPrivate, ephemeralPublic[:], resp)
+	}
+
+	current, material := negotiate()
+	if !d.offerMobileKeys(&d.relayKeys, current) {
+		t.Fatal("first keys not installed")
+	}
+	defer d.resetMobileKeys(&d.relayKeys)
+
+	// Anyone can answer to a key exchange: the connected mobile keeps its keys
+	pending, pendingMaterial := negotiate()
+	if d.offerMobileKeys(&d.relayKeys, pending) || d.relayKeys.keys != current {
+		t.Fatal("unproven keys replaced the current ones")
+	}
+	if plaintext, err := d.decrypt(&d.relayKeys, sealForTest(t, material.mobileToCLI, 1, []byte("a"))); err != nil || string(plaintext) != "a" {
+		t.Fatalf("current keys refused: %q %v", plaintext, err)
+	}
+
+	// A frame sealed with the new keys proves them
+	if plaintext, err := d.decrypt(&d.relayKeys, sealForTest(t, pendingMaterial.mobileToCLI, 1, []byte("b"))); err != nil || string(plaintext) != "b" {
+		t.Fatalf("pending keys refused: %q %v", plaintext, err)
+	}
+	if d.relayKeys.keys != pending || d.relayKeys.pending != nil {
+		t.Fatal("proven keys not installed")
+	}
+}
+
+func TestKeyExchange_RelayReconnectKeepsLANKeys(t *testing.T) {
+	d, mobilePrivate := newKeyExchangeDaemon(t)
+
+	ephemeralPrivate, ephemeralPublic, _ := GenerateX25519KeyPair()
+	keys, resp, _ := d.negotiateSessionKeys(keyExchangeRequest{
+		MobileID:  "mobile-1",
+		Ephemeral: hex.EncodeToString(ephemeralPublic[:]),
+	})
+	d.lanConn = &websocket.Conn{}
+	d.installMobileKeys(&d.lanKeys, keys)
+	defer d.resetMobileKeys(&d.lanKeys)
+	d.setMobileProtocol(2, []string{"session-keys"})
+	material := mobileSideKeys(t, d, mobilePrivate, ephemeralPrivate, ephemeralPublic[:], resp)
+
+	// A mobile (re)connects through the relay while the LAN one is in use
+	d.relayMobileConnected()
+
+	if d.lanKeys.keys != keys || !d.mobileHasCapability("session-keys") {
+		t.Fatal("relay connection reset the LAN mobile")
+	}
+	frame := sealForTest(t, material.mobileToCLI, 1, []byte("ls"))
+	if _, err := d.decrypt(&d.relayKeys, frame); err == nil {
+		t.Fatal("LAN keys accepted on the relay")
+	}
+	if plaintext, err := d.decrypt(&d.lanKeys, frame); err != nil || string(plaintext) != "ls" {
+		t.Fatalf("LAN frame refused: %q %v", plaintext, err)
+	}
+}
+
+func TestKeyExchange_ProvenKeysRefuseLegacyFrames(t *testing.T) {
+	d, mobilePrivate := newKeyExchangeDaemon(t)
+	legacy, _ := d.encrypt(&d.relayKeys, []byte("legacy"))
+
+	ephemeralPrivate, ephemeralPublic, _ := GenerateX25519KeyPair()
+	keys, resp, _ := d.negotiateSessionKeys(keyExchangeRequest{
+		MobileID:  "mobile-1",
+		Ephemeral: hex.EncodeToString(ephemeralPublic[:]),
+	})
+	d.installMobileKeys(&d.relayKeys, keys)
+	material := mobileSideKeys(t, d, mobilePrivate, ephemeralPrivate, ephemeralPublic[:], resp)
+	if _, err := d.decrypt(&d.relayKeys, sealForTest(t, material.mobileToCLI, 1, []byte("ls"))); err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+
+	// Another mobile connecting with the token key is refused
+	d.resetMobileKeys(&d.relayKeys)
+	if _, err := d.decodeMobilePayload(&d.relayKeys, legacy); !errors.Is(err, errLegacyFrame) {
+		t.Fatalf("expected legacy frame to be refused, got %v", err)
+	}
+	config, _ := loadPCConfig()
+	if !config.usesSessionKeys() {
+		t.Fatal("session keys not recorded for the next sessions")
+	}
+}
+
+func TestCheckPairings_UnpairedElsewhere(t *testing.T) {
+	d, _ := newKeyExchangeDaemon(t)
+	d.knownMobiles = map[string]bool{"mobile-1": true}
+
+	d.checkPairings()
+	if d.tokenRotationDue() {
+		t.Fatal("token rotated without an unpair")
+	}
+
+	// Another aipilot-cli process unpairs the mobile
+	config, _ := loadPCConfig()
+	config.removePairedMobile("mobile-1")
+	savePCConfig(config)
+	d.checkPairings()
+	if !d.tokenRotationDue() || !d.revokedMobiles["mobile-1"] {
+		t.Fatal("unpair not noticed")
+	}
+
+	// Paired again: no longer revoked
+	config.addPairedMobile(d.pcConfig.PairedMobiles[0])
+	savePCConfig(config)
+	d.mobilePaired("mobile-1")
+	_, ephemeralPublic, _ := GenerateX25519KeyPair()
+	if _, _, err := d.negotiateSessionKeys(keyExchangeRequest{
+		MobileID:  "mobile-1",
+		Ephemeral: hex.EncodeToString(ephemeralPublic[:]),
+	}); err != nil {
+		t.Fatalf("paired again mobile refused: %v", err)
+	}
+}
+
+func TestRevokeMobile_RefusedForTheSession(t *testing.T) {
+	d, _ := newKeyExchangeDaemon(t)
+
+	// Still in a config that can't be re-read: refused anyway
+	d.revokeMobile("mobile-1")
+	_, ephemeralPublic, _ := GenerateX25519KeyPair()
+	if _, _, err := d.negotiateSessionKeys(keyExchangeRequest{
+		MobileID:  "mobile-1",
+		Ephemeral: hex.EncodeToString(ephemeralPublic[:]),
+	}); !errors.Is(err, errMobileRevoked) {
+		t.Fatalf("expected errMobileRevoked, got %v", err)
+	}
+}
+
+func TestSavePairedMobile_KeepsChangesFromOtherProcesses(t *testing.T) {
+	d, _ := newKeyExchangeDaemon(t)
+	if d.currentPairedMobile("mobile-1").ViewOnly {
+		t.Fatal("mobile view-only from the start")
+	}
+
+	// Written by another aipilot-cli process: the cached config is stale
+	config, _ := loadPCConfig()
+	config.PairedMobiles[0].ViewOnly = true
+	data, _ := json.MarshalIndent(config, "", "  ")
+	if err := os.WriteFile(filepath.Join(customConfigDir, "config.json"), data, FilePermissions); err != nil {
+		t.Fatal(err)
+	}
+	if !d.currentPairedMobile("mobile-1").ViewOnly {
+		t.Fatal("change on disk not seen")
+	}
+
+	if err := d.savePairedMobile(PairedMobile{ID: "mobile-2", Name: "Tablet"}); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := loadPCConfig()
+	if m := saved.getPairedMobile("mobile-1"); m == nil || !m.ViewOnly {
+		t.Fatalf("view-only lost when pairing another mobile: %+v", m)
+	}
+	if saved.getPairedMobile("mobile-2") == nil || d.pcConfig.getPairedMobile("mobile-2") == nil {
+		t.Fatal("new mobile not saved")
+	}
+
+	// Callers get their own copy of the cached config
+	d.currentPCConfig().PairedMobiles[0].ViewOnly = false
+	if !d.currentPairedMobile("mobile-1").ViewOnly {
+		t.Fatal("cached config modified through a copy")
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Agent settings files (JSON) are edited in place: key order, indentation,
+// numbers and non-ASCII text are kept, so only the aipilot entries change.
+// A backup is written next to the file before each edit.
+
+// jsonObject is a JSON object that keeps its key order
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]interface{})}
+}
+
+// Get returns the value of a key
+func (o *jsonObject) Get(key string) (interface{}, bool) {
+	v, ok := o.values[key]
+	return v, ok
+}
+
+// Object returns the object value of a key, nil if absent or not an object
+func (o *jsonObject) Object(key string) *jsonObject {
+	obj, _ := o.values[key].(*jsonObject)
+	return obj
+}
+
+// Array returns the array value of a key, nil if absent or not an array
+func (o *jsonObject) Array(key string) []interface{} {
+	arr, _ := o.values[key].([]interface{})
+	return arr
+}
+
+// Set sets a key, appending it when new
+func (o *jsonObject) Set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// Delete removes a key
+func (o *jsonObject) Delete(key string) {
+	if _, ok := o.values[key]; !ok {
+		return
+	}
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Len returns the number of keys
+func (o *jsonObject) Len() int {
+	return len(o.keys)
+}
+
+// parseJSONObject parses a JSON object, keeping key order.
+// Values are *jsonObject, []interface{}, string, json.Number, bool or nil.
+func parseJSONObject(data []byte) (*jsonObject, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return newJSONObject(), nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	value, err := decodeJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := value.(*jsonObject)
+	if !ok {
+		return nil, fmt.Errorf("settings are not a JSON object")
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON object")
+	}
+	return obj, nil
+}
+
+func decodeJSONValue(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := newJSONObject()
+			for dec.More() {
+				keyToken, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, _ := keyToken.(string)
+				value, err := decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj.Set(key, value)
+			}
+			_, err := dec.Token() // }
+			return obj, err
+		case '[':
+			arr := []interface{}{}
+			for dec.More() {
+				value, err := decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, value)
+			}
+			_, err := dec.Token() // ]
+			return arr, err
+		}
+		return nil, fmt.Errorf("unexpected %v", t)
+	default:
+		return t, nil
+	}
+}
+
+// encodeJSON formats a value like json.MarshalIndent with the given indent,
+// keeping key order and without escaping <, > and &
+func encodeJSON(value interface{}, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeJSONValue(&buf, value, indent, ""); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func writeJSONValue(buf *bytes.Buffer, value interface{}, indent, prefix string) error {
+	switch v := value.(type) {
+	case *jsonObject:
+		if v.Len() == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i, key := range v.keys {
+			buf.WriteString(prefix + indent)
+			writeJSONScalar(buf, key)
+			buf.WriteString(": ")
+			if err := writeJSONValue(buf, v.values[key], indent, prefix+indent); err != nil {
+				return err
+			}
+			if i < len(v.keys)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(prefix + "}")
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, item := range v {
+			buf.WriteString(prefix + indent)
+			if err := writeJSONValue(buf, item, indent, prefix+indent); err != nil {
+				return err
+			}
+			if i < len(v)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(prefix + "]")
+	default:
+		return writeJSONScalar(buf, v)
+	}
+	return nil
+}
+
+func writeJSONScalar(buf *bytes.Buffer, value interface{}) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return err
+	}
+	buf.Truncate(buf.Len() - 1) // Encode adds a newline
+	return nil
+}
+
+// detectJSONIndent returns the indentation of the first indented line, "  " by default
+func detectJSONIndent(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return "  "
+}
+
+// settingsFile is a JSON settings file loaded for editing
+type settingsFile struct {
+	path   string
+	exists bool
+	raw    []byte
+	root   *jsonObject
+}
+
+// loadSettingsFile reads a JSON settings file. A missing file is empty settings.
+func loadSettingsFile(path string) (*settingsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &settingsFile{path: path, root: newJSONObject()}, nil
+		}
+		return nil, err
+	}
+	root, err := parseJSONObject(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &settingsFile{path: path, exists: true, raw: data, root: root}, nil
+}
+
+// save backs up the current file and writes the edited settings with the
+// original indentation and permissions
+func (f *settingsFile) save() error {
+	data, err := encodeJSON(f.root, detectJSONIndent(f.raw))
+	if err != nil {
+		return err
+	}
+	return writeSettingsWithBackup(f.path, data)
+}
+
+// writeSettingsWithBackup replaces an agent settings file, keeping a
+// timestamped copy of the previous content and the file's permissions.
+// A symlinked file (kept in a dotfiles repository) stays a link: its target
+// is replaced.
+func writeSettingsWithBackup(path string, data []byte) error {
+	path, err := resolveSettingsLink(path)
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+		previous, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := writeSettingsBackup(path, previous, mode); err != nil {
+			return fmt.Errorf("cannot back up %s: %w", path, err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write next to the file then rename, so the agent never reads half a file
+	tmp := path + ".aipilot-tmp"
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// resolveSettingsLink returns the file a settings path links to, the path
+// itself when it is not a symlink. The target may not exist yet.
+func resolveSettingsLink(path string) (string, error) {
+	for i := 0; i < 40; i++ { // Link loops give up like the OS does
+		info, err := os.Lstat(path)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return "", fmt.Errorf("%s: too many levels of symbolic links", path)
+}
+
+// writeSettingsBackup writes the previous content of a settings file next to
+// it, named after the time, with a counter when that name is taken
+func writeSettingsBackup(path string, data []byte, mode os.FileMode) error {
+	base := path + ".aipilot-backup-" + time.Now().Format("20060102-150405")
+	name := base
+	for i := 2; ; i++ {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if os.IsExist(err) {
+			name = fmt.Sprintf("%s-%d", base, i)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+}
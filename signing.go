@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Signed relay requests.
+// Every relay call of the PC carries its ID, a timestamp, a nonce and an
+// Ed25519 signature over PC ID, method, path, timestamp, nonce and body hash. The signing key is
+// derived from the X25519 private key in PCConfig, so no new secret is stored.
+// The relay registers the signing key together with the PC's public key, in
+// the request carrying it (pairing init, or the first session of a PC paired
+// through another relay), then rejects unsigned, stale, replayed or
+// foreign-key requests: a leaked PC ID alone no longer lists tokens, deletes
+// sessions or takes the PC ID over. Another signing key is only accepted with
+// a proof signed by the registered one (HeaderKeyProof).
+//
+// Mobiles sign their session listing the same way (same signature headers,
+// identity in the mobile_id query parameter), with the key derived from their
+// own X25519 private key. The relay pins it at pairing, or on first use for
+// mobiles paired before mobiles signed.
+
+// Signature headers
+const (
+	HeaderPCID       = "X-PC-ID"
+	HeaderSigningKey = "X-PC-Signing-Key" // Hex Ed25519 public key
+	HeaderTimestamp  = "X-PC-Timestamp"   // Unix seconds
+	HeaderNonce      = "X-PC-Nonce"       // Random, makes every signature unique
+	HeaderSignature  = "X-PC-Signature"   // Base64 Ed25519 signature
+	HeaderKeyProof   = "X-PC-Key-Proof"   // Base64 signature of a signing key change by the registered key
+)
+
+// signingKeyContext separates the derived signing key from other uses of the X25519 key
+const signingKeyContext = "aipilot-relay-signing-v1:"
+
+// keyChangeContext separates signing key change proofs from request signatures
+const keyChangeContext = "aipilot-relay-key-change-v1:"
+
+// deriveSigningKey derives the PC's Ed25519 request signing key from its X25519 private key
+func deriveSigningKey(privateKeyHex string) (ed25519.PrivateKey, error) {
+	privateKey, err := GetPrivateKeyFromHex(privateKeyHex)
+	if err != nil {
+		return nil, err
+	}
+	seed := sha256.Sum256(append([]byte(signingKeyContext), privateKey[:]...))
+	return ed25519.NewKeyFromSeed(seed[:]), nil
+}
+
+// signaturePayload is the signed representation of a request. pcID is ""
+// for mobile requests, whose identity is in the signed query.
+func signaturePayload(pcID, method, requestURI, timestamp, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	payload := method + "\n" + requestURI + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(bodyHash[:])
+	if pcID != "" {
+		payload = pcID + "\n" + payload
+	}
+	return []byte(payload)
+}
+
+// signatureHeaders returns the identity and signature headers of a request
+// signed with signingKey, for pcID ("" for a mobile)
+func signatureHeaders(signingKey ed25519.PrivateKey, pcID, method, requestURI string, body []byte) http.Header {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := generateRandomToken()
+	signature := ed25519.Sign(signingKey, signaturePayload(pcID, method, requestURI, timestamp, nonce, body))
+
+	header := http.Header{}
+	if pcID != "" {
+		header.Set(HeaderPCID, pcID)
+	}
+	header.Set(HeaderSigningKey, hex.EncodeToString(signingKey.Public().(ed25519.PublicKey)))
+	header.Set(HeaderTimestamp, timestamp)
+	header.Set(HeaderNonce, nonce)
+	header.Set(HeaderSignature, base64.StdEncoding.EncodeToString(signature))
+	return header
+}
+
+// signedHeaders returns the identity and signature headers for a request
+func (c *RelayClient) signedHeaders(method, requestURI string, body []byte) (http.Header, error) {
+	signingKey, err := deriveSigningKey(c.pcConfig.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive signing key: %w", err)
+	}
+
+	return signatureHeaders(signingKey, c.pcConfig.PCID, method, requestURI, body), nil
+}
+
+// keyChangePayload is what the registered signing key of a PC signs to hand
+// the PC ID over to a new signing key
+func keyChangePayload(pcID, newKeyHex string) []byte {
+	return []byte(keyChangeContext + pcID + "\n" + newKeyHex)
+}
+
+// keyChangeProof returns the HeaderKeyProof value handing pcID over from
+// previousKey to newKey
+func keyChangeProof(previousKey ed25519.PrivateKey, pcID string, newKey ed25519.PublicKey) string {
+	signature := ed25519.Sign(previousKey, keyChangePayload(pcID, hex.EncodeToString(newKey)))
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+// verifyKeyChange reports whether a request signed with newKeyHex carries the
+// proof that registeredKeyHex, the key of pcID, handed the PC ID over to it
+func verifyKeyChange(r *http.Request, pcID, registeredKeyHex, newKeyHex string) bool {
+	registeredKey, err := hex.DecodeString(registeredKeyHex)
+	if err != nil || len(registeredKey) != ed25519.PublicKeySize {
+		return false
+	}
+	proof, err := base64.StdEncoding.DecodeString(r.Header.Get(HeaderKeyProof))
+	if err != nil || len(proof) == 0 {
+		return false
+	}
+	return ed25519.Verify(registeredKey, keyChangePayload(pcID, newKeyHex), proof)
+}
+
+// newSignedRequest builds a relay API request signed with the PC identity
+func (c *RelayClient) newSignedRequest(method, path string, body []byte) (*http.Request, error) {
+	httpReq, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	header, err := c.signedHeaders(method, httpReq.URL.RequestURI(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		httpReq.Header[name] = values
+	}
+	return httpReq, nil
+}
+
+// wsURLPath returns the path prefix of a relay URL, signed as part of the WebSocket request
+func wsURLPath(relayURL string) string {
+	u, err := url.Parse(relayURL)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+// verifyRequestSignature checks the signature headers of a request against its
+// body and returns the signing key. The body is restored for the handler.
+func verifyRequestSignature(r *http.Request, now time.Time) (string, error) {
+	keyHex := r.Header.Get(HeaderSigningKey)
+	timestamp := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get(HeaderSignature))
+	if keyHex == "" || timestamp == "" || nonce == "" || err != nil || len(signature) == 0 {
+		return "", errors.New("missing request signature")
+	}
+
+	key, err := hex.DecodeString(keyHex)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", errors.New("invalid signing key")
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", errors.New("invalid timestamp")
+	}
+	skew := now.Sub(time.Unix(unix, 0))
+	if skew > RequestSignatureMaxAge || skew < -RequestSignatureMaxAge {
+		return "", errors.New("request timestamp out of range")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return "", errors.New("failed to read body")
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	payload := signaturePayload(r.Header.Get(HeaderPCID), r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !ed25519.Verify(key, payload, signature) {
+		return "", errors.New("invalid request signature")
+	}
+	return keyHex, nil
+}
@@ -45,23 +45,23 @@ func getDefaultRouteIP() string {
 
 // installSSHKey installs an SSH public key to authorized_keys
 // It removes any existing key for this mobileId before adding the new one
-func (d *Daemon) installSSHKey(username, mobileId, keyBase64 string) {
+func (d *Daemon) installSSHKey(reqID, username, mobileId, keyBase64 string) {
 	keyBytes, err := base64.StdEncoding.DecodeString(keyBase64)
 	if err != nil {
-		d.sendControlMessage("ssh-setup-result:error:Invalid key encoding")
+		d.sendControlError("ssh-setup-result", reqID, ErrCodeInvalidRequest, "Invalid key encoding")
 		return
 	}
 	publicKey := strings.TrimSpace(string(keyBytes))
 
 	home, err := os.UserHomeDir()
 	if err != nil {
-		d.sendControlMessage("ssh-setup-result:error:Cannot find home directory")
+		d.sendControlError("ssh-setup-result", reqID, ErrCodeInternal, "Cannot find home directory")
 		return
 	}
 
 	sshDir := filepath.Join(home, ".ssh")
 	if err := os.MkdirAll(sshDir, DirPermissions); err != nil {
-		d.sendControlMessage("ssh-setup-result:error:Cannot create .ssh directory")
+		d.sendControlError("ssh-setup-result", reqID, ErrCodeInternal, "Cannot create .ssh directory")
 		return
 	}
 
@@ -77,7 +77,7 @@ func (d *Daemon) installSSHKey(username, mobileId, keyBase64 string) {
 
 	// Check if exact key already installed
 	if strings.Contains(string(existingKeys), publicKey) {
-		d.sendControlMessage("ssh-setup-result:success:Key already installed")
+		d.sendControl("ssh-setup-result", reqID, sshSetupResultPayload{Message: "Key already installed"})
 		return
 	}
 
@@ -107,15 +107,15 @@ func (d *Daemon) installSSHKey(username, mobileId, keyBase64 string) {
 	// Write back the file
 	content := strings.Join(newLines, "\n") + "\n"
 	if err := os.WriteFile(authKeysPath, []byte(content), FilePermissions); err != nil {
-		d.sendControlMessage("ssh-setup-result:error:Cannot write authorized_keys")
+		d.sendControlError("ssh-setup-result", reqID, ErrCodeInternal, "Cannot write authorized_keys")
 		return
 	}
 
 	if removedOld {
-		d.sendControlMessage("ssh-setup-result:success:Key updated (replaced old key)")
+		d.sendControl("ssh-setup-result", reqID, sshSetupResultPayload{Message: "Key updated (replaced old key)"})
 		fmt.Printf("\n%s[AIPilot] SSH key updated for mobile %s%s\n", green, mobileId[:8], reset)
 	} else {
-		d.sendControlMessage("ssh-setup-result:success:Key installed successfully")
+		d.sendControl("ssh-setup-result", reqID, sshSetupResultPayload{Message: "Key installed successfully"})
 		fmt.Printf("\n%s[AIPilot] SSH key installed for mobile %s%s\n", green, mobileId[:8], reset)
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 	"time"
 )
 
@@ -72,22 +73,49 @@ func (d *Daemon) switchToClient(client string) {
 
 		// Notify mobile of mode change via control channel
 		go func(c string) {
-			d.sendControlMessage("mode:" + c)
+			d.sendControl("mode", "", modePayload{Client: c})
 		}(client)
 	}
 }
 
+// broadcastPTYOutput feeds PTY output to the scrollback buffer and screen model,
+// then forwards it to mobile. Holding outputMu keeps live output from
+// interleaving with an in-progress replay or screen snapshot.
+func (d *Daemon) broadcastPTYOutput(data []byte) {
+	d.outputMu.Lock()
+	defer d.outputMu.Unlock()
+
+	if d.scrollback != nil {
+		d.scrollback.Write(data)
+	}
+	d.recorder.Output(data)
+	d.conversation.Output(data)
+	if d.screen != nil {
+		d.screen.Write(data)
+	}
+	// Only the session shown locally writes to the terminal, unless a
+	// command panel covers it (repainted from the screen model on close)
+	if (d.mux == nil || d.mux.isActive(d)) && !d.localPanel {
+		os.Stdout.Write(data)
+	}
+	if !d.mobilePanel {
+		d.sendToMobile(data)
+	}
+}
+
 // sendToPTY sends data to the PTY (and thus to Claude)
 // This method is thread-safe.
 func (d *Daemon) sendToPTY(data []byte) {
 	d.mu.RLock()
 	ptmx := d.ptmx
+	recorder := d.recorder
 	d.mu.RUnlock()
 
 	if ptmx != nil {
 		d.ptyMu.Lock()
 		ptmx.Write(data)
 		d.ptyMu.Unlock()
+		recorder.Input(data)
 	}
 }
 
@@ -111,6 +139,7 @@ func (d *Daemon) readFromPTY(buf []byte) (int, error) {
 func (d *Daemon) resizePTY(rows, cols uint16) error {
 	d.mu.RLock()
 	ptmx := d.ptmx
+	recorder := d.recorder
 	d.mu.RUnlock()
 
 	if ptmx == nil {
@@ -120,5 +149,14 @@ func (d *Daemon) resizePTY(rows, cols uint16) error {
 	d.ptyMu.Lock()
 	err := ptmx.Resize(int(cols), int(rows))
 	d.ptyMu.Unlock()
+
+	if err == nil {
+		recorder.Resize(int(cols), int(rows))
+	}
+
+	// Keep the screen model in sync with the PTY size
+	if err == nil && d.screen != nil {
+		d.screen.Resize(int(cols), int(rows))
+	}
 	return err
 }
@@ -61,8 +61,17 @@ type Daemon struct {
 	mobileConnected bool
 	relayConnected  bool
 
-	// PTY
+	// Local LAN direct mode (bypasses the relay)
+	lanListener net.Listener
+	lanConn     *websocket.Conn
+	lanURL      string // Advertised base URL, e.g. ws://192.168.1.10:7722
+
+	// PTY and agent process
 	ptmx pty.Pty
+	cmd  *pty.Cmd
+
+	// Multiplexer sharing the local terminal with other sessions
+	mux *Multiplexer
 
 	// Session info
 	session   string
@@ -71,6 +80,10 @@ type Daemon struct {
 	command   string
 	workDir   string
 	agentType AgentType
+	agent     AgentDefinition
+	args      []string // Agent arguments (after the definition's default args)
+	env       []string // Extra KEY=VALUE variables for the agent
+	startedAt time.Time
 
 	// PC configuration (for pairing status)
 	pcConfig    *PCConfig
@@ -79,8 +92,32 @@ type Daemon struct {
 	// E2E Encryption
 	aesGCM cipher.AEAD
 
+	// Session keys negotiated with the mobile, per transport: a mobile
+	// (re)connecting through the relay leaves the keys of the LAN one alone
+	relayKeys keySlot
+	lanKeys   keySlot
+
+	// Unpaired mobiles: refused for the whole session, and the token they may
+	// know is rotated (legacy frames are refused until then)
+	knownMobiles     map[string]bool // Paired mobiles seen at the last pairing check
+	revokedMobiles   map[string]bool
+	legacyKeyRevoked bool
+	rotateToken      bool // A new session and token are due
+
+	// Strict mode (--strict, or a mobile announced session keys): legacy
+	// frames are refused
+	strict              bool
+	lastIntegrityReport time.Time
+
 	// Mobile input buffer for command detection
-	mobileLineBuf string
+	mobileLineBuf []byte // Raw bytes, converted to a string at Enter
+
+	// Mobile is view-only (/lock)
+	inputLocked bool
+
+	// Control protocol negotiated with the mobile (0: not announced, legacy)
+	mobileProtocol     int
+	mobileCapabilities []string
 
 	// Terminal state
 	oldState *term.State
@@ -97,6 +134,11 @@ type Daemon struct {
 	// Chunked file uploads in progress
 	chunkedUploads map[string]*ChunkedUpload
 	uploadMu       sync.Mutex
+	uploadCleanup  sync.Once // Starts the cleanup of abandoned uploads
+
+	// Tool calls waiting for a decision from mobile, by request ID
+	approvals  map[string]chan ApprovalDecision
+	approvalMu sync.Mutex
 
 	// Context for cancelling ping goroutine
 	pingCtx    context.Context
@@ -106,12 +148,37 @@ type Daemon struct {
 	agentBusy            bool
 	agentIdleTimer       *time.Timer
 	agentStatusBuf       []byte
-	agentEscState        int // 0=normal, 1=ESC seen, 2=CSI, 3=OSC, 4=OSC+ESC (awaiting \)
-	agentStatusViaSocket bool // true when hook socket is active — disables PTY scan
+	busyPattern          []byte       // Lowercase, empty disables PTY scanning
+	agentEsc             escapeFilter // Escape sequence state across PTY reads
+	agentStatusViaSocket bool         // true when busy status comes from the hook socket — disables PTY scan
+
+	// Recent PTY output replayed to a reconnecting mobile
+	scrollback *ScrollbackBuffer
+	outputMu   sync.Mutex // Serializes live output with replay and snapshots
+
+	// Command panel shown locally or on mobile: output to it is held back (outputMu)
+	localPanel  bool
+	mobilePanel bool
+
+	// Headless screen model for screen snapshots
+	screen *VirtualScreen
 
 	// Hook socket for receiving events from agent hooks
 	hookSocketListener net.Listener
 	hookSocketPath     string
+	hookSecret         string // Checked on every hook message
+
+	// Session recording (--record), nil when off
+	record   bool
+	recorder *Recorder
+
+	// Conversation log (--log), nil when off
+	logChat      bool
+	conversation *ConversationLog
+
+	// Attach socket of a --detach daemon
+	attachListener   net.Listener
+	attachSocketPath string
 }
 
 // Message types for WebSocket communication
@@ -133,7 +200,7 @@ type Message struct {
 func (d *Daemon) isMobileConnected() bool {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
-	return d.mobileConnected
+	return d.mobileConnected || d.lanConn != nil
 }
 
 func (d *Daemon) setMobileConnected(connected bool) {
@@ -155,6 +222,16 @@ func (d *Daemon) cleanup() {
 	// Close hook socket
 	d.stopHookSocket()
 
+	// Finish the recording and conversation log
+	d.recorder.Close()
+	d.conversation.Close()
+
+	// Close LAN listener
+	d.stopLANListener()
+
+	// Close attach socket (detached mode)
+	d.stopAttachSocket()
+
 	// Delete session from relay (intentional exit = session gone)
 	if d.relayClient != nil && d.session != "" {
 		_ = d.relayClient.DeleteSession(d.session)
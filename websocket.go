@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"strings"
 	"time"
@@ -15,8 +14,9 @@ func (d *Daemon) connectToRelay() {
 	wasConnected := false
 	for {
 		// After a successful connection was lost, the relay deleted our session.
-		// Create a new one before reconnecting.
-		if wasConnected {
+		// Create a new one before reconnecting. Same after an unpair: the new
+		// session has a new token.
+		if wasConnected || d.tokenRotationDue() {
 			wasConnected = false
 			for {
 				if err := d.recreateSession(); err == nil {
@@ -26,8 +26,14 @@ func (d *Daemon) connectToRelay() {
 			}
 		}
 
-		wsURL := d.relay + "/ws/" + d.session + "?role=bridge&pc_id=" + d.pcConfig.PCID
-		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		wsPath := "/ws/" + d.session + "?role=bridge&pc_id=" + d.pcConfig.PCID
+		header, err := d.relayClient.signedHeaders("GET", wsURLPath(d.relay)+wsPath, nil)
+		if err != nil {
+			d.setRelayConnected(false)
+			time.Sleep(RelayConnectDelay)
+			continue
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(d.relay+wsPath, header)
 		if err != nil {
 			d.setRelayConnected(false)
 			time.Sleep(RelayConnectDelay)
@@ -126,7 +132,10 @@ func (d *Daemon) recreateSession() error {
 		displayName = d.workDir[idx+1:]
 	}
 
-	sessionResp, err := d.relayClient.CreateSession(string(d.agentType), d.workDir, displayName, sshInfo)
+	// Share the new token with the mobiles paired now, not the unpaired ones
+	client := *d.relayClient
+	client.pcConfig = d.currentPCConfig()
+	sessionResp, err := client.CreateSession(string(d.agentType), d.workDir, displayName, sshInfo)
 	if err != nil {
 		return err
 	}
@@ -140,6 +149,10 @@ func (d *Daemon) recreateSession() error {
 		return err
 	}
 
+	d.mu.Lock()
+	d.rotateToken = false
+	d.legacyKeyRevoked = false
+	d.mu.Unlock()
 	return nil
 }
 
@@ -155,66 +168,30 @@ func (d *Daemon) handleWebSocketMessages(conn *websocket.Conn) {
 
 		switch msg.Type {
 		case "data":
+			if isKeyExchange(msg.Payload) {
+				d.handleKeyExchange(&d.relayKeys, conn, msg.Payload)
+				continue
+			}
+
 			// Data from mobile -> PTY (decrypt first)
-			data, err := d.decrypt(msg.Payload)
+			data, err := d.decodeMobilePayload(&d.relayKeys, msg.Payload)
 			if err != nil {
-				// Try unencrypted fallback for backwards compatibility
-				data, err = base64.StdEncoding.DecodeString(msg.Payload)
-				if err != nil {
-					continue
-				}
+				continue
 			}
 
-			// If we receive data, mobile is definitely connected
-			if !d.isMobileConnected() {
-				d.setMobileConnected(true)
-			}
+			// If we receive data, mobile is definitely connected via the relay
+			// (isMobileConnected would also report a LAN connection)
+			d.setMobileConnected(true)
 
-			// Check for control messages (format: \x00CTRL:command:args)
-			if len(data) > 6 && data[0] == 0x00 && string(data[1:6]) == "CTRL:" {
-				ctrlMsg := string(data[6:])
-				d.handleControlMessage(ctrlMsg)
+			// Output goes to the mobile connected directly: only it types
+			if d.hasLANConn() {
 				continue
 			}
-
-			// Switch to mobile dimensions when mobile starts typing
-			d.switchToClient("mobile")
-
-			// Buffer mobile input for command detection
-			for _, char := range data {
-				if char == '\r' || char == '\n' {
-					// Check if it's an AIPilot command
-					cmd := strings.TrimSpace(strings.ToLower(d.mobileLineBuf))
-					if aipilotCmd := d.getAIPilotCommand(cmd); aipilotCmd != "" {
-						// Clear the line in PTY (Ctrl+U) and don't send Enter
-						d.sendToPTY([]byte{0x15})
-						d.executeAIPilotCommand(aipilotCmd)
-						d.mobileLineBuf = ""
-						continue
-					}
-					// Normal Enter - send to PTY
-					d.sendToPTY([]byte{char})
-					d.mobileLineBuf = ""
-				} else if char == 127 || char == 8 { // Backspace
-					if len(d.mobileLineBuf) > 0 {
-						d.mobileLineBuf = d.mobileLineBuf[:len(d.mobileLineBuf)-1]
-					}
-					d.sendToPTY([]byte{char})
-				} else if char == 3 { // Ctrl+C
-					d.mobileLineBuf = ""
-					d.sendToPTY([]byte{char})
-				} else if char >= 32 && char < 127 { // Printable
-					d.mobileLineBuf += string(char)
-					d.sendToPTY([]byte{char})
-				} else {
-					// Other chars - pass through
-					d.sendToPTY([]byte{char})
-				}
-			}
+			d.handleMobileInput(data)
 
 		case "connected":
 			if msg.Role == "mobile" {
-				d.setMobileConnected(true)
+				d.relayMobileConnected()
 				// Don't trigger refresh here - wait for mobile's resize message
 				// which arrives after mobile has set up its output listener
 			}
@@ -230,9 +207,12 @@ func (d *Daemon) handleWebSocketMessages(conn *websocket.Conn) {
 					Name:      msg.MobileName,
 					PublicKey: msg.PublicKey,
 				}
-				// Update local pcConfig with the new mobile
-				d.pcConfig.addPairedMobile(mobile)
-				if err := savePCConfig(d.pcConfig); err != nil {
+				// Paired again: keep its permission
+				if existing := d.currentPairedMobile(msg.MobileID); existing != nil {
+					mobile.ViewOnly = existing.ViewOnly
+				}
+				d.mobilePaired(mobile.ID)
+				if err := d.savePairedMobile(mobile); err != nil {
 					fmt.Printf("%sFailed to save config: %v%s\n", red, err, reset)
 				}
 				// Add encrypted token for this session
@@ -245,55 +225,125 @@ func (d *Daemon) handleWebSocketMessages(conn *websocket.Conn) {
 	}
 }
 
-// sendToMobile sends data to mobile via WebSocket
-func (d *Daemon) sendToMobile(data []byte) {
-	d.mu.RLock()
-	conn := d.wsConn
-	connected := d.mobileConnected && d.relayConnected
-	d.mu.RUnlock()
+// relayMobileConnected starts a new mobile connection on the relay. A mobile
+// connected directly keeps its keys and protocol: the relay only resets its own.
+func (d *Daemon) relayMobileConnected() {
+	// Unpaired meanwhile by another process: rotate the token first
+	d.checkPairings()
+	d.setMobileConnected(true)
+	// A (re)connected mobile announces its protocol in mobile-info
+	if !d.hasLANConn() {
+		d.setMobileProtocol(0, nil)
+	}
+	d.resetMobileKeys(&d.relayKeys)
+}
 
-	if connected && conn != nil {
-		// Encrypt data before sending
-		encrypted, err := d.encrypt(data)
-		if err != nil {
-			// Fallback to unencrypted if encryption fails
-			encrypted = base64.StdEncoding.EncodeToString(data)
-		}
+// decodeMobilePayload decrypts a data frame payload received from mobile.
+// Frames failing decryption are reported as integrity errors: unencrypted
+// frames are never accepted, whoever sends them (the relay could).
+func (d *Daemon) decodeMobilePayload(slot *keySlot, payload string) ([]byte, error) {
+	data, err := d.decrypt(slot, payload)
+	if err != nil {
+		d.reportIntegrityError(err)
+		return nil, err
+	}
+	return data, nil
+}
+
+// handleMobileInput processes decrypted data from mobile (relay or LAN):
+// control messages are dispatched, terminal input is forwarded to the PTY.
+func (d *Daemon) handleMobileInput(data []byte) {
+	// Check for control messages (format: \x00CTRL:command:args)
+	if len(data) > 6 && data[0] == 0x00 && string(data[1:6]) == "CTRL:" {
+		ctrlMsg := string(data[6:])
+		d.handleControlMessage(ctrlMsg)
+		return
+	}
+
+	// A key typed on mobile closes a command panel shown there
+	if d.closeMobilePanel() {
+		d.mobileLineBuf = d.mobileLineBuf[:0]
+		return
+	}
+	// Locked (/lock) or view-only mobile: it only watches
+	if d.mobileInputRefusal() != "" {
+		return
+	}
 
-		msg := Message{
-			Type:    "data",
-			Payload: encrypted,
+	// Switch to mobile dimensions when mobile starts typing
+	d.switchToClient("mobile")
+
+	// Buffer mobile input for command detection
+	for _, char := range data {
+		if char == '\r' || char == '\n' {
+			// Check if it's an AIPilot command
+			if aipilotCmd, arg := d.getAIPilotCommand(string(d.mobileLineBuf)); aipilotCmd != "" {
+				// Clear the line in PTY (Ctrl+U) and don't send Enter
+				d.sendToPTY([]byte{0x15})
+				d.executeAIPilotCommand(aipilotCmd, arg, "mobile")
+				d.mobileLineBuf = d.mobileLineBuf[:0]
+				continue
+			}
+			// Normal Enter - send to PTY
+			d.sendToPTY([]byte{char})
+			d.logPrompt("mobile", string(d.mobileLineBuf))
+			d.mobileLineBuf = d.mobileLineBuf[:0]
+		} else if char == 127 || char == 8 { // Backspace
+			d.mobileLineBuf = trimLastRune(d.mobileLineBuf)
+			d.sendToPTY([]byte{char})
+		} else if char == 3 { // Ctrl+C
+			d.mobileLineBuf = d.mobileLineBuf[:0]
+			d.sendToPTY([]byte{char})
+		} else if char >= 32 { // Printable, UTF-8 included
+			d.mobileLineBuf = append(d.mobileLineBuf, char)
+			d.sendToPTY([]byte{char})
+		} else {
+			// Other chars - pass through
+			d.sendToPTY([]byte{char})
 		}
-		d.wsMu.Lock()
-		conn.WriteJSON(msg)
-		d.wsMu.Unlock()
 	}
 }
 
-// sendControlMessage sends a control message to mobile via the data channel
-// Format: \x00CTRL:message
-func (d *Daemon) sendControlMessage(msg string) {
+// mobileConn returns the connection that reaches mobile, with its session
+// keys: the LAN connection when a mobile is connected directly, otherwise the
+// relay connection. Returns nil when no mobile is connected.
+func (d *Daemon) mobileConn() (*websocket.Conn, *keySlot) {
 	d.mu.RLock()
-	conn := d.wsConn
-	connected := d.mobileConnected && d.relayConnected
-	d.mu.RUnlock()
+	defer d.mu.RUnlock()
 
-	if connected && conn != nil {
-		// Build control message: \x00CTRL:msg
-		ctrlData := append([]byte{0x00}, []byte("CTRL:"+msg)...)
+	if d.lanConn != nil {
+		return d.lanConn, &d.lanKeys
+	}
+	if d.mobileConnected && d.relayConnected {
+		return d.wsConn, &d.relayKeys
+	}
+	return nil, nil
+}
 
-		// Encrypt
-		encrypted, err := d.encrypt(ctrlData)
-		if err != nil {
-			encrypted = base64.StdEncoding.EncodeToString(ctrlData)
-		}
+// sendToMobile sends data to mobile via WebSocket
+func (d *Daemon) sendToMobile(data []byte) {
+	conn, slot := d.mobileConn()
+	if conn == nil {
+		return
+	}
 
-		wsMsg := Message{
-			Type:    "data",
-			Payload: encrypted,
-		}
-		d.wsMu.Lock()
-		conn.WriteJSON(wsMsg)
-		d.wsMu.Unlock()
+	// Encrypt under wsMu: strict frames must leave in counter order
+	d.wsMu.Lock()
+	defer d.wsMu.Unlock()
+	encrypted, err := d.encrypt(slot, data)
+	if err != nil {
+		// Never fall back to plaintext: a frame that can't be encrypted is dropped
+		return
 	}
+	conn.WriteJSON(Message{
+		Type:    "data",
+		Payload: encrypted,
+	})
+}
+
+// sendControlMessage sends a control message to mobile via the data channel
+// Format: \x00CTRL:message
+func (d *Daemon) sendControlMessage(msg string) {
+	// Build control message: \x00CTRL:msg
+	d.sendToMobile(append([]byte{0x00}, []byte("CTRL:"+msg)...))
 }